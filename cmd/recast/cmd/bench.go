@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
+	"github.com/arl/go-detour/sample/solomesh"
+	"github.com/arl/go-detour/sample/tilemesh"
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/spf13/cobra"
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench [OUTFILE]",
+	Short: "benchmark navmesh build and queries",
+	Long: `Build a navigation mesh from input geometry, then run random
+path-finding and raycast queries on it.
+
+The report contains the time spent in each build stage, the peak memory
+usage and the query latencies. It is written in JSON to OUTFILE, or to
+the standard output if OUTFILE is not provided, so that it can easily be
+compared across releases.`,
+	Run: doBench,
+}
+
+var (
+	benchQueriesVal  int
+	benchSeedVal     int64
+	benchMaxNodesVal int
+)
+
+func init() {
+	RootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().StringVar(&cfgVal, "config", "recast.yml", "build settings")
+	benchCmd.Flags().StringVar(&typeVal, "type", "solo", "navmesh type, 'solo' or 'tile'")
+	benchCmd.Flags().StringVar(&inputVal, "input", "", "input geometry file (required)")
+	benchCmd.Flags().IntVar(&benchQueriesVal, "queries", 1000, "number of random queries of each kind")
+	benchCmd.Flags().Int64Var(&benchSeedVal, "seed", 1, "seed of the random query generator")
+	benchCmd.Flags().IntVar(&benchMaxNodesVal, "maxnodes", 2048, "maximum number of search nodes of the navmesh query")
+	addHeightmapFlags(benchCmd)
+}
+
+// benchReport is the machine-readable result of a benchmark run.
+type benchReport struct {
+	Input    string                `json:"input"`
+	Type     string                `json:"type"`
+	Settings recast.BuildSettings  `json:"settings"`
+	Build    buildReport           `json:"build"`
+	NavMesh  navMeshReport         `json:"navmesh"`
+	Memory   memoryReport          `json:"memory"`
+	Queries  map[string]queryStats `json:"queries"`
+}
+
+type buildReport struct {
+	TotalMs  float64            `json:"total_ms"`
+	StagesMs map[string]float64 `json:"stages_ms"`
+}
+
+type navMeshReport struct {
+	Tiles int `json:"tiles"`
+	Polys int `json:"polys"`
+	Verts int `json:"verts"`
+}
+
+type memoryReport struct {
+	PeakHeapBytes   uint64 `json:"peak_heap_bytes"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	SysBytes        uint64 `json:"sys_bytes"`
+	NumGC           uint32 `json:"num_gc"`
+}
+
+type queryStats struct {
+	Count         int     `json:"count"`
+	Failed        int     `json:"failed"`
+	ThroughputQPS float64 `json:"throughput_qps"`
+	MeanUs        float64 `json:"mean_us"`
+	P50Us         float64 `json:"p50_us"`
+	P99Us         float64 `json:"p99_us"`
+}
+
+func doBench(cmd *cobra.Command, args []string) {
+	// check existence of input geometry flags
+	if len(inputVal) == 0 {
+		fmt.Printf("missing input geometry file (--input)")
+		return
+	}
+
+	// unmarshall build settings
+	var cfg recast.BuildSettings
+	err := unmarshalYAMLFile(cfgVal, &cfg)
+	check(err)
+
+	report := benchReport{
+		Input:    inputVal,
+		Type:     typeVal,
+		Settings: cfg,
+		Queries:  make(map[string]queryStats),
+	}
+
+	//
+	// build navmesh, sampling the heap while it's running
+	//
+
+	ctx := recast.NewBuildContext(true)
+	ctx.EnableLog(false)
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	sampler := newHeapSampler(5 * time.Millisecond)
+	start := time.Now()
+	navMesh, times, err := benchBuild(ctx, typeVal, cfg, inputVal)
+	elapsed := time.Since(start)
+	peak := sampler.stop()
+	check(err)
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	report.Memory = memoryReport{
+		PeakHeapBytes:   peak,
+		TotalAllocBytes: after.TotalAlloc - before.TotalAlloc,
+		SysBytes:        after.Sys,
+		NumGC:           after.NumGC - before.NumGC,
+	}
+
+	report.Build.TotalMs = durationMs(elapsed)
+	report.Build.StagesMs = make(map[string]float64)
+	for label, d := range times {
+		if d == 0 || label == recast.TimerTemp {
+			continue
+		}
+		report.Build.StagesMs[label.String()] = durationMs(d)
+	}
+
+	//
+	// run the queries
+	//
+
+	polys := navMeshPolys(navMesh, &report.NavMesh)
+	if len(polys) == 0 {
+		fmt.Println("error, the navmesh doesn't contain any polygon")
+		os.Exit(-1)
+	}
+
+	st, query := detour.NewNavMeshQuery(navMesh, int32(benchMaxNodesVal))
+	if detour.StatusFailed(st) {
+		check(fmt.Errorf("couldn't create navmesh query, %v", st))
+	}
+
+	rnd := rand.New(rand.NewSource(benchSeedVal))
+	pairs := make([][2]benchPoly, benchQueriesVal)
+	for i := range pairs {
+		pairs[i][0] = polys[rnd.Intn(len(polys))]
+		pairs[i][1] = polys[rnd.Intn(len(polys))]
+	}
+
+	// the area costs are those of the build settings area definitions
+	filter := sample.NewQueryFilter(cfg.Areas)
+	path := make([]detour.PolyRef, 256)
+	report.Queries["findpath"] = runQueries(pairs, func(org, dst benchPoly) detour.Status {
+		_, st := query.FindPath(org.ref, dst.ref, org.pos, dst.pos, filter, path)
+		return st
+	})
+	report.Queries["raycast"] = runQueries(pairs, func(org, dst benchPoly) detour.Status {
+		_, st := query.Raycast(org.ref, org.pos, dst.pos, filter, 0, 0)
+		return st
+	})
+
+	//
+	// output report
+	//
+
+	buf, err := json.MarshalIndent(report, "", "  ")
+	check(err)
+	buf = append(buf, '\n')
+
+	if len(args) == 0 {
+		_, err = os.Stdout.Write(buf)
+		check(err)
+		return
+	}
+
+	f, err := os.Create(args[0])
+	check(err)
+	defer f.Close()
+	_, err = f.Write(buf)
+	check(err)
+}
+
+// benchBuild builds the navmesh of the given type and returns it, along with
+// the time spent in each build stage.
+func benchBuild(ctx *recast.BuildContext, typ string, cfg recast.BuildSettings, input string) (*detour.NavMesh, map[recast.TimerLabel]time.Duration, error) {
+	var (
+		err     error
+		navMesh *detour.NavMesh
+		times   map[recast.TimerLabel]time.Duration
+		ok      bool
+	)
+
+	switch typ {
+	case "solo":
+		soloMesh := solomesh.New(ctx)
+		soloMesh.SetSettings(cfg)
+		if err = loadGeometry(soloMesh.InputGeom(), cfg, input); err != nil {
+			return nil, nil, err
+		}
+		navMesh, ok = soloMesh.Build()
+		times = make(map[recast.TimerLabel]time.Duration)
+		for _, label := range recast.TimerLabels() {
+			times[label] = ctx.AccumulatedTime(label)
+		}
+
+	case "tile":
+		tileMesh := tilemesh.New(ctx)
+		tileMesh.SetSettings(cfg)
+		if err = loadGeometry(tileMesh.InputGeom(), cfg, input); err != nil {
+			return nil, nil, err
+		}
+		navMesh, ok = tileMesh.Build()
+		times = tileMesh.BuildTimes()
+
+	default:
+		return nil, nil, fmt.Errorf("unknown (or unimplemented) navmesh type '%v'", typ)
+	}
+
+	if !ok {
+		return nil, nil, fmt.Errorf("couldn't build navmesh for %v", input)
+	}
+	return navMesh, times, nil
+}
+
+// benchPoly is a navmesh polygon used as start or end of a benchmark query.
+type benchPoly struct {
+	ref detour.PolyRef
+	pos d3.Vec3
+}
+
+// navMeshPolys returns all the ground polygons of the navmesh, and fills nr
+// with the navmesh statistics.
+func navMeshPolys(mesh *detour.NavMesh, nr *navMeshReport) []benchPoly {
+	var polys []benchPoly
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		nr.Tiles++
+		nr.Verts += int(tile.Header.VertCount)
+		base := mesh.PolyRefBase(tile)
+		for j := int32(0); j < tile.Header.PolyCount; j++ {
+			p := &tile.Polys[j]
+			nr.Polys++
+			// off-mesh connections only have 2 vertices
+			if p.VertCount < 3 {
+				continue
+			}
+			polys = append(polys, benchPoly{
+				ref: base | detour.PolyRef(j),
+				pos: detour.CalcPolyCenter(p.Verts[:], int32(p.VertCount), tile.Verts),
+			})
+		}
+	}
+	return polys
+}
+
+// runQueries runs fn on each pair of polygons and returns the latency
+// statistics.
+func runQueries(pairs [][2]benchPoly, fn func(org, dst benchPoly) detour.Status) queryStats {
+	var (
+		qs        queryStats
+		latencies = make([]time.Duration, len(pairs))
+		total     time.Duration
+	)
+	for i, p := range pairs {
+		start := time.Now()
+		st := fn(p[0], p[1])
+		latencies[i] = time.Since(start)
+		total += latencies[i]
+		if detour.StatusFailed(st) {
+			qs.Failed++
+		}
+	}
+	qs.Count = len(pairs)
+	if qs.Count == 0 {
+		return qs
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	qs.ThroughputQPS = float64(qs.Count) / total.Seconds()
+	qs.MeanUs = durationUs(total / time.Duration(qs.Count))
+	qs.P50Us = durationUs(percentile(latencies, 50))
+	qs.P99Us = durationUs(percentile(latencies, 99))
+	return qs
+}
+
+// percentile returns the pth percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func durationUs(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// heapSampler periodically samples the heap size in order to keep track of
+// its peak value.
+type heapSampler struct {
+	done chan struct{}
+	peak chan uint64
+}
+
+func newHeapSampler(period time.Duration) *heapSampler {
+	hs := &heapSampler{
+		done: make(chan struct{}),
+		peak: make(chan uint64),
+	}
+	go func() {
+		var (
+			ms   runtime.MemStats
+			peak uint64
+		)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > peak {
+				peak = ms.HeapAlloc
+			}
+			select {
+			case <-hs.done:
+				hs.peak <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return hs
+}
+
+// stop stops the sampling and returns the peak heap size.
+func (hs *heapSampler) stop() uint64 {
+	close(hs.done)
+	return <-hs.peak
+}
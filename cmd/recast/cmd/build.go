@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/arl/go-detour/detour"
 	"github.com/arl/go-detour/recast"
@@ -15,114 +21,578 @@ import (
 var buildCmd = &cobra.Command{
 	Use:   "build OUTFILE",
 	Short: "build navigation mesh from input geometry",
-	Long: `Build a navigation mesh from input geometry in OBJ.
-Build process is controlled by the provided build settings. Generated
-navmesh is saved to OUTFILE in binary format, readable with go-detour
-and/or detour.`,
+	Long: `Build a navigation mesh from input geometry in OBJ, STL, PLY, glTF
+(.gltf or .glb) or heightmap (PNG or RAW16) format, chosen according to
+the extension of the input file. Build process is controlled by the provided build settings. Generated
+navmesh is saved to OUTFILE in binary format, readable with go-detour.
+
+The navmesh file embeds the build metadata: build settings, input file
+and hash, build time and area definitions, shown by 'recast infos'.
+With --from, the build of an existing navmesh file is reproduced from
+its metadata, the flags set on the command line taking precedence.
+With --compress, the navmesh tiles are compressed. --format v1 writes
+the navmesh in the format of detour, without metadata.
+
+With --watch, the input geometry and build settings files are watched
+for modifications and the navmesh is rebuilt each time one of them
+changes. For tiled navmeshes, only the tiles whose input geometry has
+changed are rebuilt and replaced in the navmesh. In both cases, OUTFILE
+is rewritten as a whole after each rebuild.
+
+With --cache, the tiles of a tiled navmesh are stored in a directory,
+and reused by subsequent builds as long as their input geometry and the
+build settings don't change.
+
+With --log-format json, the build log entries are written to stdout as
+JSON objects, one per line, as the build goes. --progress shows the
+progress of tiled builds on stderr and --timings writes the time spent
+in each build stage to a JSON file.
+
+If the build settings define agent profiles, one navmesh is built per
+profile and saved to OUTFILE suffixed with the profile name, for example
+navmesh_human.bin for the profile 'human'.`,
 	Run: doBuild,
 }
 
-var cfgVal, inputVal string
+var (
+	cfgVal, inputVal string
+	forceVal         bool
+	watchVal         bool
+	intervalVal      time.Duration
+	cacheVal         string
+	logFormatVal     string
+	progressVal      bool
+	timingsVal       string
+	fromVal          string
+	formatVal        string
+	compressVal      bool
+
+	// fromSettings are the build settings read with --from, if set.
+	fromSettings *recast.BuildSettings
+
+	hmCellSizeVal  float32
+	hmMaxHeightVal float32
+)
 
 func init() {
 	RootCmd.AddCommand(buildCmd)
 	buildCmd.Flags().StringVar(&cfgVal, "config", "recast.yml", "build settings")
 	buildCmd.Flags().StringVar(&typeVal, "type", "solo", "navmesh type, 'solo' or 'tile'")
-	buildCmd.Flags().StringVar(&inputVal, "input", "", "input geometry OBJ file (required)")
+	buildCmd.Flags().StringVar(&inputVal, "input", "", "input geometry file (required)")
+	buildCmd.Flags().BoolVarP(&forceVal, "force", "f", false, "overwrite OUTFILE without asking for confirmation")
+	buildCmd.Flags().BoolVar(&watchVal, "watch", false, "rebuild the navmesh and rewrite OUTFILE when the input geometry or build settings change")
+	buildCmd.Flags().DurationVar(&intervalVal, "interval", time.Second, "interval between checks for modifications in watch mode")
+	buildCmd.Flags().StringVar(&cacheVal, "cache", "", "directory where built tiles are cached and reused across builds (tile navmesh only)")
+	buildCmd.Flags().StringVar(&logFormatVal, "log-format", "text", "build log format, 'text' or 'json'")
+	buildCmd.Flags().BoolVar(&progressVal, "progress", false, "show the build progress of tiled navmeshes")
+	buildCmd.Flags().StringVar(&timingsVal, "timings", "", "JSON file where to write the build stage timings")
+	buildCmd.Flags().StringVar(&fromVal, "from", "", "reproduce the build of this navmesh file, from its metadata")
+	buildCmd.Flags().StringVar(&formatVal, "format", "v2", "navmesh file format, 'v2' (with metadata) or 'v1' (detour)")
+	buildCmd.Flags().BoolVar(&compressVal, "compress", false, "compress the navmesh tiles (v2 format only)")
+	addHeightmapFlags(buildCmd)
+}
+
+// addHeightmapFlags adds the flags controlling the heightmap input geometry
+// to cmd.
+func addHeightmapFlags(cmd *cobra.Command) {
+	cmd.Flags().Float32Var(&hmCellSizeVal, "hm-cellsize", 1, "distance between 2 heightmap samples")
+	cmd.Flags().Float32Var(&hmMaxHeightVal, "hm-maxheight", 100, "height of the highest heightmap sample")
 }
 
 func doBuild(cmd *cobra.Command, args []string) {
+	if len(fromVal) != 0 {
+		if watchVal {
+			fmt.Println("--watch is not supported with --from")
+			return
+		}
+		cfg, err := buildFrom(fromVal, cmd.Flags().Changed)
+		check(err)
+		fromSettings = &cfg
+	}
+
 	// check existence of input geometry flags
 	if len(inputVal) == 0 {
 		fmt.Printf("missing input geometry file (--input)")
 		return
 	}
 
+	// check output file name
+	out := "navmesh.bin"
+	if len(args) >= 1 {
+		out = args[0]
+	}
+
+	if logFormatVal != "text" && logFormatVal != "json" {
+		fmt.Printf("unknown log format '%v'\n", logFormatVal)
+		return
+	}
+	if formatVal != "v1" && formatVal != "v2" {
+		fmt.Printf("unknown navmesh file format '%v'\n", formatVal)
+		return
+	}
+	if compressVal && formatVal != "v2" {
+		fmt.Println("--compress is only supported by the v2 format")
+		return
+	}
+
+	// unmarshall build settings
+	cfg, err := readSettings(cfgVal)
+	check(err)
+	warnings, _ := cfg.Validate()
+	for _, w := range warnings {
+		fmt.Printf("warning, %v\n", w)
+	}
+	if len(cfg.AgentProfiles) != 0 {
+		if watchVal {
+			fmt.Println("--watch is not supported with agent profiles")
+			return
+		}
+		if len(timingsVal) != 0 {
+			fmt.Println("--timings is not supported with agent profiles")
+			return
+		}
+		buildProfiles(cfg, out)
+		return
+	}
+
+	if !confirmOverwrite(out) {
+		fmt.Println("aborted")
+		return
+	}
+
 	//
 	// build navmesh
 	//
 
-	var (
-		navMesh *detour.NavMesh
-		err     error
-		ok      bool
-	)
-	ctx := recast.NewBuildContext(true)
+	ctx := newBuildContext()
+	b, err := newNavMeshBuilder(typeVal, ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	check(setTileCache(b))
 
-	switch typeVal {
+	// stat the watched files before the build, so that the modifications
+	// saved during the build are detected.
+	var stamps []fileStamp
+	if watchVal {
+		stamps = statFiles(inputVal, cfgVal)
+	}
 
-	case "solo":
+	navMesh, cfg, err := loadAndBuild(b, cfgVal, inputVal)
+	dumpLog(ctx)
+	check(err)
+	if len(timingsVal) != 0 {
+		check(writeBuildTimes(timingsVal, ctx, b))
+	}
 
-		// unmarshall build settings
-		var cfg recast.BuildSettings
-		err = unmarshalYAMLFile(cfgVal, &cfg)
-		check(err)
+	//
+	// save
+	//
 
-		// read input geometry
-		soloMesh := solomesh.New(ctx)
-		var r *os.File
-		r, err = os.Open(inputVal)
-		check(err)
-		defer r.Close()
+	err = saveNavMesh(navMesh, cfg, out)
+	check(err)
 
-		soloMesh.SetSettings(cfg)
-		if err = soloMesh.LoadGeometry(r); err != nil {
-			check(err)
-		}
-		navMesh, ok = soloMesh.Build()
+	fmt.Println("success")
+	fmt.Printf("navmesh written to '%v'\n", out)
 
-	case "tile":
+	if watchVal {
+		watchAndRebuild(ctx, b, cfg, out, stamps)
+	}
+}
 
-		// unmarshall build settings
-		var cfg recast.BuildSettings
-		err = unmarshalYAMLFile(cfgVal, &cfg)
-		check(err)
+// buildProfiles builds and saves one navmesh per agent profile. The navmesh
+// of each profile is saved in a file named after out and the profile name.
+func buildProfiles(cfg recast.BuildSettings, out string) {
+	outs := make([]string, len(cfg.AgentProfiles))
+	names := make(map[string]bool)
+	for i, p := range cfg.AgentProfiles {
+		if len(p.Name) == 0 {
+			check(fmt.Errorf("agent profile %d has no name", i))
+		}
+		if strings.ContainsAny(p.Name, `/\`) {
+			check(fmt.Errorf("agent profile name '%v' contains a path separator", p.Name))
+		}
+		if names[p.Name] {
+			check(fmt.Errorf("duplicate agent profile name '%v'", p.Name))
+		}
+		names[p.Name] = true
+		outs[i] = profileOutput(out, p.Name)
+	}
+	if !confirmOverwrite(outs...) {
+		fmt.Println("aborted")
+		return
+	}
+
+	ctx := newBuildContext()
+	var navMeshes []*detour.NavMesh
 
-		// read input geometry
-		tileMesh := tilemesh.New(ctx)
-		var r *os.File
-		r, err = os.Open(inputVal)
+	switch typeVal {
+	case "solo":
+		// build all the profiles at once in order to share the rasterized
+		// input geometry between them.
+		soloMesh := solomesh.New(ctx)
+		_, err := loadSettingsAndGeometry(soloMesh, cfgVal, inputVal)
 		check(err)
-		defer r.Close()
+		var ok bool
+		navMeshes, ok = soloMesh.BuildProfiles(cfg.AgentProfiles)
+		dumpLog(ctx)
+		if !ok {
+			check(fmt.Errorf("couldn't build navmeshes for %v", inputVal))
+		}
 
-		tileMesh.SetSettings(cfg)
-		if err = tileMesh.LoadGeometry(r); err != nil {
+	case "tile":
+		for _, p := range cfg.AgentProfiles {
+			tileMesh := tilemesh.New(ctx)
+			check(setTileCache(tileMesh))
+			_, err := loadSettingsAndGeometry(tileMesh, cfgVal, inputVal)
 			check(err)
+			tileMesh.SetSettings(cfg.WithProfile(p))
+			navMesh, ok := tileMesh.Build()
+			dumpLog(ctx)
+			if !ok {
+				check(fmt.Errorf("couldn't build navmesh of profile '%v' for %v", p.Name, inputVal))
+			}
+			navMeshes = append(navMeshes, navMesh)
 		}
-		navMesh, ok = tileMesh.Build()
 
 	default:
 		fmt.Printf("unknown (or unimplemented) navmesh type '%v'\n", typeVal)
 		return
 	}
 
-	ctx.DumpLog("")
+	for i, navMesh := range navMeshes {
+		check(saveNavMesh(navMesh, cfg.WithProfile(cfg.AgentProfiles[i]), outs[i]))
+		fmt.Printf("navmesh of profile '%v' written to '%v'\n", cfg.AgentProfiles[i].Name, outs[i])
+	}
+	fmt.Println("success")
+}
 
-	//
-	// save
-	//
+// profileOutput returns the name of the file where to save the navmesh of
+// the named agent profile, that is, out with the profile name appended before
+// the extension.
+func profileOutput(out, profile string) string {
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "_" + profile + ext
+}
 
+// confirmOverwrite asks for confirmation if any of the given files already
+// exists, unless --force is set. It returns true if the files can be written.
+func confirmOverwrite(paths ...string) bool {
+	if forceVal {
+		return true
+	}
+	for _, path := range paths {
+		if err := fileExists(path); err == nil {
+			msg := fmt.Sprintf("'%v' already exists, overwrite? [y/N]", path)
+			if overwrite := askForConfirmation(msg); !overwrite {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// setTileCache sets the tile cache specified with --cache, if any, to the
+// navmesh builder b.
+func setTileCache(b navMeshBuilder) error {
+	if len(cacheVal) == 0 {
+		return nil
+	}
+	tm, ok := b.(*tilemesh.TileMesh)
 	if !ok {
-		fmt.Printf("couldn't build navmesh for %v\n", inputVal)
-		return
+		return fmt.Errorf("--cache is only supported by 'tile' navmeshes")
 	}
+	cache, err := tilemesh.NewDirCache(cacheVal)
+	if err != nil {
+		return err
+	}
+	tm.SetCache(cache)
+	return nil
+}
 
-	// check output file name
-	out := "navmesh.bin"
-	if len(args) >= 1 {
-		out = args[0]
+// newBuildContext returns a build context with logging enabled, writing its
+// entries and the build progress as requested with --log-format and
+// --progress.
+func newBuildContext() *recast.BuildContext {
+	ctx := recast.NewBuildContext(true)
+	if logFormatVal == "json" {
+		ctx.AddLogSink(recast.NewJSONSink(os.Stdout))
 	}
-	if err = fileExists(out); err == nil {
-		msg := fmt.Sprintf("\n'%v' already exists, overwrite? [y/N]", out)
-		if overwrite := askForConfirmation(msg); !overwrite {
-			fmt.Println("aborted")
-			return
+	if progressVal {
+		ctx.SetProgressFunc(printProgress)
+	}
+	return ctx
+}
+
+// dumpLog prints the log entries of ctx, unless they have already been
+// written as JSON.
+func dumpLog(ctx *recast.BuildContext) {
+	if logFormatVal != "json" {
+		ctx.DumpLog("")
+	}
+}
+
+// printProgress shows the build progress p on stderr, as a progress bar.
+func printProgress(p recast.Progress) {
+	const width = 40
+	n := int(p.Percent() * width / 100)
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3.0f%% (%d/%d)",
+		p.Stage, strings.Repeat("=", n), strings.Repeat(" ", width-n), p.Percent(), p.Done, p.Total)
+	if p.Done >= p.Total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// writeBuildTimes writes the time spent in each build stage by the last
+// build of b, in JSON, to the file at path.
+func writeBuildTimes(path string, ctx *recast.BuildContext, b navMeshBuilder) error {
+	times := ctx.BuildTimes()
+	if tm, ok := b.(*tilemesh.TileMesh); ok {
+		// the timers of the build context only measure the last tile
+		times = make(recast.BuildTimes)
+		for label, t := range tm.BuildTimes() {
+			if t != 0 {
+				times[label] = t
+			}
 		}
 	}
+	buf, err := json.MarshalIndent(times, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0644)
+}
 
-	err = navMesh.SaveToFile(out)
-	check(err)
+// navMeshBuilder is the interface implemented by the navmesh builders of the
+// sample packages.
+type navMeshBuilder interface {
+	SetSettings(recast.BuildSettings)
+	InputGeom() *recast.InputGeom
+	Build() (*detour.NavMesh, bool)
+}
 
-	fmt.Println("success")
-	fmt.Printf("navmesh written to '%v'\n", out)
+// newNavMeshBuilder returns the navmesh builder for the navmesh type typ.
+func newNavMeshBuilder(typ string, ctx *recast.BuildContext) (navMeshBuilder, error) {
+	switch typ {
+	case "solo":
+		return solomesh.New(ctx), nil
+	case "tile":
+		return tilemesh.New(ctx), nil
+	}
+	return nil, fmt.Errorf("unknown (or unimplemented) navmesh type '%v'", typ)
+}
+
+// readSettings reads the build settings from the YAML file at cfgPath, or
+// returns those read with --from, if set. An error is returned if the
+// settings are not valid.
+func readSettings(cfgPath string) (recast.BuildSettings, error) {
+	var cfg recast.BuildSettings
+	if fromSettings != nil {
+		cfg = *fromSettings
+	} else if err := unmarshalYAMLFile(cfgPath, &cfg); err != nil {
+		return cfg, err
+	}
+	_, err := cfg.Validate()
+	return cfg, err
+}
+
+// saveNavMesh saves navMesh, built with the build settings cfg, to out, in the
+// format specified with --format.
+func saveNavMesh(navMesh *detour.NavMesh, cfg recast.BuildSettings, out string) error {
+	if formatVal == "v1" {
+		return navMesh.SaveToFile(out)
+	}
+	meta, err := newMetadata(cfg, typeVal, inputVal)
+	if err != nil {
+		return err
+	}
+	opts := detour.SaveOptions{Metadata: meta}
+	if compressVal {
+		opts.Compression = detour.CompressionFlate
+	}
+	return navMesh.SaveToFileWithOptions(out, opts)
+}
+
+// loadSettingsAndGeometry reads the build settings and the input geometry
+// and passes them to the navmesh builder.
+func loadSettingsAndGeometry(b navMeshBuilder, cfgPath, input string) (recast.BuildSettings, error) {
+	// unmarshall build settings
+	cfg, err := readSettings(cfgPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	b.SetSettings(cfg)
+	return cfg, loadGeometry(b.InputGeom(), cfg, input)
+}
+
+// loadGeometry loads the input geometry file into geom, with the mesh loader
+// matching its extension.
+func loadGeometry(geom *recast.InputGeom, cfg recast.BuildSettings, input string) error {
+	ml, err := recast.NewMeshLoader(input)
+	if err != nil {
+		return err
+	}
+	switch ml := ml.(type) {
+	case *recast.MeshLoaderOBJ:
+		ml.MaterialAreas = cfg.MaterialAreas
+	case *recast.MeshLoaderHeightmap:
+		ml.CellSize = hmCellSizeVal
+		ml.MaxHeight = hmMaxHeightVal
+	}
+
+	r, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return geom.LoadMesh(ml, r)
+}
+
+// loadAndBuild loads the build settings and input geometry, then builds the
+// navmesh.
+func loadAndBuild(b navMeshBuilder, cfgPath, input string) (*detour.NavMesh, recast.BuildSettings, error) {
+	cfg, err := loadSettingsAndGeometry(b, cfgPath, input)
+	if err != nil {
+		return nil, cfg, err
+	}
+	navMesh, ok := b.Build()
+	if !ok {
+		return nil, cfg, fmt.Errorf("couldn't build navmesh for %v", input)
+	}
+	return navMesh, cfg, nil
+}
+
+// fileStamp identifies a version of a file, in order to detect modifications.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// statFiles returns the stamps of the files at paths. The stamp of a file
+// which can't be stat'ed is the zero fileStamp.
+func statFiles(paths ...string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		stamps[i], _ = statFile(path)
+	}
+	return stamps
+}
+
+// watchAndRebuild polls the input geometry and build settings files and
+// rebuilds the navmesh, saving it to out, each time one of them changes. It
+// never returns.
+//
+// stamps are the stamps of the input geometry and build settings files, taken
+// before the navmesh has been built from them.
+//
+// For tiled navmeshes, if the build settings and the navmesh bounds are
+// unchanged, only the tiles which input geometry has been modified are
+// rebuilt. Otherwise, or if the previous rebuild failed, the whole navmesh is
+// rebuilt. In both cases the whole navmesh is written to out.
+func watchAndRebuild(ctx *recast.BuildContext, b navMeshBuilder, cfg recast.BuildSettings, out string, stamps []fileStamp) {
+	var hashes map[tilemesh.TileCoord]uint64
+	tm, tiled := b.(*tilemesh.TileMesh)
+	if tiled {
+		hashes = tm.TileHashes()
+	}
+
+	// fullRebuild is set when the last rebuild failed, since the navmesh may
+	// then be partially built, or not match the input geometry anymore.
+	fullRebuild := false
+
+	files := []string{inputVal, cfgVal}
+	fmt.Printf("watching '%v' and '%v' for changes...\n", inputVal, cfgVal)
+	for {
+		time.Sleep(intervalVal)
+
+		modified := false
+		for i, f := range files {
+			st, err := statFile(f)
+			if err != nil {
+				// the file may be in the process of being written
+				continue
+			}
+			if st != stamps[i] {
+				stamps[i] = st
+				modified = true
+			}
+		}
+		if !modified {
+			continue
+		}
+
+		fmt.Println("modification detected, rebuilding navmesh...")
+		start := time.Now()
+		ctx.ResetLog()
+		ctx.ResetTimers()
+
+		var (
+			navMesh *detour.NavMesh
+			newCfg  recast.BuildSettings
+			cur     map[tilemesh.TileCoord]uint64
+			err     error
+		)
+		if tiled {
+			var bmin, bmax [3]float32
+			copy(bmin[:], tm.InputGeom().NavMeshBoundsMin())
+			copy(bmax[:], tm.InputGeom().NavMeshBoundsMax())
+
+			newCfg, err = loadSettingsAndGeometry(tm, cfgVal, inputVal)
+			if err == nil {
+				cur = tm.TileHashes()
+				if !fullRebuild && reflect.DeepEqual(newCfg, cfg) && boundsEqual(tm.InputGeom(), bmin, bmax) {
+					// only rebuild the modified tiles
+					changed := tilemesh.ChangedTiles(hashes, cur)
+					if _, ok := tm.RebuildTiles(changed); !ok {
+						err = fmt.Errorf("couldn't rebuild the modified tiles of %v", inputVal)
+					} else {
+						navMesh = tm.NavMesh()
+						fmt.Printf("%d modified tile(s) rebuilt\n", len(changed))
+					}
+				} else {
+					var ok bool
+					if navMesh, ok = tm.Build(); !ok {
+						err = fmt.Errorf("couldn't build navmesh for %v", inputVal)
+					}
+				}
+			}
+		} else {
+			navMesh, newCfg, err = loadAndBuild(b, cfgVal, inputVal)
+		}
+
+		dumpLog(ctx)
+		if err == nil {
+			err = saveNavMesh(navMesh, newCfg, out)
+		}
+		if err != nil {
+			fmt.Printf("error, %v\n", err)
+			fullRebuild = true
+			continue
+		}
+		// the navmesh is up to date with its input geometry and settings
+		cfg = newCfg
+		hashes = cur
+		fullRebuild = false
+		fmt.Printf("navmesh written to '%v' (%v)\n", out, time.Since(start))
+	}
+}
+
+// boundsEqual reports whether the navmesh bounds of geom are equal to bmin
+// and bmax.
+func boundsEqual(geom *recast.InputGeom, bmin, bmax [3]float32) bool {
+	var curMin, curMax [3]float32
+	copy(curMin[:], geom.NavMeshBoundsMin())
+	copy(curMax[:], geom.NavMeshBoundsMax())
+	return curMin == bmin && curMax == bmax
 }
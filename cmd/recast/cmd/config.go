@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
 	"github.com/arl/go-detour/sample/solomesh"
 	"github.com/arl/go-detour/sample/tilemesh"
 	"github.com/spf13/cobra"
@@ -18,18 +21,30 @@ var configCmd = &cobra.Command{
 	Long: `Write to FILE a build config in YAML format, pre-filled with the
 default settings to build a navmesh of type TYPE.
 
-To use the generated file, call "recast build --cfg FILE".`,
+To use the generated file, call "recast build --cfg FILE".
+
+With --check, FILE is not written but validated: the settings out of
+range are reported as errors and the suspicious ones as warnings.
+
+The build config values derived from the settings, in voxel units, are
+then printed. With --input, the grid size, number of tiles and navmesh
+parameters are printed as well, along with cell and tile sizes suggested
+for the input geometry.`,
 	Run: doConfig,
 }
 
 var (
 	defaultCfgs = make(map[string][]byte)
 	typeVal     string
+	checkVal    bool
 )
 
 func init() {
 	RootCmd.AddCommand(configCmd)
 	configCmd.Flags().StringVar(&typeVal, "type", "solo", "navmesh type, 'solo' or 'tile'")
+	configCmd.Flags().BoolVar(&checkVal, "check", false, "validate the build settings of FILE instead of writing it")
+	configCmd.Flags().StringVar(&inputVal, "input", "", "input geometry file, to derive the grid size and navmesh parameters from")
+	addHeightmapFlags(configCmd)
 
 	// register the default build config for solo mesh
 	if buf, err := yaml.Marshal(solomesh.DefaultSettings()); err != nil {
@@ -67,6 +82,11 @@ func doConfig(cmd *cobra.Command, args []string) {
 	if len(args) >= 1 {
 		path = args[0]
 	}
+
+	if checkVal {
+		printDerived(checkConfig(path))
+		return
+	}
 	if err = fileExists(path); err == nil {
 		msg := fmt.Sprintf("\n'%v' already exists, overwrite? [y/N]", path)
 		if overwrite := askForConfirmation(msg); !overwrite {
@@ -84,4 +104,83 @@ func doConfig(cmd *cobra.Command, args []string) {
 
 	fmt.Printf("success\n")
 	fmt.Printf("build settings for '%v' navmesh generated to '%v'\n", typeVal, path)
+
+	var settings recast.BuildSettings
+	check(yaml.Unmarshal(cfg, &settings))
+	printDerived(settings)
+}
+
+// checkConfig validates the build settings of the file at path, printing
+// the warnings and errors, then returns them. It exits with an error status
+// if the settings are invalid.
+func checkConfig(path string) recast.BuildSettings {
+	var cfg recast.BuildSettings
+	check(unmarshalYAMLFile(path, &cfg))
+
+	warnings, err := cfg.Validate()
+	for _, w := range warnings {
+		fmt.Printf("warning, %v\n", w)
+	}
+	if err != nil {
+		for _, is := range err.(*recast.ValidationError).Issues {
+			fmt.Printf("error, %v\n", is)
+		}
+		os.Exit(-1)
+	}
+	fmt.Printf("'%v' is valid\n", path)
+	return cfg
+}
+
+// printDerived prints the build config values derived from the build
+// settings cfg and, if --input is set, from the bounds of the input
+// geometry.
+func printDerived(cfg recast.BuildSettings) {
+	var (
+		geom       recast.InputGeom
+		bmin, bmax []float32
+	)
+	if len(inputVal) != 0 {
+		geom.SetBuildArea(cfg.BuildBounds, cfg.IncludeBoxes, cfg.ExcludeBoxes)
+		check(loadGeometry(&geom, cfg, inputVal))
+		bmin, bmax = geom.NavMeshBoundsMin(), geom.NavMeshBoundsMax()
+	} else {
+		bmin, bmax = make([]float32, 3), make([]float32, 3)
+	}
+	if typeVal != "tile" {
+		cfg.TileSize = 0
+	}
+	d := sample.DeriveConfig(cfg, bmin, bmax)
+	c := d.Config
+
+	fmt.Println("derived config:")
+	fmt.Printf("  walkable height:    %d vx\n", c.WalkableHeight)
+	fmt.Printf("  walkable climb:     %d vx\n", c.WalkableClimb)
+	fmt.Printf("  walkable radius:    %d vx\n", c.WalkableRadius)
+	fmt.Printf("  max edge length:    %d vx\n", c.MaxEdgeLen)
+	fmt.Printf("  min region area:    %d vx\n", c.MinRegionArea)
+	fmt.Printf("  merge region area:  %d vx\n", c.MergeRegionArea)
+	fmt.Printf("  detail sample dist: %v wu\n", c.DetailSampleDist)
+	fmt.Printf("  detail max error:   %v wu\n", c.DetailSampleMaxError)
+	if c.TileSize != 0 {
+		fmt.Printf("  tile size:          %d vx (%v wu)\n", c.TileSize, float32(c.TileSize)*c.Cs)
+		fmt.Printf("  border size:        %d vx\n", c.BorderSize)
+	}
+	if len(inputVal) == 0 {
+		return
+	}
+
+	fmt.Printf("  grid size:          %d x %d cells\n", d.GridWidth, d.GridHeight)
+	if c.TileSize != 0 {
+		fmt.Printf("  tiles:              %d x %d\n", d.TilesX, d.TilesZ)
+		fmt.Printf("  reference bits:     %d tile, %d poly\n", d.TileBits, d.PolyBits)
+		buf, err := json.MarshalIndent(d.NavMeshParams, "  ", "  ")
+		check(err)
+		fmt.Printf("navmesh params:\n  %s\n", buf)
+	}
+
+	sug := sample.SuggestSettings(cfg.AgentRadius, bmin, bmax)
+	fmt.Println("suggested settings:")
+	fmt.Printf("  cellsize:   %v\n", sug.CellSize)
+	fmt.Printf("  cellheight: %v\n", sug.CellHeight)
+	fmt.Printf("  tilesize:   %v\n", sug.TileSize)
 }
@@ -14,7 +14,10 @@ var infosCmd = &cobra.Command{
 	Use:   "infos NAVMESH",
 	Short: "show infos about a navmesh",
 	Long: `Read a navigation mesh from binary file, check the data
-for consistency then print informations on standard output.`,
+for consistency then print informations on standard output.
+
+The build metadata, such as the build settings and input geometry, are
+printed as well if the navmesh file has some.`,
 	Run: doInfos,
 }
 
@@ -50,5 +53,12 @@ func doInfos(cmd *cobra.Command, args []string) {
 	buf, err = json.MarshalIndent(navmesh.Params, "", "  ")
 	check(err)
 	fmt.Printf("successfully loaded '%v'\n", binMesh)
-	fmt.Printf("'%v' navmesh infos:\n%s", typeVal, string(buf))
+	fmt.Printf("'%v' navmesh infos:\n%s\n", typeVal, string(buf))
+
+	// show build metadata, if any
+	if meta, err := readMetadata(binMesh); err == nil {
+		printMetadata(meta)
+	} else {
+		fmt.Println("no build metadata")
+	}
 }
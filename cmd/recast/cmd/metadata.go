@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
+)
+
+// Keys of the metadata Extra map written by the build command.
+const (
+	metaType        = "type"
+	metaHmCellSize  = "hm-cellsize"
+	metaHmMaxHeight = "hm-maxheight"
+)
+
+// hashFile returns the hex encoded SHA-256 of the content of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newMetadata returns the metadata describing the build of a navmesh of
+// type typ, with the build settings cfg, from the input geometry file input.
+func newMetadata(cfg recast.BuildSettings, typ, input string) (*detour.NavMeshMetadata, error) {
+	settings, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashFile(input)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(input); err == nil {
+		input = abs
+	}
+
+	areas := cfg.Areas
+	if len(areas) == 0 {
+		areas = sample.DefaultAreas()
+	}
+
+	meta := &detour.NavMeshMetadata{
+		AgentHeight:   cfg.AgentHeight,
+		AgentRadius:   cfg.AgentRadius,
+		AgentMaxClimb: cfg.AgentMaxClimb,
+		Source:        input,
+		SourceHash:    hash,
+		BuildTime:     time.Now().UTC(),
+		Generator:     "recast " + Version,
+		Settings:      string(settings),
+		Extra: map[string]string{
+			metaType:        typ,
+			metaHmCellSize:  strconv.FormatFloat(float64(hmCellSizeVal), 'g', -1, 32),
+			metaHmMaxHeight: strconv.FormatFloat(float64(hmMaxHeightVal), 'g', -1, 32),
+		},
+	}
+	for _, a := range areas {
+		meta.Areas = append(meta.Areas, detour.AreaMetadata{
+			Name:  a.Name,
+			ID:    a.ID,
+			Flags: a.Flags,
+			Cost:  a.Cost,
+		})
+	}
+	return meta, nil
+}
+
+// readMetadata returns the metadata of the navmesh file at path.
+func readMetadata(path string) (*detour.NavMeshMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sr, err := detour.NewNavMeshSetReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("'%v' has no build metadata: %v", path, err)
+	}
+	if sr.Metadata() == nil {
+		return nil, fmt.Errorf("'%v' has no build metadata", path)
+	}
+	return sr.Metadata(), nil
+}
+
+// buildFrom sets up the build to reproduce the build of the navmesh file at
+// path, from its metadata: the build settings, navmesh type and heightmap
+// parameters are those of the metadata. The input geometry is the one of the
+// metadata, unless --input is set, and its content is checked against the
+// recorded hash.
+//
+// Flags explicitly set on the command line take precedence over the
+// metadata.
+func buildFrom(path string, changed func(flag string) bool) (recast.BuildSettings, error) {
+	var cfg recast.BuildSettings
+	meta, err := readMetadata(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err = yaml.Unmarshal([]byte(meta.Settings), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid build settings in '%v': %v", path, err)
+	}
+
+	if !changed("input") {
+		inputVal = meta.Source
+	}
+	if typ, ok := meta.Extra[metaType]; ok && !changed("type") {
+		typeVal = typ
+	}
+	parseFloat := func(key, flag string, dst *float32) error {
+		s, ok := meta.Extra[key]
+		if !ok || changed(flag) {
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %v in '%v': %v", key, path, err)
+		}
+		*dst = float32(f)
+		return nil
+	}
+	if err = parseFloat(metaHmCellSize, "hm-cellsize", &hmCellSizeVal); err != nil {
+		return cfg, err
+	}
+	if err = parseFloat(metaHmMaxHeight, "hm-maxheight", &hmMaxHeightVal); err != nil {
+		return cfg, err
+	}
+
+	hash, err := hashFile(inputVal)
+	if err != nil {
+		return cfg, err
+	}
+	if hash != meta.SourceHash {
+		fmt.Printf("warning, '%v' differs from the input geometry '%v' was built from\n", inputVal, path)
+	}
+	return cfg, nil
+}
+
+// printMetadata prints the navmesh metadata meta on standard output.
+func printMetadata(meta *detour.NavMeshMetadata) {
+	fmt.Println("build metadata:")
+	fmt.Printf("  generator:   %v\n", meta.Generator)
+	fmt.Printf("  build time:  %v\n", meta.BuildTime.Format(time.RFC3339))
+	fmt.Printf("  input:       %v\n", meta.Source)
+	fmt.Printf("  input hash:  %v\n", meta.SourceHash)
+	if typ, ok := meta.Extra[metaType]; ok {
+		fmt.Printf("  type:        %v\n", typ)
+	}
+	fmt.Printf("  agent:       height=%v radius=%v maxclimb=%v\n", meta.AgentHeight, meta.AgentRadius, meta.AgentMaxClimb)
+	if len(meta.Areas) != 0 {
+		fmt.Println("  areas:")
+		for _, a := range meta.Areas {
+			fmt.Printf("    %-10s id=%-3d flags=0x%04x cost=%v\n", a.Name, a.ID, a.Flags, a.Cost)
+		}
+	}
+	if len(meta.Settings) != 0 {
+		fmt.Println("build settings:")
+		fmt.Print(meta.Settings)
+	}
+}
@@ -9,6 +9,10 @@ import (
 
 var cfgFile string
 
+// Version is the version of the command, recorded in the navmeshes it builds.
+// It can be set at build time with -ldflags "-X <package path>.Version=X".
+var Version = "devel"
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "recast",
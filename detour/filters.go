@@ -0,0 +1,355 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// The filters of this file can be composed: the decorating filters
+// (RefFilter, RegionCostFilter, DangerFilter and HeightPenaltyFilter) wrap a
+// base filter to which they delegate, and can themselves be the base of
+// another filter, while AndFilter and OrFilter combine any number of filters.
+//
+// For example, a filter avoiding a set of polygons as well as the areas
+// under enemy fire:
+//
+//  danger := NewDangerFilter(NewStandardQueryFilter())
+//  danger.AddCircle(enemyPos, 10, 5)
+//  filter := NewBlacklistFilter(danger, blockedRefs...)
+
+// AndFilter is a query filter that combines several filters.
+//
+// A polygon passes the filter if it passes all the combined filters. The
+// cost of a segment is the highest of the costs given by the combined
+// filters.
+type AndFilter []QueryFilter
+
+// PassFilter implements the QueryFilter interface.
+func (f AndFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	for _, qf := range f {
+		if !qf.PassFilter(ref, tile, poly) {
+			return false
+		}
+	}
+	return true
+}
+
+// Cost implements the QueryFilter interface.
+func (f AndFilter) Cost(pa, pb d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
+	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
+
+	var cost float32
+	for i, qf := range f {
+		c := qf.Cost(pa, pb, prevRef, prevTile, prevPoly, curRef, curTile, curPoly, nextRef, nextTile, nextPoly)
+		if i == 0 || c > cost {
+			cost = c
+		}
+	}
+	return cost
+}
+
+// OrFilter is a query filter that combines several filters.
+//
+// A polygon passes the filter if it passes at least one of the combined
+// filters. The cost of a segment is the lowest of the costs given by the
+// combined filters that the current polygon passes.
+type OrFilter []QueryFilter
+
+// PassFilter implements the QueryFilter interface.
+func (f OrFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	for _, qf := range f {
+		if qf.PassFilter(ref, tile, poly) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cost implements the QueryFilter interface.
+func (f OrFilter) Cost(pa, pb d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
+	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
+
+	var (
+		cost  float32
+		found bool
+	)
+	for _, qf := range f {
+		if !qf.PassFilter(curRef, curTile, curPoly) {
+			continue
+		}
+		c := qf.Cost(pa, pb, prevRef, prevTile, prevPoly, curRef, curTile, curPoly, nextRef, nextTile, nextPoly)
+		if !found || c < cost {
+			cost = c
+			found = true
+		}
+	}
+	if !found {
+		// the query only computes the cost of polygons passing the filter,
+		// fallback to the segment length.
+		return pa.Dist(pb)
+	}
+	return cost
+}
+
+// RefFilter is a query filter that rejects, or only accepts, a set of
+// polygons, the other polygons being filtered by its base filter.
+type RefFilter struct {
+	QueryFilter // base filter
+
+	refs      map[PolyRef]struct{}
+	whitelist bool
+}
+
+// NewBlacklistFilter returns a filter rejecting the polygons refs, and
+// delegating to base for the other polygons.
+func NewBlacklistFilter(base QueryFilter, refs ...PolyRef) *RefFilter {
+	f := &RefFilter{QueryFilter: base, refs: make(map[PolyRef]struct{})}
+	f.Add(refs...)
+	return f
+}
+
+// NewWhitelistFilter returns a filter rejecting all polygons but refs, which
+// are then filtered by base.
+func NewWhitelistFilter(base QueryFilter, refs ...PolyRef) *RefFilter {
+	f := NewBlacklistFilter(base, refs...)
+	f.whitelist = true
+	return f
+}
+
+// Add adds polygons to the set of polygons of the filter.
+func (f *RefFilter) Add(refs ...PolyRef) {
+	for _, ref := range refs {
+		f.refs[ref] = struct{}{}
+	}
+}
+
+// Remove removes polygons from the set of polygons of the filter.
+func (f *RefFilter) Remove(refs ...PolyRef) {
+	for _, ref := range refs {
+		delete(f.refs, ref)
+	}
+}
+
+// Contains reports whether ref is in the set of polygons of the filter.
+func (f *RefFilter) Contains(ref PolyRef) bool {
+	_, ok := f.refs[ref]
+	return ok
+}
+
+// PassFilter implements the QueryFilter interface.
+func (f *RefFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	if _, ok := f.refs[ref]; ok == f.whitelist {
+		return f.QueryFilter.PassFilter(ref, tile, poly)
+	}
+	return false
+}
+
+// RegionCostFilter is a query filter that overrides the cost of the segments
+// which middle point is inside axis-aligned boxes. The other segments cost is
+// given by its base filter.
+//
+// In order for A* searches to work properly, the cost multipliers should not
+// be less than 1.
+type RegionCostFilter struct {
+	QueryFilter // base filter
+
+	boxes  []costBox
+	nextID int
+}
+
+type costBox struct {
+	id         int
+	bmin, bmax [3]float32
+	cost       float32
+}
+
+// NewRegionCostFilter returns a region cost filter delegating to base.
+func NewRegionCostFilter(base QueryFilter) *RegionCostFilter {
+	return &RegionCostFilter{QueryFilter: base}
+}
+
+// AddBox adds a box in which the cost of a segment is its length multiplied
+// by cost. If the boxes overlap, the last added box has precedence. It returns
+// the identifier of the box, to use with RemoveBox.
+func (f *RegionCostFilter) AddBox(bmin, bmax d3.Vec3, cost float32) int {
+	b := costBox{id: f.nextID, cost: cost}
+	copy(b.bmin[:], bmin)
+	copy(b.bmax[:], bmax)
+	f.nextID++
+	f.boxes = append(f.boxes, b)
+	return b.id
+}
+
+// RemoveBox removes the box which identifier is id. It returns false if
+// there is no such box.
+func (f *RegionCostFilter) RemoveBox(id int) bool {
+	for i := range f.boxes {
+		if f.boxes[i].id == id {
+			f.boxes = append(f.boxes[:i], f.boxes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear removes all the boxes.
+func (f *RegionCostFilter) Clear() {
+	f.boxes = f.boxes[:0]
+}
+
+// Cost implements the QueryFilter interface.
+func (f *RegionCostFilter) Cost(pa, pb d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
+	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
+
+	mid := [3]float32{(pa[0] + pb[0]) * 0.5, (pa[1] + pb[1]) * 0.5, (pa[2] + pb[2]) * 0.5}
+	for i := len(f.boxes) - 1; i >= 0; i-- {
+		b := &f.boxes[i]
+		if mid[0] >= b.bmin[0] && mid[0] <= b.bmax[0] &&
+			mid[1] >= b.bmin[1] && mid[1] <= b.bmax[1] &&
+			mid[2] >= b.bmin[2] && mid[2] <= b.bmax[2] {
+			return pa.Dist(pb) * b.cost
+		}
+	}
+	return f.QueryFilter.Cost(pa, pb, prevRef, prevTile, prevPoly, curRef, curTile, curPoly, nextRef, nextTile, nextPoly)
+}
+
+// DangerFilter is a query filter that adds cost to the segments crossing
+// circles of influence, in the xz-plane, on top of the cost given by its base
+// filter.
+//
+// The added cost of a circle is the length of the part of the segment inside
+// the circle multiplied by the circle cost, so that paths go around the
+// dangerous zones when possible, without excluding them.
+type DangerFilter struct {
+	QueryFilter // base filter
+
+	circles []dangerCircle
+	nextID  int
+}
+
+type dangerCircle struct {
+	id     int
+	x, z   float32
+	radius float32
+	cost   float32
+}
+
+// NewDangerFilter returns a danger filter delegating to base.
+func NewDangerFilter(base QueryFilter) *DangerFilter {
+	return &DangerFilter{QueryFilter: base}
+}
+
+// AddCircle adds a circle of influence, centered at center, and returns its
+// identifier, to use with RemoveCircle.
+func (f *DangerFilter) AddCircle(center d3.Vec3, radius, cost float32) int {
+	f.circles = append(f.circles, dangerCircle{
+		id:     f.nextID,
+		x:      center[0],
+		z:      center[2],
+		radius: radius,
+		cost:   cost,
+	})
+	f.nextID++
+	return f.nextID - 1
+}
+
+// RemoveCircle removes the circle which identifier is id. It returns false if
+// there is no such circle.
+func (f *DangerFilter) RemoveCircle(id int) bool {
+	for i := range f.circles {
+		if f.circles[i].id == id {
+			f.circles = append(f.circles[:i], f.circles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear removes all the circles.
+func (f *DangerFilter) Clear() {
+	f.circles = f.circles[:0]
+}
+
+// Cost implements the QueryFilter interface.
+func (f *DangerFilter) Cost(pa, pb d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
+	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
+
+	cost := f.QueryFilter.Cost(pa, pb, prevRef, prevTile, prevPoly, curRef, curTile, curPoly, nextRef, nextTile, nextPoly)
+	if len(f.circles) == 0 {
+		return cost
+	}
+	dx, dz := pb[0]-pa[0], pb[2]-pa[2]
+	seglen := math32.Sqrt(dx*dx + dz*dz)
+	for i := range f.circles {
+		c := &f.circles[i]
+		cost += c.cost * seglen * segmentInCircle(pa[0]-c.x, pa[2]-c.z, dx, dz, c.radius)
+	}
+	return cost
+}
+
+// segmentInCircle returns the fraction of the 2D segment starting at (px, py)
+// with direction (dx, dy) that is inside the circle of radius r centered at
+// the origin.
+func segmentInCircle(px, py, dx, dy, r float32) float32 {
+	a := dx*dx + dy*dy
+	c := px*px + py*py - r*r
+	if a < 1e-12 {
+		// degenerated segment
+		if c <= 0 {
+			return 1
+		}
+		return 0
+	}
+	b := px*dx + py*dy
+	disc := b*b - a*c
+	if disc <= 0 {
+		return 0
+	}
+	s := math32.Sqrt(disc)
+	t0 := math32.Max((-b-s)/a, 0)
+	t1 := math32.Min((-b+s)/a, 1)
+	if t1 <= t0 {
+		return 0
+	}
+	return t1 - t0
+}
+
+// HeightPenaltyFilter is a query filter that adds cost to the segments going
+// up or down, on top of the cost given by its base filter.
+type HeightPenaltyFilter struct {
+	QueryFilter // base filter
+
+	// Climb is the cost added per unit of height climbed.
+	Climb float32
+
+	// Descent is the cost added per unit of height descended.
+	Descent float32
+}
+
+// NewHeightPenaltyFilter returns a height penalty filter delegating to base.
+func NewHeightPenaltyFilter(base QueryFilter, climb, descent float32) *HeightPenaltyFilter {
+	return &HeightPenaltyFilter{QueryFilter: base, Climb: climb, Descent: descent}
+}
+
+// Cost implements the QueryFilter interface.
+func (f *HeightPenaltyFilter) Cost(pa, pb d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
+	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
+
+	cost := f.QueryFilter.Cost(pa, pb, prevRef, prevTile, prevPoly, curRef, curTile, curPoly, nextRef, nextTile, nextPoly)
+	if dy := pb[1] - pa[1]; dy > 0 {
+		cost += dy * f.Climb
+	} else {
+		cost -= dy * f.Descent
+	}
+	return cost
+}
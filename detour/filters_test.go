@@ -0,0 +1,140 @@
+package detour
+
+import (
+	"math"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// segmentCost returns the cost of the segment from pa to pb in the polygon
+// of ref, according to filter.
+func segmentCost(filter QueryFilter, pa, pb d3.Vec3, ref PolyRef) float32 {
+	poly := &Poly{}
+	return filter.Cost(pa, pb, 0, nil, nil, ref, nil, poly, 0, nil, nil)
+}
+
+func TestRefFilters(t *testing.T) {
+	poly := &Poly{Flags: 1}
+	base := NewStandardQueryFilter()
+
+	black := NewBlacklistFilter(base, 1, 2)
+	white := NewWhitelistFilter(base, 2, 3)
+	black.Remove(2)
+
+	tests := []struct {
+		filter QueryFilter
+		pass   [4]bool // for refs 1 to 4
+	}{
+		{black, [4]bool{false, true, true, true}},
+		{white, [4]bool{false, true, true, false}},
+		{AndFilter{black, white}, [4]bool{false, true, true, false}},
+		{OrFilter{NewWhitelistFilter(base, 1), NewWhitelistFilter(base, 4)}, [4]bool{true, false, false, true}},
+	}
+	for i, tt := range tests {
+		for j, want := range tt.pass {
+			ref := PolyRef(j + 1)
+			if got := tt.filter.PassFilter(ref, nil, poly); got != want {
+				t.Errorf("filter %d: PassFilter(%v) = %v, want %v", i, ref, got, want)
+			}
+		}
+	}
+
+	// polygons without flags are still rejected by the base filter
+	if white.PassFilter(2, nil, &Poly{}) {
+		t.Errorf("polygon without flags should not pass")
+	}
+}
+
+func TestCostFilters(t *testing.T) {
+	base := NewStandardQueryFilter()
+	pa, pb := d3.Vec3{0, 0, 0}, d3.Vec3{10, 0, 0}
+
+	approx := func(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-4 }
+
+	region := NewRegionCostFilter(base)
+	region.AddBox(d3.Vec3{-1, -1, -1}, d3.Vec3{20, 1, 1}, 3)
+	id := region.AddBox(d3.Vec3{4, -1, -1}, d3.Vec3{6, 1, 1}, 5)
+	if c := segmentCost(region, pa, pb, 1); !approx(c, 50) {
+		t.Errorf("region cost = %v, want 50", c)
+	}
+	region.RemoveBox(id)
+	if c := segmentCost(region, pa, pb, 1); !approx(c, 30) {
+		t.Errorf("region cost = %v, want 30", c)
+	}
+	if c := segmentCost(region, d3.Vec3{0, 0, 5}, d3.Vec3{10, 0, 5}, 1); !approx(c, 10) {
+		t.Errorf("cost outside region = %v, want 10", c)
+	}
+
+	// the segment crosses the circle on a length of 4
+	danger := NewDangerFilter(base)
+	danger.AddCircle(d3.Vec3{5, 100, 0}, 2, 2)
+	if c := segmentCost(danger, pa, pb, 1); !approx(c, 10+4*2) {
+		t.Errorf("danger cost = %v, want 18", c)
+	}
+	// circle containing the segment end
+	id = danger.AddCircle(d3.Vec3{10, 0, 0}, 1, 1)
+	if c := segmentCost(danger, pa, pb, 1); !approx(c, 10+4*2+1) {
+		t.Errorf("danger cost = %v, want 19", c)
+	}
+	danger.RemoveCircle(id)
+
+	height := NewHeightPenaltyFilter(danger, 3, 1)
+	if c := segmentCost(height, pa, d3.Vec3{10, 2, 0}, 1); !approx(c, pa.Dist(d3.Vec3{10, 2, 0})+8+6) {
+		t.Errorf("climb cost = %v", c)
+	}
+	if c := segmentCost(height, pa, d3.Vec3{10, -2, 0}, 1); !approx(c, pa.Dist(d3.Vec3{10, -2, 0})+8+2) {
+		t.Errorf("descent cost = %v", c)
+	}
+
+	and := AndFilter{base, region}
+	or := OrFilter{base, region}
+	if c := segmentCost(and, pa, pb, 1); !approx(c, 30) {
+		t.Errorf("and cost = %v, want 30", c)
+	}
+	poly := &Poly{Flags: 1}
+	if c := or.Cost(pa, pb, 0, nil, nil, 1, nil, poly, 0, nil, nil); !approx(c, 10) {
+		t.Errorf("or cost = %v, want 10", c)
+	}
+}
+
+func TestBlacklistFilterPath(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.Vec3{2, 4, 2}
+	base := NewStandardQueryFilter()
+	var orgRef, dstRef PolyRef
+	st, orgRef, org = query.FindNearestPoly(org, extents, base)
+	if StatusFailed(st) {
+		t.Fatalf("couldn't find nearest poly of %v, status: 0x%x\n", org, st)
+	}
+	st, dstRef, dst = query.FindNearestPoly(dst, extents, base)
+	if StatusFailed(st) {
+		t.Fatalf("couldn't find nearest poly of %v, status: 0x%x\n", dst, st)
+	}
+
+	path := make([]PolyRef, 256)
+	n, st := query.FindPath(orgRef, dstRef, org, dst, base, path)
+	if StatusFailed(st) || n < 3 {
+		t.Fatalf("FindPath failed, status 0x%x, %d polygons", st, n)
+	}
+
+	// blacklist the polygons of the path, but its ends
+	black := NewBlacklistFilter(base, path[1:n-1]...)
+	n2, st := query.FindPath(orgRef, dstRef, org, dst, black, path)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed, status 0x%x", st)
+	}
+	for _, ref := range path[:n2] {
+		if black.Contains(ref) {
+			t.Fatalf("path goes through blacklisted polygon %v", ref)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// FlowField stores, for each polygon of a navigation mesh, the cost to reach
+// a common goal and the neighbour polygon to go through to get there.
+//
+// It is computed once for a goal, with a Dijkstra expansion of the polygon
+// graph from the goal, following the links backward, after which any number
+// of agents can sample their steering direction from it, which suits group
+// movement where many agents head to the same place.
+//
+// The field is computed with the node pool of a NavMeshQuery, the number of
+// polygons it can reach is thus limited by the maximum number of nodes of the
+// query. The query should not be used concurrently with the flow field.
+//
+// A FlowField observes its navigation mesh. When tiles are added or removed,
+// only the polygons which cost may have changed are computed again, the next
+// time the field is sampled or Update is called.
+type FlowField struct {
+	// MaxCost is the cost above which polygons are not expanded anymore, 0
+	// meaning no limit.
+	MaxCost float32
+
+	query   *NavMeshQuery
+	filter  QueryFilter
+	goalRef PolyRef
+	goalPos d3.Vec3
+	cells   map[PolyRef]flowCell
+	pending []PolyRef // polygons around which the field must be repaired.
+}
+
+// flowCell is the flow field data of a polygon.
+type flowCell struct {
+	cost float32
+	next PolyRef // neighbour polygon toward the goal, 0 for the goal.
+	pos  d3.Vec3 // mid point of the portal toward next, or the goal.
+}
+
+// NewFlowField creates an empty flow field on the navigation mesh of query,
+// and registers it as a tile observer of this navigation mesh.
+//
+// see FlowField.SetGoal, FlowField.Close
+func NewFlowField(query *NavMeshQuery, filter QueryFilter) *FlowField {
+	f := &FlowField{
+		query:  query,
+		filter: filter,
+		cells:  make(map[PolyRef]flowCell),
+	}
+	query.nav.AddTileObserver(f)
+	return f
+}
+
+// Close unregisters the flow field from its navigation mesh, after which the
+// field doesn't get updated anymore.
+func (f *FlowField) Close() {
+	f.query.nav.RemoveTileObserver(f)
+}
+
+// SetGoal computes the flow field toward the goal position pos, within the
+// polygon ref.
+//
+// The returned status has the OutOfNodes flag set if the field doesn't cover
+// all the polygons that can reach the goal.
+func (f *FlowField) SetGoal(ref PolyRef, pos d3.Vec3) Status {
+	if !f.query.nav.IsValidPolyRef(ref) || len(pos) < 3 {
+		return Failure | InvalidParam
+	}
+	f.goalRef = ref
+	f.goalPos = d3.NewVec3From(pos)
+	f.cells = make(map[PolyRef]flowCell)
+	f.pending = f.pending[:0]
+	f.cells[ref] = flowCell{pos: f.goalPos}
+	return f.propagate([]PolyRef{ref})
+}
+
+// Goal returns the goal polygon and position of the flow field. ref is 0 if
+// the field has no goal, or if the goal polygon has been removed.
+func (f *FlowField) Goal() (ref PolyRef, pos d3.Vec3) {
+	return f.goalRef, f.goalPos
+}
+
+// Cost returns the cost to reach the goal from the polygon ref. It returns
+// false if the goal can't be reached from ref.
+func (f *FlowField) Cost(ref PolyRef) (float32, bool) {
+	f.Update()
+	c, ok := f.cells[ref]
+	return c.cost, ok
+}
+
+// Next returns the neighbour of the polygon ref to go through in order to
+// reach the goal. It returns 0 if ref is the goal polygon or if the goal
+// can't be reached from ref.
+func (f *FlowField) Next(ref PolyRef) PolyRef {
+	f.Update()
+	return f.cells[ref].next
+}
+
+// Direction computes the steering direction of an agent at position pos,
+// within the polygon ref.
+//
+// The direction, stored in dir, is the normalized direction on the xz-plane
+// toward the portal leading to the next polygon, or toward the goal position
+// in the goal polygon. The status has the Failure flag set if the goal can't
+// be reached from ref.
+func (f *FlowField) Direction(ref PolyRef, pos, dir d3.Vec3) Status {
+	f.Update()
+	cell, ok := f.cells[ref]
+	if !ok {
+		return Failure | InvalidParam
+	}
+
+	target := d3.NewVec3From(cell.pos)
+	if cell.next != 0 {
+		// Head for the nearest point of the portal, away from its ends as
+		// they lie on walls.
+		var fromType, toType uint8
+		left, right := d3.NewVec3(), d3.NewVec3()
+		if StatusSucceed(f.query.portalPoints6(ref, cell.next, left, right, &fromType, &toType)) {
+			var t float32
+			distancePtSegSqr2D(pos, left, right, &t)
+			t = math32.Max(0.1, math32.Min(t, 0.9))
+			d3.Vec3Lerp(target, left, right, t)
+		}
+	}
+
+	dir[0] = target[0] - pos[0]
+	dir[1] = 0
+	dir[2] = target[2] - pos[2]
+	if dir[0] != 0 || dir[2] != 0 {
+		dir.Normalize()
+	}
+	return Success
+}
+
+// Update repairs the flow field after tiles have been added to, or removed
+// from, the navigation mesh. It is automatically called when the field is
+// sampled.
+func (f *FlowField) Update() Status {
+	if len(f.pending) == 0 {
+		return Success
+	}
+
+	// Seed the expansion with the polygons of the field around the polygons
+	// which cost is unknown.
+	var (
+		seeds  []PolyRef
+		seeded = make(map[PolyRef]bool)
+	)
+	nav := f.query.nav
+	for _, ref := range f.pending {
+		if !nav.IsValidPolyRef(ref) {
+			continue
+		}
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		nav.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		for i := poly.FirstLink; i != nullLink; i = tile.Links[i].Next {
+			nei := tile.Links[i].Ref
+			if _, ok := f.cells[nei]; ok && !seeded[nei] {
+				seeded[nei] = true
+				seeds = append(seeds, nei)
+			}
+		}
+	}
+	f.pending = f.pending[:0]
+	return f.propagate(seeds)
+}
+
+// TileAdded implements the TileObserver interface.
+func (f *FlowField) TileAdded(m *NavMesh, tile *MeshTile) {
+	if f.goalRef == 0 {
+		return
+	}
+	base := m.PolyRefBase(tile)
+	for i := int32(0); i < tile.Header.PolyCount; i++ {
+		f.pending = append(f.pending, base|PolyRef(i))
+	}
+}
+
+// TileRemoved implements the TileObserver interface.
+func (f *FlowField) TileRemoved(m *NavMesh, tile *MeshTile) {
+	if f.goalRef == 0 {
+		return
+	}
+	base := m.PolyRefBase(tile)
+	if m.decodePolyIDTile(f.goalRef) == m.decodePolyIDTile(base) {
+		// The goal is gone.
+		f.goalRef = 0
+		f.cells = make(map[PolyRef]flowCell)
+		f.pending = f.pending[:0]
+		return
+	}
+
+	// Invalidate the polygons of the tile and all the polygons which route
+	// toward the goal goes through them.
+	invalid := make(map[PolyRef]bool)
+	for i := int32(0); i < tile.Header.PolyCount; i++ {
+		invalid[base|PolyRef(i)] = true
+	}
+	var isInvalid func(ref PolyRef) bool
+	isInvalid = func(ref PolyRef) bool {
+		if inv, ok := invalid[ref]; ok {
+			return inv
+		}
+		// guard against cycles while following the route.
+		invalid[ref] = false
+		cell := f.cells[ref]
+		inv := cell.next != 0 && isInvalid(cell.next)
+		invalid[ref] = inv
+		return inv
+	}
+	for ref := range f.cells {
+		if isInvalid(ref) {
+			delete(f.cells, ref)
+			f.pending = append(f.pending, ref)
+		}
+	}
+}
+
+// propagate expands the field from the seed polygons, updating the polygons
+// that can reach the goal with a lower cost through them.
+func (f *FlowField) propagate(seeds []PolyRef) Status {
+	// The field is followed from the neighbours to the expanded polygons,
+	// toward the goal, so the search is backward. Each polygon has a single
+	// cell, so a single node, which position is the one of its cell.
+	search := f.query.newGraphSearch(f.filter)
+	search.backward = true
+	search.reposition = true
+	if f.MaxCost > 0 {
+		search.maxCost = f.MaxCost
+	}
+	search.estimate = func(_, nei *searchPoly, cost float32) (float32, float32, bool) {
+		if cell, ok := f.cells[nei.ref]; ok && cost >= cell.cost {
+			return 0, 0, false
+		}
+		return cost, 0, true
+	}
+	search.visit = func(cur, nei *searchPoly) {
+		f.cells[nei.ref] = flowCell{cost: nei.node.Cost, next: cur.ref, pos: d3.NewVec3From(nei.node.Pos)}
+	}
+
+	search.reset()
+	for _, ref := range seeds {
+		cell := f.cells[ref]
+		if search.push(ref, cell.pos, cell.cost, 0) == nil {
+			return Failure | OutOfNodes
+		}
+	}
+
+	for !search.open.empty() {
+		// Do not expand back toward the goal.
+		bestNode := search.pop()
+		search.expand(bestNode, f.cells[bestNode.ID].next)
+	}
+
+	if search.outOfNodes {
+		return Success | OutOfNodes
+	}
+	return Success
+}
@@ -0,0 +1,151 @@
+package detour
+
+import (
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// checkFlowField checks that following the field from any of its polygons
+// leads to the goal, with decreasing costs.
+func checkFlowField(t *testing.T, mesh *NavMesh, f *FlowField) {
+	goal, _ := f.Goal()
+	for ref, cell := range f.cells {
+		if ref == goal {
+			continue
+		}
+		if !arePolysLinked(mesh, ref, cell.next) {
+			t.Fatalf("polygon %v is not linked to its next polygon %v", ref, cell.next)
+		}
+		next, ok := f.cells[cell.next]
+		if !ok {
+			t.Fatalf("next polygon %v of %v is not in the field", cell.next, ref)
+		}
+		if next.cost >= cell.cost {
+			t.Fatalf("cost of %v (%f) should be higher than the cost of its next polygon %v (%f)",
+				ref, cell.cost, cell.next, next.cost)
+		}
+	}
+}
+
+// compareFlowFields checks that 2 flow fields have the same polygons, with the
+// same costs.
+func compareFlowFields(t *testing.T, got, want *FlowField) {
+	if len(got.cells) != len(want.cells) {
+		t.Fatalf("got %d polygons in the field, want %d", len(got.cells), len(want.cells))
+	}
+	for ref, wc := range want.cells {
+		gc, ok := got.cells[ref]
+		if !ok {
+			t.Fatalf("polygon %v should be in the field", ref)
+		}
+		if math32.Abs(gc.cost-wc.cost) > 1e-3*wc.cost {
+			t.Fatalf("polygon %v: got cost %f, want %f", ref, gc.cost, wc.cost)
+		}
+	}
+}
+
+func TestFlowField(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 4096)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	filter := NewStandardQueryFilter()
+
+	// take the goal in the tile with the most polygons.
+	var goalTile *MeshTile
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header != nil && (goalTile == nil || tile.Header.PolyCount > goalTile.Header.PolyCount) {
+			goalTile = tile
+		}
+	}
+	goalPoly := &goalTile.Polys[0]
+	goalRef := mesh.PolyRefBase(goalTile)
+	goalPos := CalcPolyCenter(goalPoly.Verts[:], int32(goalPoly.VertCount), goalTile.Verts)
+
+	field := NewFlowField(query, filter)
+	defer field.Close()
+	if st := field.SetGoal(goalRef, goalPos); StatusFailed(st) || StatusDetail(st, OutOfNodes) {
+		t.Fatalf("SetGoal failed with status 0x%x", st)
+	}
+	if len(field.cells) < 2 {
+		t.Fatalf("flow field only has %d polygons", len(field.cells))
+	}
+	checkFlowField(t, mesh, field)
+
+	// steering directions are normalized and point toward the next polygon.
+	dir := d3.NewVec3()
+	for ref := range field.cells {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		if poly.Type() == polyTypeOffMeshConnection {
+			continue
+		}
+		pos := CalcPolyCenter(poly.Verts[:], int32(poly.VertCount), tile.Verts)
+		if st := field.Direction(ref, pos, dir); StatusFailed(st) {
+			t.Fatalf("Direction failed for polygon %v, status 0x%x", ref, st)
+		}
+		if l := dir.Len(); ref != goalRef && math32.Abs(l-1) > 1e-4 {
+			t.Fatalf("direction %v of polygon %v is not normalized", dir, ref)
+		}
+	}
+
+	// remove then add back the biggest tile next to the goal tile, the
+	// repaired field must be the same than the field computed from scratch.
+	var nei *MeshTile
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header != nil && tile != goalTile &&
+			math32.Abs(float32(tile.Header.X-goalTile.Header.X)) <= 1 &&
+			math32.Abs(float32(tile.Header.Y-goalTile.Header.Y)) <= 1 &&
+			(nei == nil || tile.Header.PolyCount > nei.Header.PolyCount) {
+			nei = tile
+		}
+	}
+	if nei == nil {
+		t.Fatalf("no tile found around the goal tile")
+	}
+	x, y := nei.Header.X, nei.Header.Y
+	data := make([]byte, nei.DataSize)
+	nei.Header.serialize(data)
+	nei.serialize(data[nei.Header.size():])
+
+	if _, st := mesh.RemoveTile(mesh.TileRef(nei)); StatusFailed(st) {
+		t.Fatalf("couldn't remove tile, status 0x%x", st)
+	}
+	fresh := NewFlowField(query, filter)
+	defer fresh.Close()
+	fresh.SetGoal(goalRef, goalPos)
+	field.Update()
+	checkFlowField(t, mesh, field)
+	compareFlowFields(t, field, fresh)
+
+	if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+		t.Fatalf("couldn't add tile, status 0x%x", st)
+	}
+	if mesh.TileAt(x, y, 0) == nil {
+		t.Fatalf("tile not added back")
+	}
+	fresh.SetGoal(goalRef, goalPos)
+	field.Update()
+	checkFlowField(t, mesh, field)
+	compareFlowFields(t, field, fresh)
+
+	// removing the goal tile invalidates the field.
+	if _, st := mesh.RemoveTile(mesh.TileRef(goalTile)); StatusFailed(st) {
+		t.Fatalf("couldn't remove tile, status 0x%x", st)
+	}
+	if ref, _ := field.Goal(); ref != 0 {
+		t.Errorf("goal should be reset when its tile is removed")
+	}
+	if _, ok := field.Cost(goalRef); ok {
+		t.Errorf("field should be empty when the goal tile is removed")
+	}
+}
@@ -0,0 +1,229 @@
+package detour
+
+import (
+	"log"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// graphSearch is a best-first search of the polygon graph, using a node pool
+// and an open list.
+//
+// It implements the expansion of the nodes shared by the path searches, which
+// only differ by the way they estimate the cost of the nodes, and by when they
+// stop.
+type graphSearch struct {
+	q      *NavMeshQuery
+	pool   *NodePool
+	open   *nodeQueue
+	filter QueryFilter
+
+	// backward is set for the searches following the links backward, from
+	// the end polygon. The node positions are then the midpoints of the links
+	// from the neighbours to the expanded polygon, which must exist since
+	// off-mesh connections may be one-way, and the costs are those of moving
+	// from the neighbours to the expanded polygon.
+	backward bool
+
+	// reposition is set for the searches keeping a single node per polygon,
+	// which position is the midpoint of the edge it's reached through with
+	// its current cost, rather than of the first edge it's reached through.
+	reposition bool
+
+	// maxCost is the cost above which the nodes are not opened, 0 meaning no
+	// limit.
+	maxCost float32
+
+	// estimate is called with the cost of reaching nei from cur, the polygon
+	// being expanded. It returns the cost of the node of nei, for example
+	// adding the cost of reaching a goal inside nei, and its heuristic.
+	// Returning false skips the neighbour.
+	estimate func(cur, nei *searchPoly, cost float32) (float32, float32, bool)
+
+	// visit, if not nil, is called for each node opened or updated.
+	visit func(cur, nei *searchPoly)
+
+	lastBest     *Node   // node having the lowest heuristic.
+	lastBestCost float32 // heuristic of lastBest.
+	outOfNodes   bool    // set when the node pool ran out of nodes.
+
+	pos d3.Vec3 // scratch position used when reposition is set.
+}
+
+// searchPoly is a polygon reached by a graph search, along with its node.
+type searchPoly struct {
+	ref  PolyRef
+	tile *MeshTile
+	poly *Poly
+	node *Node
+}
+
+// newGraphSearch returns a search using the node pool and open list of the
+// query q.
+func (q *NavMeshQuery) newGraphSearch(filter QueryFilter) *graphSearch {
+	return &graphSearch{q: q, pool: q.nodePool, open: q.openList, filter: filter}
+}
+
+// reset clears the node pool and the open list.
+func (s *graphSearch) reset() {
+	s.pool.Clear()
+	s.open.clear()
+	s.lastBest = nil
+	s.outOfNodes = false
+}
+
+// push opens a start node for the polygon ref, at pos, with the given cost
+// and heuristic. It returns nil if the node pool is out of nodes.
+func (s *graphSearch) push(ref PolyRef, pos d3.Vec3, cost, heuristic float32) *Node {
+	node := s.pool.Node(ref, 0)
+	if node == nil {
+		s.outOfNodes = true
+		return nil
+	}
+	node.Pos.Assign(pos)
+	node.PIdx = 0
+	node.Cost = cost
+	node.Total = cost + heuristic
+	node.ID = ref
+	node.Flags = nodeOpen
+	s.open.push(node)
+
+	if s.lastBest == nil {
+		s.lastBest = node
+		s.lastBestCost = node.Total
+	}
+	return node
+}
+
+// pop removes the cheapest node from the open list and closes it.
+func (s *graphSearch) pop() *Node {
+	node := s.open.pop()
+	node.Flags &= ^nodeOpen
+	node.Flags |= nodeClosed
+	return node
+}
+
+// parentRef returns the polygon node has been reached from, 0 for a start
+// node.
+func (s *graphSearch) parentRef(node *Node) PolyRef {
+	if node.PIdx == 0 {
+		return 0
+	}
+	return s.pool.NodeAtIdx(int32(node.PIdx)).ID
+}
+
+// expand opens, or updates, the nodes of the neighbours of the polygon of
+// node, which has been reached from the polygon parentRef. The search doesn't
+// expand back to parentRef.
+func (s *graphSearch) expand(node *Node, parentRef PolyRef) {
+	nav := s.q.nav
+	cur := searchPoly{ref: node.ID, node: node}
+	nav.TileAndPolyByRefUnsafe(cur.ref, &cur.tile, &cur.poly)
+
+	var (
+		parentTile *MeshTile
+		parentPoly *Poly
+	)
+	if parentRef != 0 {
+		nav.TileAndPolyByRefUnsafe(parentRef, &parentTile, &parentPoly)
+	}
+	if s.reposition && s.pos == nil {
+		s.pos = d3.NewVec3()
+	}
+
+	for i := cur.poly.FirstLink; i != nullLink; i = cur.tile.Links[i].Next {
+		link := &cur.tile.Links[i]
+		nei := searchPoly{ref: link.Ref}
+
+		// Skip invalid ids and do not expand back to where we came from.
+		if nei.ref == 0 || nei.ref == parentRef {
+			continue
+		}
+		nav.TileAndPolyByRefUnsafe(nei.ref, &nei.tile, &nei.poly)
+		if !s.filter.PassFilter(nei.ref, nei.tile, nei.poly) {
+			continue
+		}
+
+		// deal explicitly with crossing tile boundaries
+		var crossSide uint8
+		if link.Side != 0xff && !s.reposition {
+			crossSide = link.Side >> 1
+		}
+		nei.node = s.pool.Node(nei.ref, crossSide)
+		if nei.node == nil {
+			s.outOfNodes = true
+			continue
+		}
+
+		// If the node is visited the first time, calculate node position.
+		pos := nei.node.Pos
+		if s.reposition {
+			pos = s.pos
+		}
+		if nei.node.Flags == 0 || s.reposition {
+			if s.backward {
+				if StatusFailed(s.q.edgeMidPoint(nei.ref, nei.poly, nei.tile,
+					cur.ref, cur.poly, cur.tile, pos)) {
+					continue
+				}
+			} else if st := s.q.edgeMidPoint(cur.ref, cur.poly, cur.tile,
+				nei.ref, nei.poly, nei.tile, pos); StatusFailed(st) {
+				log.Println("getEdgeMidPoint failed:", st)
+			}
+		}
+
+		// Calculate cost and heuristic.
+		var cost float32
+		if s.backward {
+			cost = node.Cost + s.filter.Cost(pos, node.Pos,
+				nei.ref, nei.tile, nei.poly,
+				cur.ref, cur.tile, cur.poly,
+				parentRef, parentTile, parentPoly)
+		} else {
+			cost = node.Cost + s.filter.Cost(node.Pos, pos,
+				parentRef, parentTile, parentPoly,
+				cur.ref, cur.tile, cur.poly,
+				nei.ref, nei.tile, nei.poly)
+		}
+		cost, heuristic, ok := s.estimate(&cur, &nei, cost)
+		if !ok || (s.maxCost > 0 && cost > s.maxCost) {
+			continue
+		}
+		total := cost + heuristic
+
+		// The node is already in open or closed list and the new result is
+		// worse, skip.
+		if nei.node.Flags&(nodeOpen|nodeClosed) != 0 && total >= nei.node.Total {
+			continue
+		}
+
+		// Add or update the node.
+		if s.reposition {
+			nei.node.Pos.Assign(pos)
+		}
+		nei.node.PIdx = s.pool.NodeIdx(node)
+		nei.node.ID = nei.ref
+		nei.node.Flags &= ^nodeClosed
+		nei.node.Cost = cost
+		nei.node.Total = total
+
+		if nei.node.Flags&nodeOpen != 0 {
+			// Already in open, update node location.
+			s.open.modify(nei.node)
+		} else {
+			// Put the node in open list.
+			nei.node.Flags |= nodeOpen
+			s.open.push(nei.node)
+		}
+
+		// Update nearest node to target so far.
+		if heuristic < s.lastBestCost {
+			s.lastBestCost = heuristic
+			s.lastBest = nei.node
+		}
+
+		if s.visit != nil {
+			s.visit(&cur, &nei)
+		}
+	}
+}
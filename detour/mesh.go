@@ -47,22 +47,57 @@ import (
 //
 // see NavMeshQuery, CreateNavMeshData, NavMeshCreateParams
 type NavMesh struct {
-	Params                NavMeshParams // Current initialization params. TODO: do not store this info twice.
-	Orig                  d3.Vec3       // Origin of the tile (0,0)
-	TileWidth, TileHeight float32       // Dimensions of each tile.
-	MaxTiles              int32         // Max number of tiles.
-	TileLUTSize           int32         // Tile hash lookup size (must be pot).
-	TileLUTMask           int32         // Tile hash lookup mask.
-	posLookup             []*MeshTile   // Tile hash lookup.
-	nextFree              *MeshTile     // Freelist of tiles.
-	Tiles                 []MeshTile    // List of tiles.
-	saltBits              uint32        // Number of salt bits in the tile ID.
-	tileBits              uint32        // Number of tile bits in the tile ID.
-	polyBits              uint32        // Number of poly bits in the tile ID.
+	Params                NavMeshParams  // Current initialization params. TODO: do not store this info twice.
+	Orig                  d3.Vec3        // Origin of the tile (0,0)
+	TileWidth, TileHeight float32        // Dimensions of each tile.
+	MaxTiles              int32          // Max number of tiles.
+	TileLUTSize           int32          // Tile hash lookup size (must be pot).
+	TileLUTMask           int32          // Tile hash lookup mask.
+	posLookup             []*MeshTile    // Tile hash lookup.
+	nextFree              *MeshTile      // Freelist of tiles.
+	Tiles                 []MeshTile     // List of tiles.
+	saltBits              uint32         // Number of salt bits in the tile ID.
+	tileBits              uint32         // Number of tile bits in the tile ID.
+	polyBits              uint32         // Number of poly bits in the tile ID.
+	observers             []TileObserver // Notified of tile additions and removals.
+}
+
+// TileObserver is the interface implemented by the types that need to be
+// notified when tiles are added to, or removed from, a NavMesh.
+//
+// see NavMesh.AddTileObserver
+type TileObserver interface {
+	// TileAdded is called once the tile has been added to the navigation mesh
+	// and connected to its neighbours.
+	TileAdded(m *NavMesh, tile *MeshTile)
+
+	// TileRemoved is called before the tile gets disconnected from its
+	// neighbours and removed from the navigation mesh.
+	TileRemoved(m *NavMesh, tile *MeshTile)
+}
+
+// AddTileObserver registers o so that it gets notified of the tiles added to
+// or removed from the navigation mesh.
+func (m *NavMesh) AddTileObserver(o TileObserver) {
+	m.observers = append(m.observers, o)
+}
+
+// RemoveTileObserver unregisters o, previously registered with
+// AddTileObserver.
+func (m *NavMesh) RemoveTileObserver(o TileObserver) {
+	for i := range m.observers {
+		if m.observers[i] == o {
+			m.observers = append(m.observers[:i], m.observers[i+1:]...)
+			return
+		}
+	}
 }
 
 // Decode reads a tiled navigation mesh from r and returns it.
 //
+// r can either be a version 1 navigation mesh set, as written by SaveToFile,
+// or a version 2 one, as written by Encode.
+//
 // returned error will be different from nil in case of failure.
 func Decode(r io.Reader) (*NavMesh, error) {
 	// Read header.
@@ -71,21 +106,38 @@ func Decode(r io.Reader) (*NavMesh, error) {
 		err error
 	)
 
-	err = binary.Read(r, binary.LittleEndian, &hdr)
-	if err != nil {
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(r, magic); err != nil {
 		return nil, err
 	}
-
+	hdr.Magic = int32(binary.LittleEndian.Uint32(magic))
+	if hdr.Magic == navMeshSetV2Magic {
+		return decodeV2(magic, r)
+	}
 	if hdr.Magic != navMeshSetMagic {
 		return nil, fmt.Errorf("wrong magic number: %x", hdr.Magic)
 	}
 
-	if hdr.Version != navMeshSetVersion {
+	err = binary.Read(r, binary.LittleEndian, &hdr.Version)
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &hdr.NumTiles)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &hdr.Params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Files with references of another size are converted.
+	fileRefSize := setVersionRefSize(hdr.Version)
+	if fileRefSize == 0 {
 		return nil, fmt.Errorf("wrong version: %d", hdr.Version)
 	}
 
 	var mesh NavMesh
-	status := mesh.Init(&hdr.Params)
+	params := convertParams(hdr.Params, fileRefSize)
+	status := mesh.Init(&params)
 	if StatusFailed(status) {
 		return nil, fmt.Errorf("status failed 0x%x", status)
 	}
@@ -98,14 +150,20 @@ func Decode(r io.Reader) (*NavMesh, error) {
 			tileHdr navMeshTileHeader
 			err     error
 		)
-		err = binary.Read(r, binary.LittleEndian, &tileHdr)
-		if err != nil {
+		buf := make([]byte, fileRefSize+4)
+		if _, err = io.ReadFull(r, buf); err != nil {
 			return nil, err
 		}
+		fileRef := readRef(buf, fileRefSize)
+		tileHdr.DataSize = int32(binary.LittleEndian.Uint32(buf[fileRefSize:]))
 
-		if tileHdr.TileRef == 0 || tileHdr.DataSize == 0 {
+		if fileRef == 0 || tileHdr.DataSize == 0 {
 			break
 		}
+		tileHdr.TileRef, status = mesh.convertTileRef(fileRef, fileRefSize, &hdr.Params)
+		if StatusFailed(status) {
+			return nil, fmt.Errorf("invalid reference for tile %d, status: 0x%x", i, status)
+		}
 
 		data := make([]byte, tileHdr.DataSize)
 		if data == nil {
@@ -190,7 +248,7 @@ func (m *NavMesh) InitForSingleTile(data []uint8, flags int) Status {
 	if header.Magic != navMeshMagic {
 		return Failure | WrongMagic
 	}
-	if header.Version != navMeshVersion {
+	if tileVersionRefSize(header.Version) == 0 {
 		return Failure | WrongVersion
 	}
 
@@ -240,20 +298,9 @@ func (m *NavMesh) Init(params *NavMeshParams) Status {
 	}
 
 	// Init ID generator values.
-	m.tileBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxTiles)))
-	m.polyBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxPolys)))
-	// Only allow 31 salt bits, since the salt mask is calculated using 32bit uint and it will overflow.
-	if 31 < 32-m.tileBits-m.polyBits {
-		m.saltBits = 31
-	} else {
-		m.saltBits = 32 - m.tileBits - m.polyBits
-	}
-
-	if m.saltBits < 10 {
-		return Status(Failure | InvalidParam)
-	}
-
-	return Success
+	var st Status
+	m.saltBits, m.tileBits, m.polyBits, st = refBits(refSize, params)
+	return st
 }
 
 // AddTile adds a tile to the navigation mesh.
@@ -289,7 +336,7 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 	if hdr.Magic != navMeshMagic {
 		return Failure | WrongMagic, 0
 	}
-	if hdr.Version != navMeshVersion {
+	if tileVersionRefSize(hdr.Version) == 0 {
 		return Failure | WrongVersion, 0
 	}
 
@@ -369,6 +416,12 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 	tile.Header = &hdr
 	tile.DataSize = int32(len(data))
 	tile.Flags = 0
+	if linkRefSize := tileVersionRefSize(hdr.Version); linkRefSize != refSize {
+		// The tile data had references of another size, links are rebuilt
+		// below so only the size of the data changes.
+		hdr.Version = navMeshVersion
+		tile.DataSize += hdr.MaxLinkCount * int32(refSize-linkRefSize)
+	}
 
 	m.connectIntLinks(tile)
 
@@ -407,6 +460,10 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 		}
 	}
 
+	for _, o := range m.observers {
+		o.TileAdded(m, tile)
+	}
+
 	return Success, m.TileRef(tile)
 }
 
@@ -437,6 +494,10 @@ func (m *NavMesh) RemoveTile(ref TileRef) (data []uint8, st Status) {
 		return data, Failure | InvalidParam
 	}
 
+	for _, o := range m.observers {
+		o.TileRemoved(m, tile)
+	}
+
 	// Remove tile from hash lookup.
 	h := computeTileHash(tile.Header.X, tile.Header.Y, m.TileLUTMask)
 	var (
@@ -547,7 +608,7 @@ func (m *NavMesh) connectIntLinks(tile *MeshTile) {
 		i    int32
 		base PolyRef
 	)
-	base = m.polyRefBase(tile)
+	base = m.PolyRefBase(tile)
 
 	for i = 0; i < tile.Header.PolyCount; i++ {
 		poly := &tile.Polys[i]
@@ -581,18 +642,18 @@ func (m *NavMesh) connectIntLinks(tile *MeshTile) {
 	}
 }
 
-// polyRefBase returns the polygon reference for the base polygon in the
+// PolyRefBase returns the polygon reference for the base polygon in the
 // specified tile.
 //
 // Example use case:
-//  base := navmesh.polyRefBase(tile);
+//  base := navmesh.PolyRefBase(tile);
 //  for i = 0; i < tile.Header.PolyCount; i++ {
 //      poly = &tile.polys[i]
 //      ref := base | PolyRef(i)
 //
 //      // Use the reference to access the polygon data.
 //  }
-func (m *NavMesh) polyRefBase(tile *MeshTile) PolyRef {
+func (m *NavMesh) PolyRefBase(tile *MeshTile) PolyRef {
 	if tile == nil {
 		return 0
 	}
@@ -635,9 +696,6 @@ func (m *NavMesh) encodePolyID(salt, it, ip uint32) PolyRef {
 		(PolyRef(it) << m.polyBits) | PolyRef(ip)
 }
 
-// PolyRef is a polygon reference.
-type PolyRef uint32
-
 // Link defines a Link between polygons.
 //
 // Note: This structure is rarely if ever used by the end user.
@@ -702,9 +760,6 @@ const (
 	// A magic number used to detect compatibility of navigation tile data.
 	navMeshMagic int32 = 'D'<<24 | 'N'<<16 | 'A'<<8 | 'V'
 
-	// A version number used to detect compatibility of navigation tile data.
-	navMeshVersion = 7
-
 	// A magic number used to detect the compatibility of navigation tile states.
 	navMeshStateMagic = 'D'<<24 | 'N'<<16 | 'M'<<8 | 'S'
 
@@ -761,7 +816,7 @@ func (m *NavMesh) baseOffMeshLinks(tile *MeshTile) {
 		base PolyRef
 	)
 
-	base = m.polyRefBase(tile)
+	base = m.PolyRefBase(tile)
 
 	// Base off-mesh connection start points.
 	for i = 0; i < tile.Header.OffMeshConCount; i++ {
@@ -907,7 +962,7 @@ func (m *NavMesh) queryPolygonsInTile(
 		bmax[2] = uint16(uint32(qfac*maxz+1) | 1)
 
 		// Traverse tree
-		base := m.polyRefBase(tile)
+		base := m.PolyRefBase(tile)
 		var n int32
 		for nodeIdx < endIdx {
 			node = &tile.BvTree[nodeIdx]
@@ -937,7 +992,7 @@ func (m *NavMesh) queryPolygonsInTile(
 		bmin, bmax [3]float32
 		n, i       int32
 	)
-	base := m.polyRefBase(tile)
+	base := m.PolyRefBase(tile)
 	for i = 0; i < tile.Header.PolyCount; i++ {
 		p := &tile.Polys[i]
 		// Do not return off-mesh connection polygons.
@@ -1174,7 +1229,7 @@ func (m *NavMesh) connectExtOffMeshLinks(tile, target *MeshTile, side int32) {
 				landPolyIdx := uint16(m.decodePolyIDPoly(ref))
 				landPoly := &tile.Polys[landPolyIdx]
 				link := &tile.Links[tidx]
-				link.Ref = m.polyRefBase(target) | PolyRef(targetCon.Poly)
+				link.Ref = m.PolyRefBase(target) | PolyRef(targetCon.Poly)
 				link.Edge = 0xff
 				if side == -1 {
 					link.Side = 0xff
@@ -1314,7 +1369,7 @@ func (m *NavMesh) findConnectingPolys(
 	l := extLink | uint16(side)
 	var n int32
 
-	base := m.polyRefBase(tile)
+	base := m.PolyRefBase(tile)
 
 	var i int32
 	for i = 0; i < tile.Header.PolyCount; i++ {
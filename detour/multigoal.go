@@ -0,0 +1,286 @@
+package detour
+
+import (
+	"math"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// goalSet is a set of goal positions, indexed by the polygon containing them.
+type goalSet struct {
+	refs  []PolyRef
+	pos   []d3.Vec3
+	byRef map[PolyRef][]int
+}
+
+func newGoalSet(refs []PolyRef, pos []d3.Vec3) *goalSet {
+	gs := &goalSet{refs: refs, pos: pos, byRef: make(map[PolyRef][]int)}
+	for i, ref := range refs {
+		gs.byRef[ref] = append(gs.byRef[ref], i)
+	}
+	return gs
+}
+
+// heuristic returns the estimated cost from pos to the nearest goal.
+func (gs *goalSet) heuristic(pos d3.Vec3) float32 {
+	h := float32(math.MaxFloat32)
+	for _, gpos := range gs.pos {
+		if d := pos.Dist(gpos); d < h {
+			h = d
+		}
+	}
+	return h * HScale
+}
+
+// endCost returns the cheapest cost from pos, in the polygon ref, to the goals
+// it contains, along with the index of that goal.
+func (gs *goalSet) endCost(filter QueryFilter, pos d3.Vec3,
+	prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+	ref PolyRef, tile *MeshTile, poly *Poly) (cost float32, goal int) {
+
+	goal = -1
+	for _, i := range gs.byRef[ref] {
+		c := filter.Cost(pos, gs.pos[i],
+			prevRef, prevTile, prevPoly,
+			ref, tile, poly,
+			0, nil, nil)
+		if goal == -1 || c < cost {
+			cost, goal = c, i
+		}
+	}
+	return cost, goal
+}
+
+// validGoals checks the goal polygons and positions given to a multi-goal
+// query.
+func (q *NavMeshQuery) validGoals(goalRefs []PolyRef, goalPos []d3.Vec3) bool {
+	if len(goalRefs) == 0 || len(goalRefs) != len(goalPos) {
+		return false
+	}
+	for i, ref := range goalRefs {
+		if !q.nav.IsValidPolyRef(ref) || len(goalPos[i]) < 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// FindPathToNearest finds the path from the start polygon to the goal that is
+// the cheapest to reach, among several goals.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   goalRefs  The reference ids of the goal polygons.
+//   goalPos   The goal positions, each one being within the polygon of the
+//             same index in goalRefs. [Length: len(goalRefs)]
+//   filter    The polygon filter to apply to the query.
+//   path      This slice will be filled with an ordered list of polygon
+//             references representing the path. (Start to goal.)
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   goal      the index of the reached goal, or -1 if no goal is reachable.
+//   st        status code (may be a partial result)
+//
+// This is equivalent to, but much faster than, calling FindPath for each goal
+// and keeping the cheapest path. The search heuristic is the distance to the
+// nearest goal.
+//
+// If no goal can be reached through the navigation graph, the last polygon in
+// the path will be the nearest to a goal, goal will be -1 and the status will
+// have the PartialResult flag set.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindPathToNearest(
+	startRef PolyRef,
+	startPos d3.Vec3,
+	goalRefs []PolyRef,
+	goalPos []d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef) (pathCount, goal int, st Status) {
+
+	// Validate input
+	if !q.nav.IsValidPolyRef(startRef) || !q.validGoals(goalRefs, goalPos) ||
+		len(startPos) < 3 || filter == nil || len(path) == 0 {
+		return 0, -1, Failure | InvalidParam
+	}
+
+	goals := newGoalSet(goalRefs, goalPos)
+	if _, ok := goals.byRef[startRef]; ok {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		q.nav.TileAndPolyByRefUnsafe(startRef, &tile, &poly)
+		_, goal = goals.endCost(filter, startPos, 0, nil, nil, startRef, tile, poly)
+		path[0] = startRef
+		return 1, goal, Success
+	}
+
+	search := q.newGraphSearch(filter)
+	search.estimate = func(cur, nei *searchPoly, cost float32) (float32, float32, bool) {
+		if _, ok := goals.byRef[nei.ref]; ok {
+			// Special case for goal nodes.
+			endCost, _ := goals.endCost(filter, nei.node.Pos,
+				cur.ref, cur.tile, cur.poly,
+				nei.ref, nei.tile, nei.poly)
+			return cost + endCost, 0, true
+		}
+		return cost, goals.heuristic(nei.node.Pos), true
+	}
+
+	search.reset()
+	search.push(startRef, startPos, 0, goals.heuristic(startPos))
+	goal = -1
+
+	for !search.open.empty() {
+		// Remove node from open list and put it in closed list.
+		bestNode := search.pop()
+		parentRef := search.parentRef(bestNode)
+
+		// Reached a goal, stop searching.
+		if _, ok := goals.byRef[bestNode.ID]; ok {
+			var (
+				bestTile, parentTile *MeshTile
+				bestPoly, parentPoly *Poly
+			)
+			q.nav.TileAndPolyByRefUnsafe(bestNode.ID, &bestTile, &bestPoly)
+			if parentRef != 0 {
+				q.nav.TileAndPolyByRefUnsafe(parentRef, &parentTile, &parentPoly)
+			}
+			_, goal = goals.endCost(filter, bestNode.Pos,
+				parentRef, parentTile, parentPoly,
+				bestNode.ID, bestTile, bestPoly)
+			search.lastBest = bestNode
+			break
+		}
+		search.expand(bestNode, parentRef)
+	}
+
+	pathCount, st = q.pathToNode(search.lastBest, path)
+	if goal == -1 {
+		st |= PartialResult
+	}
+	if search.outOfNodes {
+		st |= OutOfNodes
+	}
+	return pathCount, goal, st
+}
+
+// FindGoalCosts computes the costs to reach several goals from a start
+// position, in a single Dijkstra expansion of the polygon graph.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   goalRefs  The reference ids of the goal polygons.
+//   goalPos   The goal positions, each one being within the polygon of the
+//             same index in goalRefs. [Length: len(goalRefs)]
+//   filter    The polygon filter to apply to the query.
+//   maxCost   The search stops expanding the polygons which cost is higher
+//             than maxCost, 0 meaning no limit.
+//   costs     This slice will be filled with the cost to reach each goal, or
+//             math.MaxFloat32 for the unreachable goals.
+//             [Length: >= len(goalRefs)]
+//
+//  Returns:
+//   reached   the number of reachable goals.
+//   st        status code
+//
+// The costs are the same as the costs of the paths FindPath would find to
+// each goal, but the graph is only explored once, and the search stops as
+// soon as the costs to all the goals are known.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindGoalCosts(
+	startRef PolyRef,
+	startPos d3.Vec3,
+	goalRefs []PolyRef,
+	goalPos []d3.Vec3,
+	filter QueryFilter,
+	maxCost float32,
+	costs []float32) (reached int, st Status) {
+
+	// Validate input
+	if !q.nav.IsValidPolyRef(startRef) || !q.validGoals(goalRefs, goalPos) ||
+		len(startPos) < 3 || filter == nil || len(costs) < len(goalRefs) || maxCost < 0 {
+		return 0, Failure | InvalidParam
+	}
+
+	goals := newGoalSet(goalRefs, goalPos)
+	for i := range goalRefs {
+		costs[i] = math.MaxFloat32
+	}
+
+	// setCosts updates the costs of the goals inside the polygon ref, reached
+	// from pos with the cost base.
+	setCosts := func(base float32, pos d3.Vec3,
+		prevRef PolyRef, prevTile *MeshTile, prevPoly *Poly,
+		ref PolyRef, tile *MeshTile, poly *Poly) {
+
+		for _, i := range goals.byRef[ref] {
+			c := base + filter.Cost(pos, goalPos[i],
+				prevRef, prevTile, prevPoly,
+				ref, tile, poly,
+				0, nil, nil)
+			if c < costs[i] {
+				costs[i] = c
+			}
+		}
+	}
+
+	// maxGoalCost returns the highest goal cost, math.MaxFloat32 while a goal
+	// has not been reached.
+	maxGoalCost := func() float32 {
+		var max float32
+		for _, c := range costs[:len(goalRefs)] {
+			if c > max {
+				max = c
+			}
+		}
+		return max
+	}
+
+	var (
+		startTile *MeshTile
+		startPoly *Poly
+	)
+	q.nav.TileAndPolyByRefUnsafe(startRef, &startTile, &startPoly)
+	setCosts(0, startPos, 0, nil, nil, startRef, startTile, startPoly)
+
+	search := q.newGraphSearch(filter)
+	search.maxCost = maxCost
+	search.estimate = func(_, _ *searchPoly, cost float32) (float32, float32, bool) {
+		return cost, 0, true
+	}
+	search.visit = func(cur, nei *searchPoly) {
+		setCosts(nei.node.Cost, nei.node.Pos,
+			cur.ref, cur.tile, cur.poly,
+			nei.ref, nei.tile, nei.poly)
+	}
+
+	search.reset()
+	search.push(startRef, startPos, 0, 0)
+
+	for !search.open.empty() {
+		bestNode := search.pop()
+
+		// The goal costs can't be improved anymore, stop searching.
+		if bestNode.Cost >= maxGoalCost() {
+			break
+		}
+		search.expand(bestNode, search.parentRef(bestNode))
+	}
+
+	for _, c := range costs[:len(goalRefs)] {
+		if c != math.MaxFloat32 {
+			reached++
+		}
+	}
+	st = Success
+	if search.outOfNodes {
+		st |= OutOfNodes
+	}
+	return reached, st
+}
@@ -0,0 +1,89 @@
+package detour
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestMultiGoalQueries(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.Vec3{2, 4, 2}
+
+	locate := func(pos d3.Vec3) (PolyRef, d3.Vec3) {
+		st, ref, pt := query.FindNearestPoly(pos, extents, filter)
+		if StatusFailed(st) || ref == 0 {
+			t.Fatalf("couldn't find nearest poly of %v, status: 0x%x\n", pos, st)
+		}
+		return ref, pt
+	}
+
+	startRef, startPos := locate(d3.Vec3{37.298489, -1.776901, 11.652311})
+	var (
+		goalRefs []PolyRef
+		goalPos  []d3.Vec3
+	)
+	for _, pos := range []d3.Vec3{
+		{42.457218, 7.797607, 17.778244},
+		{35.2, -0.1, -1.4},
+		{34.9, -0.6, 3.5},
+	} {
+		ref, pt := locate(pos)
+		goalRefs = append(goalRefs, ref)
+		goalPos = append(goalPos, pt)
+	}
+
+	costs := make([]float32, len(goalRefs))
+	reached, st := query.FindGoalCosts(startRef, startPos, goalRefs, goalPos, filter, 0, costs)
+	if StatusFailed(st) {
+		t.Fatalf("FindGoalCosts failed with status 0x%x", st)
+	}
+	if reached != len(goalRefs) {
+		t.Fatalf("FindGoalCosts reached %d goals, want %d (costs: %v)", reached, len(goalRefs), costs)
+	}
+	nearest := 0
+	for i, c := range costs {
+		if c <= 0 || c == math.MaxFloat32 {
+			t.Errorf("goal %d: invalid cost %f", i, c)
+		}
+		if c < costs[nearest] {
+			nearest = i
+		}
+	}
+
+	path := make([]PolyRef, 256)
+	n, goal, st := query.FindPathToNearest(startRef, startPos, goalRefs, goalPos, filter, path)
+	if StatusFailed(st) || st&PartialResult != 0 {
+		t.Fatalf("FindPathToNearest failed with status 0x%x", st)
+	}
+	if goal != nearest {
+		t.Errorf("FindPathToNearest reached goal %d, want %d (costs: %v)", goal, nearest, costs)
+	}
+	got := append([]PolyRef(nil), path[:n]...)
+
+	// the path must be the one FindPath finds to the nearest goal.
+	n, st = query.FindPath(startRef, goalRefs[nearest], startPos, goalPos[nearest], filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed with status 0x%x", st)
+	}
+	if !reflect.DeepEqual(got, path[:n]) {
+		t.Errorf("FindPathToNearest path = %v, want %v", got, path[:n])
+	}
+
+	// with a maximum cost, only the nearest goal is reachable.
+	reached, st = query.FindGoalCosts(startRef, startPos, goalRefs, goalPos, filter, costs[nearest], costs)
+	if StatusFailed(st) {
+		t.Fatalf("FindGoalCosts failed with status 0x%x", st)
+	}
+	if reached != 1 || costs[nearest] == math.MaxFloat32 {
+		t.Errorf("FindGoalCosts with max cost reached %d goals, want 1 (costs: %v)", reached, costs)
+	}
+}
@@ -0,0 +1,488 @@
+package detour
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Version 2 of the navigation mesh set files is a container holding, in this
+// order:
+//   - a header: magic, version, reference size, number of tiles, navigation
+//     mesh parameters, size of the metadata block and 4 reserved bytes,
+//   - the tile index table, with for each tile, its reference, the offset and
+//     size of its stored data, the size of its data once decompressed, the
+//     compression method and the CRC32 (IEEE) of its decompressed data,
+//   - the metadata block, in JSON,
+//   - the stored data of the tiles.
+//
+// Version 1 of the files, magic navMeshSetMagic, only has the header and the
+// raw tile data, it's the format written by SaveToFile.
+const (
+	navMeshSetV2Magic   int32 = 'M'<<24 | 'S'<<16 | 'T'<<8 | '2' //'MST2';
+	navMeshSetV2Version int32 = 2
+
+	navMeshSetV2HeaderSize = 24 + 28 // header fields and NavMeshParams
+
+	// maxNavMeshSetIndexSize is the maximum size of the tile index table and
+	// of the metadata block, when the size of the file can't be determined.
+	maxNavMeshSetIndexSize = 64 << 20
+
+	// maxNavMeshSetTileSize is the maximum size of the data of a tile, stored
+	// or decompressed.
+	maxNavMeshSetTileSize = 256 << 20
+)
+
+// Compression is the compression method of the tile data stored in a version
+// 2 navigation mesh set file.
+type Compression uint32
+
+// Compression methods.
+const (
+	CompressionNone  Compression = iota // The tile data is stored as is.
+	CompressionFlate                    // The tile data is compressed with DEFLATE.
+	CompressionZstd                     // Zstandard, requires a registered Compressor.
+)
+
+// A Compressor compresses and decompresses tile data.
+//
+// see RegisterCompressor
+type Compressor interface {
+	// Compress returns the compressed data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns the decompressed data, of size bytes.
+	Decompress(data []byte, size int) ([]byte, error)
+}
+
+var compressors = map[Compression]Compressor{
+	CompressionFlate: flateCompressor{},
+}
+
+// RegisterCompressor registers the Compressor of the compression method c,
+// replacing the previous one, if any.
+//
+// DEFLATE is available by default. Other methods, such as CompressionZstd,
+// need to be registered before writing or reading files using them.
+func RegisterCompressor(c Compression, comp Compressor) {
+	compressors[c] = comp
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte, size int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	// size is read from the file, so the output grows with the decompressed
+	// data rather than being allocated upfront.
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("decompressed %d bytes, want %d", len(out), size)
+	}
+	return out, nil
+}
+
+// NavMeshMetadata holds information about a navigation mesh, stored in the
+// metadata block of version 2 navigation mesh set files.
+type NavMeshMetadata struct {
+	AgentHeight   float32 `json:",omitempty"` // The height of the agents.
+	AgentRadius   float32 `json:",omitempty"` // The radius of the agents.
+	AgentMaxClimb float32 `json:",omitempty"` // The maximum climb height of the agents.
+
+	// Source is the name of the input geometry the navigation mesh has been
+	// built from, and SourceHash identifies its content.
+	Source     string `json:",omitempty"`
+	SourceHash string `json:",omitempty"`
+
+	// BuildTime is the time at which the navigation mesh has been built.
+	BuildTime time.Time
+
+	// Generator names the tool, and its version, which built the navigation
+	// mesh.
+	Generator string `json:",omitempty"`
+
+	// Settings are the settings the navigation mesh has been built with,
+	// serialized. Their format is left to the navigation mesh builder.
+	Settings string `json:",omitempty"`
+
+	// Areas are the definitions of the area ids of the polygons.
+	Areas []AreaMetadata `json:",omitempty"`
+
+	// Extra holds any other information.
+	Extra map[string]string `json:",omitempty"`
+}
+
+// AreaMetadata describes an area id of the polygons of a navigation mesh.
+type AreaMetadata struct {
+	Name  string  // Name of the area.
+	ID    uint8   // Area id.
+	Flags uint16  // Flags of the polygons of this area.
+	Cost  float32 // Default traversal cost of this area.
+}
+
+// SaveOptions are the options of the version 2 navigation mesh set files.
+type SaveOptions struct {
+	Compression Compression      // Compression method of the tile data.
+	Metadata    *NavMeshMetadata // Metadata block, may be nil.
+}
+
+// TileIndexEntry is an entry of the tile index table of a version 2
+// navigation mesh set file.
+type TileIndexEntry struct {
+	Ref         TileRef     // The tile reference.
+	Offset      uint64      // Offset of the stored tile data, from the beginning of the file.
+	StoredSize  uint32      // Size of the stored tile data.
+	DataSize    uint32      // Size of the tile data, decompressed.
+	Compression Compression // Compression method of the stored tile data.
+	CRC32       uint32      // CRC32 (IEEE) of the decompressed tile data.
+}
+
+// SaveToFileWithOptions saves the navigation mesh as a version 2 navigation
+// mesh set file.
+//
+// see Encode
+func (m *NavMesh) SaveToFileWithOptions(fn string, opts SaveOptions) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if err = m.Encode(f, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Encode writes the navigation mesh to w in the version 2 navigation mesh set
+// format, which Decode reads.
+//
+// Unlike SaveToFile, which writes the version 1 format, readable by Detour,
+// the tile data can be compressed, is checksummed and can be accessed
+// randomly, see NavMeshSetReader. The file can also hold metadata.
+func (m *NavMesh) Encode(w io.Writer, opts SaveOptions) error {
+	comp := compressors[opts.Compression]
+	if opts.Compression != CompressionNone && comp == nil {
+		return fmt.Errorf("no compressor registered for compression method %d", opts.Compression)
+	}
+
+	var meta []byte
+	if opts.Metadata != nil {
+		var err error
+		if meta, err = json.Marshal(opts.Metadata); err != nil {
+			return err
+		}
+	}
+
+	// Serialize and compress the tiles.
+	var (
+		index []TileIndexEntry
+		blobs [][]byte
+	)
+	for i := int32(0); i < m.MaxTiles; i++ {
+		tile := &m.Tiles[i]
+		if tile.DataSize == 0 {
+			continue
+		}
+		data := make([]byte, tile.DataSize)
+		tile.Header.serialize(data)
+		tile.serialize(data[tile.Header.size():])
+
+		e := TileIndexEntry{
+			Ref:         m.TileRef(tile),
+			DataSize:    uint32(len(data)),
+			Compression: opts.Compression,
+			CRC32:       crc32.ChecksumIEEE(data),
+		}
+		if comp != nil {
+			var err error
+			if data, err = comp.Compress(data); err != nil {
+				return fmt.Errorf("couldn't compress tile %d: %v", i, err)
+			}
+		}
+		e.StoredSize = uint32(len(data))
+		index = append(index, e)
+		blobs = append(blobs, data)
+	}
+
+	entrySize := refSize + 24
+	off := uint64(navMeshSetV2HeaderSize + len(index)*entrySize + len(meta))
+	for i := range index {
+		index[i].Offset = off
+		off += uint64(index[i].StoredSize)
+	}
+
+	little := binary.LittleEndian
+	buf := make([]byte, navMeshSetV2HeaderSize+len(index)*entrySize)
+	little.PutUint32(buf[0:], uint32(navMeshSetV2Magic))
+	little.PutUint32(buf[4:], uint32(navMeshSetV2Version))
+	little.PutUint32(buf[8:], uint32(refSize))
+	little.PutUint32(buf[12:], uint32(len(index)))
+	little.PutUint32(buf[16:], uint32(len(meta)))
+	m.Params.serialize(buf[24:])
+	for i, e := range index {
+		dst := buf[navMeshSetV2HeaderSize+i*entrySize:]
+		putPolyRef(dst, PolyRef(e.Ref))
+		little.PutUint64(dst[refSize:], e.Offset)
+		little.PutUint32(dst[refSize+8:], e.StoredSize)
+		little.PutUint32(dst[refSize+12:], e.DataSize)
+		little.PutUint32(dst[refSize+16:], uint32(e.Compression))
+		little.PutUint32(dst[refSize+20:], e.CRC32)
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if _, err := w.Write(meta); err != nil {
+		return err
+	}
+	for _, data := range blobs {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NavMeshSetReader provides random access to the tiles of a version 2
+// navigation mesh set file.
+type NavMeshSetReader struct {
+	r        io.ReaderAt
+	params   NavMeshParams
+	refSize  int
+	index    []TileIndexEntry
+	fileRefs []uint64 // tile references, as stored in the file
+	metadata *NavMeshMetadata
+}
+
+// NewNavMeshSetReader reads the header, tile index table and metadata of the
+// version 2 navigation mesh set file read by r.
+func NewNavMeshSetReader(r io.ReaderAt) (*NavMeshSetReader, error) {
+	little := binary.LittleEndian
+	hdr := make([]byte, navMeshSetV2HeaderSize)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if magic := int32(little.Uint32(hdr)); magic != navMeshSetV2Magic {
+		return nil, fmt.Errorf("wrong magic number: %x", magic)
+	}
+	if version := int32(little.Uint32(hdr[4:])); version != navMeshSetV2Version {
+		return nil, fmt.Errorf("wrong version: %d", version)
+	}
+
+	sr := &NavMeshSetReader{r: r, refSize: int(little.Uint32(hdr[8:]))}
+	if sr.refSize != 4 && sr.refSize != 8 {
+		return nil, fmt.Errorf("wrong reference size: %d", sr.refSize)
+	}
+	ntiles := int64(little.Uint32(hdr[12:]))
+	metaSize := int64(little.Uint32(hdr[16:]))
+	if err := binary.Read(bytes.NewReader(hdr[24:]), little, &sr.params); err != nil {
+		return nil, err
+	}
+
+	// Check the sizes read from the header before allocating: the tile index
+	// table and the metadata block must fit in the file, or, if its size is
+	// unknown, not exceed maxNavMeshSetIndexSize.
+	if ntiles > int64(sr.params.MaxTiles) {
+		return nil, fmt.Errorf("invalid header: %d tiles, more than the maximum of %d", ntiles, sr.params.MaxTiles)
+	}
+	entrySize := int64(sr.refSize + 24)
+	indexSize := ntiles*entrySize + metaSize
+	size, sizeKnown := readerSize(r)
+	if sizeKnown {
+		if navMeshSetV2HeaderSize+indexSize > size {
+			return nil, fmt.Errorf("invalid header: tile index table and metadata (%d bytes) exceed the file size (%d bytes)", indexSize, size)
+		}
+	} else if indexSize > maxNavMeshSetIndexSize {
+		return nil, fmt.Errorf("invalid header: tile index table and metadata (%d bytes) exceed %d bytes", indexSize, maxNavMeshSetIndexSize)
+	}
+
+	// Read the tile index table and the metadata block.
+	buf := make([]byte, indexSize)
+	if _, err := r.ReadAt(buf, navMeshSetV2HeaderSize); err != nil {
+		return nil, err
+	}
+
+	// Tile references are converted to the size of the references of this
+	// build, using the navigation mesh parameters.
+	var conv NavMesh
+	params := convertParams(sr.params, sr.refSize)
+	if st := conv.Init(&params); StatusFailed(st) {
+		return nil, fmt.Errorf("invalid navmesh parameters, status: 0x%x", st)
+	}
+
+	sr.index = make([]TileIndexEntry, ntiles)
+	sr.fileRefs = make([]uint64, ntiles)
+	for i := range sr.index {
+		src := buf[int64(i)*entrySize:]
+		e := &sr.index[i]
+		sr.fileRefs[i] = readRef(src, sr.refSize)
+		var st Status
+		if e.Ref, st = conv.convertTileRef(sr.fileRefs[i], sr.refSize, &sr.params); StatusFailed(st) {
+			return nil, fmt.Errorf("invalid reference for tile %d, status: 0x%x", i, st)
+		}
+		e.Offset = little.Uint64(src[sr.refSize:])
+		e.StoredSize = little.Uint32(src[sr.refSize+8:])
+		e.DataSize = little.Uint32(src[sr.refSize+12:])
+		e.Compression = Compression(little.Uint32(src[sr.refSize+16:]))
+		e.CRC32 = little.Uint32(src[sr.refSize+20:])
+
+		// The tile data must fit in the file and have a sensible size, since
+		// these sizes are used to allocate it.
+		if e.StoredSize > maxNavMeshSetTileSize || e.DataSize > maxNavMeshSetTileSize {
+			return nil, fmt.Errorf("invalid index: tile %d is too large (%d bytes stored, %d bytes of data)", i, e.StoredSize, e.DataSize)
+		}
+		if e.Compression == CompressionNone && e.StoredSize != e.DataSize {
+			return nil, fmt.Errorf("invalid index: tile %d is not compressed but has %d bytes stored for %d bytes of data", i, e.StoredSize, e.DataSize)
+		}
+		if sizeKnown && (e.Offset > uint64(size) || uint64(e.StoredSize) > uint64(size)-e.Offset) {
+			return nil, fmt.Errorf("invalid index: tile %d (%d bytes at offset %d) exceeds the file size (%d bytes)", i, e.StoredSize, e.Offset, size)
+		}
+	}
+
+	if metaSize != 0 {
+		sr.metadata = new(NavMeshMetadata)
+		if err := json.Unmarshal(buf[ntiles*entrySize:], sr.metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata: %v", err)
+		}
+	}
+	return sr, nil
+}
+
+// readerSize returns the size of the data read by r, and false if it can't be
+// determined.
+func readerSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface {
+		Size() int64
+	}:
+		return r.Size(), true
+	case interface {
+		Stat() (os.FileInfo, error)
+	}:
+		if fi, err := r.Stat(); err == nil {
+			return fi.Size(), true
+		}
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		size, err := r.Seek(0, io.SeekEnd)
+		if _, err2 := r.Seek(cur, io.SeekStart); err != nil || err2 != nil {
+			return 0, false
+		}
+		return size, true
+	}
+	return 0, false
+}
+
+// Params returns the navigation mesh parameters, as stored in the file.
+func (sr *NavMeshSetReader) Params() NavMeshParams {
+	return sr.params
+}
+
+// Metadata returns the metadata block, or nil if the file has none.
+func (sr *NavMeshSetReader) Metadata() *NavMeshMetadata {
+	return sr.metadata
+}
+
+// Index returns the tile index table.
+func (sr *NavMeshSetReader) Index() []TileIndexEntry {
+	return sr.index
+}
+
+// ErrChecksum is the error returned when the data of a tile doesn't match
+// its checksum.
+var ErrChecksum = errors.New("tile data checksum mismatch")
+
+// TileData reads the data of the i-th tile of the index table, decompresses
+// it and verifies its checksum.
+//
+// The returned data can be added to a navigation mesh with AddTile.
+func (sr *NavMeshSetReader) TileData(i int) ([]byte, error) {
+	if i < 0 || i >= len(sr.index) {
+		return nil, fmt.Errorf("tile index %d out of range", i)
+	}
+	e := &sr.index[i]
+	// Read through a section reader, so that a truncated file doesn't cause
+	// the allocation of the whole stored size.
+	data, err := ioutil.ReadAll(io.NewSectionReader(sr.r, int64(e.Offset), int64(e.StoredSize)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(e.StoredSize) {
+		return nil, fmt.Errorf("tile %d: %v", i, io.ErrUnexpectedEOF)
+	}
+	if e.Compression != CompressionNone {
+		comp := compressors[e.Compression]
+		if comp == nil {
+			return nil, fmt.Errorf("tile %d: no compressor registered for compression method %d", i, e.Compression)
+		}
+		if data, err = comp.Decompress(data, int(e.DataSize)); err != nil {
+			return nil, fmt.Errorf("tile %d: %v", i, err)
+		}
+	}
+	if len(data) != int(e.DataSize) || crc32.ChecksumIEEE(data) != e.CRC32 {
+		return nil, fmt.Errorf("tile %d: %v", i, ErrChecksum)
+	}
+	return data, nil
+}
+
+// NavMesh reads all the tiles and returns the navigation mesh.
+func (sr *NavMeshSetReader) NavMesh() (*NavMesh, error) {
+	var mesh NavMesh
+	params := convertParams(sr.params, sr.refSize)
+	if st := mesh.Init(&params); StatusFailed(st) {
+		return nil, fmt.Errorf("status failed 0x%x", st)
+	}
+	for i, e := range sr.index {
+		data, err := sr.TileData(i)
+		if err != nil {
+			return nil, err
+		}
+		if st, _ := mesh.AddTile(data, e.Ref); StatusFailed(st) {
+			return nil, fmt.Errorf("couldn't add tile %d, status: 0x%x", i, st)
+		}
+	}
+	return &mesh, nil
+}
+
+// decodeV2 reads a version 2 navigation mesh set file from r, which magic
+// number has already been read into magic.
+func decodeV2(magic []byte, r io.Reader) (*NavMesh, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := NewNavMeshSetReader(bytes.NewReader(append(magic, buf...)))
+	if err != nil {
+		return nil, err
+	}
+	return sr.NavMesh()
+}
@@ -0,0 +1,196 @@
+package detour
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tileData returns the serialized data of the tile.
+func tileData(tile *MeshTile) []byte {
+	data := make([]byte, tile.DataSize)
+	tile.Header.serialize(data)
+	tile.serialize(data[tile.Header.size():])
+	return data
+}
+
+func TestNavMeshSetV2(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	meta := &NavMeshMetadata{
+		AgentHeight: 2,
+		AgentRadius: 0.6,
+		SourceHash:  "0123abcd",
+		Settings:    "cellsize: 0.3\n",
+		Areas:       []AreaMetadata{{Name: "ground", ID: 1, Flags: 1, Cost: 1}},
+		Extra:       map[string]string{"tool": "test"},
+	}
+
+	for _, comp := range []Compression{CompressionNone, CompressionFlate} {
+		var buf bytes.Buffer
+		checkt(t, mesh.Encode(&buf, SaveOptions{Compression: comp, Metadata: meta}))
+
+		sr, err := NewNavMeshSetReader(bytes.NewReader(buf.Bytes()))
+		checkt(t, err)
+		if sr.Params() != mesh.Params {
+			t.Fatalf("compression %d: got params %+v, want %+v", comp, sr.Params(), mesh.Params)
+		}
+		if got := sr.Metadata(); got == nil || got.SourceHash != meta.SourceHash ||
+			got.AgentRadius != meta.AgentRadius || got.Settings != meta.Settings ||
+			len(got.Areas) != 1 || got.Areas[0] != meta.Areas[0] ||
+			got.Extra["tool"] != "test" {
+			t.Fatalf("compression %d: got metadata %+v, want %+v", comp, got, meta)
+		}
+
+		// random access to the last tile
+		last := len(sr.Index()) - 1
+		e := sr.Index()[last]
+		data, err := sr.TileData(last)
+		checkt(t, err)
+		want := mesh.TileByRef(e.Ref)
+		if want == nil || !bytes.Equal(data, tileData(want)) {
+			t.Fatalf("compression %d: tile %d data differs", comp, last)
+		}
+
+		// full decode
+		got, err := Decode(bytes.NewReader(buf.Bytes()))
+		checkt(t, err)
+		for i := range mesh.Tiles {
+			if mesh.Tiles[i].DataSize == 0 {
+				continue
+			}
+			if !bytes.Equal(tileData(&got.Tiles[i]), tileData(&mesh.Tiles[i])) {
+				t.Errorf("compression %d: tile %d data differs", comp, i)
+			}
+		}
+	}
+}
+
+func TestNavMeshSetV2Checksum(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	var buf bytes.Buffer
+	checkt(t, mesh.Encode(&buf, SaveOptions{}))
+
+	// corrupt the last byte of the first tile
+	sr, err := NewNavMeshSetReader(bytes.NewReader(buf.Bytes()))
+	checkt(t, err)
+	e := sr.Index()[0]
+	raw := buf.Bytes()
+	raw[e.Offset+uint64(e.StoredSize)-1] ^= 0xff
+
+	if _, err = sr.TileData(0); err == nil || !strings.Contains(err.Error(), ErrChecksum.Error()) {
+		t.Fatalf("got error %v, want a checksum error", err)
+	}
+	if _, err = Decode(bytes.NewReader(raw)); err == nil {
+		t.Fatalf("decoding a corrupted file should fail")
+	}
+}
+
+// readerAtOnly hides the methods, other than ReadAt, of a reader.
+type readerAtOnly struct {
+	r io.ReaderAt
+}
+
+func (r readerAtOnly) ReadAt(p []byte, off int64) (int, error) {
+	return r.r.ReadAt(p, off)
+}
+
+func TestNavMeshSetV2InvalidHeader(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	var buf bytes.Buffer
+	checkt(t, mesh.Encode(&buf, SaveOptions{}))
+
+	tests := []struct {
+		name     string
+		ntiles   uint32
+		metaSize uint32
+	}{
+		{"too many tiles", 0xffffffff, 0},
+		{"huge metadata", 1, 0xffffffff},
+		{"metadata past the end", 1, uint32(buf.Len())},
+	}
+	for _, tt := range tests {
+		raw := append([]byte(nil), buf.Bytes()...)
+		binary.LittleEndian.PutUint32(raw[12:], tt.ntiles)
+		binary.LittleEndian.PutUint32(raw[16:], tt.metaSize)
+
+		if _, err := NewNavMeshSetReader(bytes.NewReader(raw)); err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+		if _, err := Decode(bytes.NewReader(raw)); err == nil {
+			t.Errorf("%s: decode: want an error", tt.name)
+		}
+	}
+
+	// the size of the file is unknown
+	raw := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(raw[16:], 0xffffffff)
+	if _, err := NewNavMeshSetReader(readerAtOnly{bytes.NewReader(raw)}); err == nil {
+		t.Errorf("unknown size: want an error")
+	}
+}
+
+func TestNavMeshSetV2InvalidIndex(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	var buf bytes.Buffer
+	checkt(t, mesh.Encode(&buf, SaveOptions{}))
+
+	// fields of the first entry of the tile index table
+	refSize := int(binary.LittleEndian.Uint32(buf.Bytes()[8:]))
+	offset := navMeshSetV2HeaderSize + refSize
+	storedSize := offset + 8
+	dataSize := offset + 12
+
+	tests := []struct {
+		name  string
+		field int
+		val   uint32
+	}{
+		{"huge stored size", storedSize, 0xffffffff},
+		{"huge data size", dataSize, 0xffffffff},
+		{"stored size differs from data size", dataSize, 1},
+		{"tile past the end", offset, uint32(buf.Len())},
+	}
+	for _, tt := range tests {
+		raw := append([]byte(nil), buf.Bytes()...)
+		binary.LittleEndian.PutUint32(raw[tt.field:], tt.val)
+
+		if _, err := NewNavMeshSetReader(bytes.NewReader(raw)); err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+		if _, err := Decode(bytes.NewReader(raw)); err == nil {
+			t.Errorf("%s: decode: want an error", tt.name)
+		}
+	}
+
+	// the size of the file is unknown, the tile data can only be checked
+	// while reading it.
+	raw := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(raw[storedSize:], maxNavMeshSetTileSize)
+	binary.LittleEndian.PutUint32(raw[dataSize:], maxNavMeshSetTileSize)
+	sr, err := NewNavMeshSetReader(readerAtOnly{bytes.NewReader(raw)})
+	checkt(t, err)
+	if _, err := sr.TileData(0); err == nil {
+		t.Errorf("truncated tile: want an error")
+	}
+
+	// the compressed data is shorter than its data size
+	buf.Reset()
+	checkt(t, mesh.Encode(&buf, SaveOptions{Compression: CompressionFlate}))
+	raw = buf.Bytes()
+	binary.LittleEndian.PutUint32(raw[dataSize:], maxNavMeshSetTileSize)
+	sr, err = NewNavMeshSetReader(bytes.NewReader(raw))
+	checkt(t, err)
+	if _, err := sr.TileData(0); err == nil {
+		t.Errorf("short compressed tile: want an error")
+	}
+}
@@ -9,16 +9,6 @@ import (
 	"github.com/arl/math32"
 )
 
-func hashRef(a PolyRef) uint32 {
-	a += ^(a << 15)
-	a ^= (a >> 10)
-	a += (a << 3)
-	a ^= (a >> 6)
-	a += ^(a << 11)
-	a ^= (a >> 16)
-	return uint32(a)
-}
-
 // NodeFlags represent flags associated to a node.
 type NodeFlags uint8
 
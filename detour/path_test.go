@@ -120,6 +120,9 @@ func TestFindPathFindStraightPath(t *testing.T) {
 			t.Fatalf("query.FindPath failed with 0x%x\n", st)
 		}
 
+		for i := range tt.wantPath {
+			tt.wantPath[i] = ref32(mesh, tt.wantPath[i])
+		}
 		if !reflect.DeepEqual(tt.wantPath, path[:pathCount]) {
 			t.Fatalf("found path is not correct, want %#v, got %#v", tt.wantPath, path[:pathCount])
 		}
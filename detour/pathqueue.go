@@ -0,0 +1,353 @@
+package detour
+
+import (
+	"sync"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// PathTicket identifies a path request of a PathQueue. The zero value is not
+// a valid ticket.
+type PathTicket uint32
+
+// PathResult is the result of a path request, delivered on the channel
+// returned by PathQueue.RequestAsync.
+type PathResult struct {
+	Ticket PathTicket
+	Path   []PolyRef // The polygon path, from the start to the end polygon.
+	Status Status    // The status of the search.
+}
+
+// pathRequest is a path request waiting in a PathQueue.
+type pathRequest struct {
+	ticket   PathTicket
+	status   Status // 0 until the search is initialized.
+	startRef PolyRef
+	endRef   PolyRef
+	startPos d3.Vec3
+	endPos   d3.Vec3
+	filter   QueryFilter
+	path     []PolyRef
+	result   chan PathResult // non nil for asynchronous requests.
+}
+
+// PathQueue runs path searches in the background of the application loop.
+//
+// Path requests are queued and return a ticket, each call to Update then
+// spends a fixed number of search iterations on the queued requests, the
+// status and results of which are fetched with the ticket. This allows to
+// spread the cost of many path searches over several frames.
+//
+// Requests are processed one at a time, in the order they have been queued,
+// with a NavMeshQuery of the path queue.
+//
+// A PathQueue can also be updated by its own goroutine, see Start, in which
+// case all its methods can be called concurrently and results of the requests
+// made with RequestAsync are delivered on channels.
+type PathQueue struct {
+	mu          sync.Mutex
+	query       *NavMeshQuery
+	maxPathSize int
+	queue       []pathRequest
+	head        int        // index of the request being processed.
+	nextTicket  PathTicket // next ticket to hand out.
+
+	wake chan struct{} // signals the update goroutine of a new request.
+	stop chan struct{} // closed to stop the update goroutine.
+	done chan struct{} // closed when the update goroutine returns.
+}
+
+// NewPathQueue initializes a path queue.
+//
+//  Arguments:
+//   nav          Pointer to the NavMesh object to search paths on.
+//   maxPathSize  Maximum number of polygons of the resulting paths.
+//   maxQueue     Maximum number of requests in the queue, including the
+//                requests which results have not been fetched yet.
+//   maxNodes     Maximum number of search nodes. [Limits: 0 < value <= 65535]
+//
+// Return the status flags for the initialization of the path queue and the
+// path queue.
+func NewPathQueue(nav *NavMesh, maxPathSize, maxQueue int, maxNodes int32) (Status, *PathQueue) {
+	if maxPathSize <= 0 || maxQueue <= 0 {
+		return Failure | InvalidParam, nil
+	}
+	st, query := NewNavMeshQuery(nav, maxNodes)
+	if StatusFailed(st) {
+		return st, nil
+	}
+	pq := &PathQueue{
+		query:       query,
+		maxPathSize: maxPathSize,
+		queue:       make([]pathRequest, maxQueue),
+		nextTicket:  1,
+	}
+	return Success, pq
+}
+
+// Request queues a path request from startRef to endRef.
+//
+//  Arguments:
+//   startRef  The reference of the start polygon.
+//   endRef    The reference of the end polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   endPos    A position within the end polygon. [(x, y, z)]
+//   filter    The polygon filter to apply to the query.
+//
+// Returns the ticket of the request, and the status, which has the
+// BufferTooSmall flag set if the queue is full.
+func (pq *PathQueue) Request(startRef, endRef PolyRef, startPos, endPos d3.Vec3, filter QueryFilter) (PathTicket, Status) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	return pq.request(startRef, endRef, startPos, endPos, filter, nil)
+}
+
+// RequestAsync queues a path request, like Request, which result is sent on
+// the returned channel once the search is over, instead of being kept in the
+// queue. The channel is nil if the request couldn't be queued.
+func (pq *PathQueue) RequestAsync(startRef, endRef PolyRef, startPos, endPos d3.Vec3, filter QueryFilter) (PathTicket, <-chan PathResult, Status) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	result := make(chan PathResult, 1)
+	ticket, st := pq.request(startRef, endRef, startPos, endPos, filter, result)
+	if StatusFailed(st) {
+		return 0, nil, st
+	}
+	return ticket, result, st
+}
+
+func (pq *PathQueue) request(startRef, endRef PolyRef, startPos, endPos d3.Vec3, filter QueryFilter, result chan PathResult) (PathTicket, Status) {
+	if len(startPos) < 3 || len(endPos) < 3 || filter == nil {
+		return 0, Failure | InvalidParam
+	}
+
+	// Find an empty slot.
+	slot := -1
+	for i := range pq.queue {
+		if pq.queue[i].ticket == 0 {
+			slot = i
+			break
+		}
+	}
+	if slot == -1 {
+		return 0, Failure | BufferTooSmall
+	}
+
+	ticket := pq.nextTicket
+	pq.nextTicket++
+	if pq.nextTicket == 0 {
+		pq.nextTicket = 1
+	}
+
+	req := &pq.queue[slot]
+	*req = pathRequest{
+		ticket:   ticket,
+		startRef: startRef,
+		endRef:   endRef,
+		startPos: d3.NewVec3From(startPos),
+		endPos:   d3.NewVec3From(endPos),
+		filter:   filter,
+		path:     req.path[:0],
+		result:   result,
+	}
+
+	if pq.wake != nil {
+		select {
+		case pq.wake <- struct{}{}:
+		default:
+		}
+	}
+	return ticket, Success
+}
+
+// Update runs the queued path searches.
+//
+//  Arguments:
+//   maxIters  The maximum number of search iterations to spend, over all
+//             the requests.
+//
+// Returns true if some requests are still being processed.
+func (pq *PathQueue) Update(maxIters int) bool {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	return pq.update(maxIters)
+}
+
+func (pq *PathQueue) update(maxIters int) bool {
+	iterCount := maxIters
+	n := len(pq.queue)
+	for i := 0; i < n; i++ {
+		req := &pq.queue[pq.head%n]
+
+		// Skip empty slots and finished requests.
+		if req.ticket == 0 || (req.status != 0 && !StatusInProgress(req.status)) {
+			pq.head = (pq.head + 1) % n
+			continue
+		}
+
+		// Handle query start.
+		if req.status == 0 {
+			req.status = pq.query.InitSlicedFindPath(req.startRef, req.endRef, req.startPos, req.endPos, req.filter, 0)
+		}
+		// Handle query in progress.
+		if StatusInProgress(req.status) {
+			var iters int
+			req.status = pq.query.UpdateSlicedFindPath(iterCount, &iters)
+			iterCount -= iters
+		}
+		if StatusSucceed(req.status) {
+			if cap(req.path) < pq.maxPathSize {
+				req.path = make([]PolyRef, pq.maxPathSize)
+			}
+			req.path = req.path[:pq.maxPathSize]
+			var npath int
+			npath, req.status = pq.query.FinalizeSlicedFindPath(req.path, pq.maxPathSize)
+			req.path = req.path[:npath]
+		}
+		if !StatusInProgress(req.status) && req.result != nil {
+			// Deliver the result of asynchronous requests and free the slot.
+			req.result <- PathResult{
+				Ticket: req.ticket,
+				Path:   append([]PolyRef(nil), req.path...),
+				Status: req.status,
+			}
+			req.ticket = 0
+			req.result = nil
+		}
+
+		if iterCount <= 0 {
+			break
+		}
+		pq.head = (pq.head + 1) % n
+	}
+
+	for i := range pq.queue {
+		if pq.queue[i].ticket != 0 && (pq.queue[i].status == 0 || StatusInProgress(pq.queue[i].status)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestStatus returns the status of the request identified by ticket. The
+// status has the InProgress flag set while the search is running, and is
+// Failure|InvalidParam if the ticket is unknown.
+func (pq *PathQueue) RequestStatus(ticket PathTicket) Status {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	req := pq.find(ticket)
+	if req == nil {
+		return Failure | InvalidParam
+	}
+	if req.status == 0 {
+		return InProgress
+	}
+	return req.status
+}
+
+// Result copies the path found for the request identified by ticket into
+// path, then removes the request from the queue.
+//
+// Returns the number of polygons copied into path and the status of the
+// request. If the search is still running, the status has the InProgress
+// flag set and the request stays in the queue.
+func (pq *PathQueue) Result(ticket PathTicket, path []PolyRef) (int, Status) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	req := pq.find(ticket)
+	if req == nil {
+		return 0, Failure | InvalidParam
+	}
+	if req.status == 0 || StatusInProgress(req.status) {
+		return 0, InProgress
+	}
+
+	st := req.status
+	n := copy(path, req.path)
+	if n < len(req.path) {
+		st |= BufferTooSmall
+	}
+	req.ticket = 0
+	return n, st
+}
+
+// Cancel removes the request identified by ticket from the queue. The result
+// of an asynchronous request is not delivered.
+func (pq *PathQueue) Cancel(ticket PathTicket) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	req := pq.find(ticket)
+	if req == nil {
+		return
+	}
+	if StatusInProgress(req.status) {
+		// Abort the sliced search of the query.
+		pq.query.query = queryData{}
+	}
+	req.ticket = 0
+	req.result = nil
+}
+
+func (pq *PathQueue) find(ticket PathTicket) *pathRequest {
+	if ticket == 0 {
+		return nil
+	}
+	for i := range pq.queue {
+		if pq.queue[i].ticket == ticket {
+			return &pq.queue[i]
+		}
+	}
+	return nil
+}
+
+// Start starts a goroutine that updates the path queue while requests are
+// pending, spending maxIters search iterations per update, until Stop is
+// called. The mutex of the queue is released between updates so that
+// requests can be made in the meantime.
+//
+// Start does nothing if the goroutine is already running.
+func (pq *PathQueue) Start(maxIters int) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if pq.stop != nil {
+		return
+	}
+	pq.wake = make(chan struct{}, 1)
+	pq.stop = make(chan struct{})
+	pq.done = make(chan struct{})
+	go pq.run(maxIters, pq.wake, pq.stop, pq.done)
+}
+
+// Stop stops the update goroutine started by Start, and waits for it to
+// return. Pending requests stay in the queue.
+func (pq *PathQueue) Stop() {
+	pq.mu.Lock()
+	stop, done := pq.stop, pq.done
+	pq.wake, pq.stop, pq.done = nil, nil, nil
+	pq.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (pq *PathQueue) run(maxIters int, wake, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		if !pq.Update(maxIters) {
+			// Wait for a new request.
+			select {
+			case <-wake:
+			case <-stop:
+				return
+			}
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
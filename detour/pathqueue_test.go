@@ -0,0 +1,98 @@
+package detour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestPathQueue(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.Vec3{2, 4, 2}
+
+	locate := func(pos d3.Vec3) (PolyRef, d3.Vec3) {
+		st, ref, pt := query.FindNearestPoly(pos, extents, filter)
+		if StatusFailed(st) || ref == 0 {
+			t.Fatalf("couldn't find nearest poly of %v, status: 0x%x\n", pos, st)
+		}
+		return ref, pt
+	}
+	startRef, startPos := locate(d3.Vec3{37.298489, -1.776901, 11.652311})
+	endRef, endPos := locate(d3.Vec3{42.457218, 7.797607, 17.778244})
+
+	want := make([]PolyRef, 256)
+	n, st := query.FindPath(startRef, endRef, startPos, endPos, filter, want)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed with status 0x%x", st)
+	}
+	want = want[:n]
+
+	st, pq := NewPathQueue(mesh, 256, 2, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("path queue creation failed with status 0x%x\n", st)
+	}
+
+	t1, st := pq.Request(startRef, endRef, startPos, endPos, filter)
+	if StatusFailed(st) {
+		t.Fatalf("Request failed with status 0x%x", st)
+	}
+	t2, st := pq.Request(endRef, startRef, endPos, startPos, filter)
+	if StatusFailed(st) {
+		t.Fatalf("Request failed with status 0x%x", st)
+	}
+	if _, st = pq.Request(startRef, endRef, startPos, endPos, filter); !StatusDetail(st, BufferTooSmall) {
+		t.Errorf("Request on a full queue should fail with BufferTooSmall, got 0x%x", st)
+	}
+
+	// a small iteration budget requires several updates.
+	updates := 0
+	for pq.Update(2) {
+		updates++
+		if updates > 1000 {
+			t.Fatalf("path queue never completes")
+		}
+	}
+	if updates < 2 {
+		t.Errorf("requests completed in %d updates, expected more", updates)
+	}
+
+	path := make([]PolyRef, 256)
+	n, st = pq.Result(t1, path)
+	if StatusFailed(st) || StatusInProgress(st) {
+		t.Fatalf("Result failed with status 0x%x", st)
+	}
+	if !reflect.DeepEqual(path[:n], want) {
+		t.Errorf("path = %v, want %v", path[:n], want)
+	}
+	if st = pq.RequestStatus(t1); !StatusDetail(st, InvalidParam) {
+		t.Errorf("ticket should be unknown once its result is fetched, got status 0x%x", st)
+	}
+	if st = pq.RequestStatus(t2); !StatusSucceed(st) {
+		t.Errorf("second request status = 0x%x, want success", st)
+	}
+	if n, _ = pq.Result(t2, path); path[0] != endRef || path[n-1] != startRef {
+		t.Errorf("reverse path %v doesn't go from %v to %v", path[:n], endRef, startRef)
+	}
+
+	// goroutine mode.
+	pq.Start(4)
+	defer pq.Stop()
+	_, results, st := pq.RequestAsync(startRef, endRef, startPos, endPos, filter)
+	if StatusFailed(st) {
+		t.Fatalf("RequestAsync failed with status 0x%x", st)
+	}
+	res := <-results
+	if StatusFailed(res.Status) {
+		t.Fatalf("async request failed with status 0x%x", res.Status)
+	}
+	if !reflect.DeepEqual(res.Path, want) {
+		t.Errorf("async path = %v, want %v", res.Path, want)
+	}
+}
@@ -0,0 +1,224 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// PathSearchOptions are the options of NavMeshQuery.FindPathWithOptions,
+// trading the path quality for the search speed.
+//
+// The zero value gives the same search as NavMeshQuery.FindPath.
+type PathSearchOptions struct {
+	// HeuristicWeight is the factor applied to the distance to the goal to
+	// estimate the remaining cost. 0 means HScale. Values greater than 1
+	// give a weighted A* search, expanding less nodes but finding paths that
+	// may cost up to HeuristicWeight times the cost of the optimal path.
+	HeuristicWeight float32
+
+	// Bidirectional runs two searches, from the start and from the end,
+	// until they meet. This mostly expands less nodes when the start or the
+	// end is in a dead end. It allocates a second node pool, as big as the
+	// query node pool, on first use.
+	Bidirectional bool
+
+	// MaxCost is the cost above which nodes are not expanded anymore, 0
+	// meaning no limit. If the end can't be reached within that cost, the
+	// result is a partial path.
+	MaxCost float32
+
+	// MaxIterations is the maximum number of nodes the search expands, 0
+	// meaning no limit. If the search is stopped before reaching the end,
+	// the result is a partial path.
+	MaxIterations int
+}
+
+// heuristicScale returns the scale of the search heuristic.
+func (opts *PathSearchOptions) heuristicScale() float32 {
+	if opts.HeuristicWeight > 0 {
+		return opts.HeuristicWeight
+	}
+	return HScale
+}
+
+// PathSearchStats reports how a path search went.
+type PathSearchStats struct {
+	// NodesExpanded is the number of nodes removed from the open lists and
+	// expanded.
+	NodesExpanded int
+
+	// PathCost is the cost of the found path, from the start position to the
+	// end position for a complete path or to the last polygon for a partial
+	// one.
+	PathCost float32
+}
+
+// FindPathWithOptions finds a path from the start polygon to the end polygon,
+// with the search options opts.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   endRef    The reference id of the end polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   endPos    A position within the end polygon. [(x, y, z)]
+//   filter    The polygon filter to apply to the query.
+//   path      This slice will be filled with an ordered list of polygon
+//             references representing the path. (Start to end.)
+//   opts      The search options, nil giving the same search as FindPath.
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   stats     the search statistics.
+//   st        status code (may be a partial result)
+//
+// See FindPath for the description of the partial results.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindPathWithOptions(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef,
+	opts *PathSearchOptions) (pathCount int, stats PathSearchStats, st Status) {
+
+	// Validate input
+	if !q.nav.IsValidPolyRef(startRef) || !q.nav.IsValidPolyRef(endRef) ||
+		len(startPos) < 3 || len(endPos) < 3 || filter == nil || path == nil || len(path) == 0 {
+		return 0, stats, Failure | InvalidParam
+	}
+	if opts == nil {
+		opts = &PathSearchOptions{}
+	}
+	if opts.HeuristicWeight < 0 || opts.MaxCost < 0 || opts.MaxIterations < 0 {
+		return 0, stats, Failure | InvalidParam
+	}
+
+	if startRef == endRef {
+		path[0] = startRef
+		return 1, stats, Success
+	}
+
+	if opts.Bidirectional {
+		pathCount, st = q.findPathBidirectional(startRef, endRef, startPos, endPos, filter, path, opts, &stats)
+	} else {
+		pathCount, st = q.findPath(startRef, endRef, startPos, endPos, filter, path, opts, &stats)
+	}
+	return pathCount, stats, st
+}
+
+// findPathBidirectional implements the bidirectional search of
+// FindPathWithOptions.
+//
+// The forward search uses the query node pool and open list, and the
+// backward search, from the end polygon, follows the links backward. The
+// search stops when the cost of the best path going through a polygon
+// reached by both searches can't be improved anymore.
+func (q *NavMeshQuery) findPathBidirectional(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef,
+	opts *PathSearchOptions,
+	stats *PathSearchStats) (pathCount int, st Status) {
+
+	if q.backNodePool == nil {
+		maxNodes := q.nodePool.MaxNodes()
+		q.backNodePool = newNodePool(maxNodes, q.nodePool.HashSize())
+		q.backOpenList = newnodeQueue(maxNodes)
+	}
+	hscale := opts.heuristicScale()
+
+	// best path found so far, going through the meeting nodes.
+	var meetFwd, meetBwd *Node
+	best := math32.MaxFloat32
+
+	fwd := q.newGraphSearch(filter)
+	bwd := &graphSearch{q: q, pool: q.backNodePool, open: q.backOpenList, filter: filter, backward: true}
+	for _, side := range []*graphSearch{fwd, bwd} {
+		side, other, target := side, bwd, endPos
+		if side.backward {
+			other, target = fwd, startPos
+		}
+		side.maxCost = opts.MaxCost
+		side.estimate = func(_, nei *searchPoly, cost float32) (float32, float32, bool) {
+			return cost, nei.node.Pos.Dist(target) * hscale, true
+		}
+		side.visit = func(_, nei *searchPoly) {
+			// Check whether the other side reached the polygon too.
+			var others [maxStatesPerNode]*Node
+			n := other.pool.FindNodes(nei.ref, others[:], maxStatesPerNode)
+			for _, o := range others[:n] {
+				if o.Flags == 0 {
+					continue
+				}
+				f, b := nei.node, o
+				if side.backward {
+					f, b = o, nei.node
+				}
+				cost := f.Cost + b.Cost + filter.Cost(f.Pos, b.Pos,
+					0, nil, nil,
+					nei.ref, nei.tile, nei.poly,
+					0, nil, nil)
+				if cost < best {
+					best = cost
+					meetFwd, meetBwd = f, b
+				}
+			}
+		}
+	}
+
+	fwd.reset()
+	fwd.push(startRef, startPos, 0, startPos.Dist(endPos)*hscale)
+	bwd.reset()
+	bwd.push(endRef, endPos, 0, endPos.Dist(startPos)*hscale)
+
+	for !fwd.open.empty() && !bwd.open.empty() {
+		// Stop when no node can lead to a better path.
+		if meetFwd != nil && (fwd.open.top().Total >= best || bwd.open.top().Total >= best) {
+			break
+		}
+		if opts.MaxIterations > 0 && stats.NodesExpanded >= opts.MaxIterations {
+			break
+		}
+		stats.NodesExpanded++
+
+		// Expand the side having the cheapest node, the parent of a node
+		// being the next polygon toward the end for the backward search.
+		side := fwd
+		if bwd.open.top().Total < fwd.open.top().Total {
+			side = bwd
+		}
+		bestNode := side.pop()
+		side.expand(bestNode, side.parentRef(bestNode))
+	}
+	if fwd.outOfNodes || bwd.outOfNodes {
+		st |= OutOfNodes
+	}
+	if meetFwd == nil {
+		// The searches didn't meet, return the path toward the end.
+		pathCount, status := q.pathToNode(fwd.lastBest, path)
+		stats.PathCost = fwd.lastBest.Cost
+		return pathCount, status | PartialResult | st
+	}
+
+	// Join the forward path, to the meeting node, and the backward path,
+	// from the meeting node.
+	var refs []PolyRef
+	for node := meetFwd; node != nil; node = fwd.pool.NodeAtIdx(int32(node.PIdx)) {
+		refs = append(refs, node.ID)
+	}
+	for i, j := 0, len(refs)-1; i < j; i, j = i+1, j-1 {
+		refs[i], refs[j] = refs[j], refs[i]
+	}
+	for node := bwd.pool.NodeAtIdx(int32(meetBwd.PIdx)); node != nil; node = bwd.pool.NodeAtIdx(int32(node.PIdx)) {
+		refs = append(refs, node.ID)
+	}
+
+	pathCount = copy(path, refs)
+	stats.PathCost = best
+	st |= Success
+	if pathCount < len(refs) {
+		st |= BufferTooSmall
+	}
+	return pathCount, st
+}
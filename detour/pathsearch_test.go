@@ -0,0 +1,112 @@
+package detour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestFindPathWithOptions(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	filter := NewStandardQueryFilter()
+
+	// gather the ground polygons, with their centers
+	type poly struct {
+		ref PolyRef
+		pos d3.Vec3
+	}
+	var polys []poly
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		base := mesh.PolyRefBase(tile)
+		for j := int32(0); j < tile.Header.PolyCount; j++ {
+			p := &tile.Polys[j]
+			if p.Type() != polyTypeOffMeshConnection {
+				polys = append(polys, poly{
+					ref: base | PolyRef(j),
+					pos: CalcPolyCenter(p.Verts[:], int32(p.VertCount), tile.Verts),
+				})
+			}
+		}
+	}
+
+	path := make([]PolyRef, 512)
+	ref := make([]PolyRef, 512)
+	var complete int
+	for i := 0; i < len(polys); i += 7 {
+		org, dst := polys[i], polys[(i*31+17)%len(polys)]
+
+		n, st := query.FindPath(org.ref, dst.ref, org.pos, dst.pos, filter, ref)
+		if StatusFailed(st) {
+			t.Fatalf("%v -> %v: FindPath failed with status 0x%x", org.ref, dst.ref, st)
+		}
+		want := append([]PolyRef(nil), ref[:n]...)
+		partial := StatusDetail(st, PartialResult)
+
+		// default options give the same result as FindPath.
+		n, stats, st := query.FindPathWithOptions(org.ref, dst.ref, org.pos, dst.pos, filter, path, nil)
+		if StatusFailed(st) || !reflect.DeepEqual(path[:n], want) {
+			t.Fatalf("%v -> %v: got path %v, want %v (status 0x%x)", org.ref, dst.ref, path[:n], want, st)
+		}
+		if partial || org.ref == dst.ref {
+			continue
+		}
+		complete++
+		optimal := stats.PathCost
+
+		for _, opts := range []PathSearchOptions{
+			{Bidirectional: true},
+			{HeuristicWeight: 2},
+			{HeuristicWeight: 2, Bidirectional: true},
+		} {
+			n, stats, st := query.FindPathWithOptions(org.ref, dst.ref, org.pos, dst.pos, filter, path, &opts)
+			if StatusFailed(st) || StatusDetail(st, PartialResult) {
+				t.Fatalf("%v -> %v, %+v: got status 0x%x", org.ref, dst.ref, opts, st)
+			}
+			if path[0] != org.ref || path[n-1] != dst.ref {
+				t.Fatalf("%v -> %v, %+v: path goes from %v to %v", org.ref, dst.ref, opts, path[0], path[n-1])
+			}
+			for j := 1; j < n; j++ {
+				if !arePolysLinked(mesh, path[j-1], path[j]) {
+					t.Fatalf("%v -> %v, %+v: path polygons %v and %v are not linked", org.ref, dst.ref, opts, path[j-1], path[j])
+				}
+			}
+			// allow for the slight difference between the costs computed
+			// by the forward and backward searches.
+			maxCost := optimal * 1.05
+			if opts.HeuristicWeight > 0 {
+				maxCost = optimal * opts.HeuristicWeight
+			}
+			if stats.PathCost > maxCost+1e-3 {
+				t.Errorf("%v -> %v, %+v: path cost %f, optimal %f", org.ref, dst.ref, opts, stats.PathCost, optimal)
+			}
+		}
+
+		// limit the search
+		n, stats, st = query.FindPathWithOptions(org.ref, dst.ref, org.pos, dst.pos, filter, path,
+			&PathSearchOptions{MaxIterations: 1})
+		if stats.NodesExpanded != 1 {
+			t.Errorf("%v -> %v: got %d nodes expanded, want 1", org.ref, dst.ref, stats.NodesExpanded)
+		}
+		if n > 2 && !StatusDetail(st, PartialResult) {
+			t.Errorf("%v -> %v: path with 1 iteration should be partial", org.ref, dst.ref)
+		}
+		_, _, st = query.FindPathWithOptions(org.ref, dst.ref, org.pos, dst.pos, filter, path,
+			&PathSearchOptions{MaxCost: optimal * 0.5})
+		if !StatusDetail(st, PartialResult) {
+			t.Errorf("%v -> %v: path limited to half its cost should be partial", org.ref, dst.ref)
+		}
+	}
+	if complete == 0 {
+		t.Fatalf("no complete path found")
+	}
+}
@@ -0,0 +1,429 @@
+package detour
+
+import (
+	"math"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// The methods of this file post-process the straight paths returned by
+// FindStraightPath, for agents that can't follow sharp polyline corners.
+//
+// They all take the path points along with the reference ids of the polygons
+// containing them, as returned by FindStraightPath in straightPathRefs, and
+// keep the processed paths on the navigation mesh by casting rays along the
+// modified segments, leaving the original path where a ray hits a wall.
+
+// Path smoothing curves, for NavMeshQuery.SmoothPath.
+const (
+	// SmoothCatmullRom interpolates the path points with a Catmull-Rom
+	// spline, the smoothed path going through all the path points.
+	SmoothCatmullRom = iota
+
+	// SmoothBezier uses the path corners as the control points of quadratic
+	// Bezier curves joining the middles of the path segments, the smoothed
+	// path cutting the corners. As the straight path corners lie on the
+	// walls, the curves mostly stay on the mesh once the corners have been
+	// moved with OffsetPathCorners.
+	SmoothBezier
+)
+
+// pathRaycastSize is the number of polygons the rays cast by the path
+// post-processing methods can visit.
+const pathRaycastSize = 256
+
+// OffsetPathCorners moves the corners of a straight path away from the walls
+// they turn around, so that an agent of the given radius following the path
+// doesn't collide with the corners.
+//
+//  Arguments:
+//   straightPath      The straight path points, modified in place.
+//   straightPathFlags The flags of the points, as returned by
+//                     FindStraightPath.
+//   straightPathRefs  The reference ids of the polygons containing the
+//                     points, updated in place.
+//   filter            The polygon filter to apply to the query.
+//   radius            The agent radius.
+//
+// The start and end points, as well as the points at the ends of off-mesh
+// connections, are not moved. A corner is moved by at most half the length of
+// its adjacent segments, and less if it would leave the navigation mesh.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) OffsetPathCorners(
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	filter QueryFilter,
+	radius float32) Status {
+
+	n := len(straightPath)
+	if len(straightPathFlags) < n || len(straightPathRefs) < n || filter == nil || radius < 0 {
+		return Failure | InvalidParam
+	}
+
+	buf := make([]PolyRef, pathRaycastSize)
+	for i := 1; i < n-1; i++ {
+		if straightPathFlags[i]&(StraightPathStart|StraightPathEnd|StraightPathOffMeshConnection) != 0 ||
+			straightPathFlags[i-1]&StraightPathOffMeshConnection != 0 {
+			continue
+		}
+
+		cur := straightPath[i]
+		d0x, d0z, len0 := dir2D(straightPath[i-1], cur)
+		d1x, d1z, len1 := dir2D(cur, straightPath[i+1])
+		if len0 < 1e-6 || len1 < 1e-6 {
+			continue
+		}
+		if math32.Abs(d0x*d1z-d0z*d1x) < 1e-3 {
+			// no turn at this point (area crossing).
+			continue
+		}
+
+		// The bisector of the turn points toward the corner wall, move the
+		// point the other way.
+		bx, bz := d1x-d0x, d1z-d0z
+		bl := math32.Sqrt(bx*bx + bz*bz)
+		dist := math32.Min(radius, math32.Min(len0, len1)*0.5)
+		target := d3.Vec3{cur[0] - bx/bl*dist, cur[1], cur[2] - bz/bl*dist}
+
+		hit := RaycastHit{Path: buf, MaxPath: len(buf)}
+		st := q.raycast(straightPathRefs[i], cur, target, filter, 0, 0, &hit)
+		if StatusFailed(st) || st&BufferTooSmall != 0 || hit.PathCount == 0 {
+			continue
+		}
+		if hit.T != math.MaxFloat32 {
+			// Stop before the wall.
+			t := hit.T * 0.9
+			if t <= 0 {
+				continue
+			}
+			target = cur.Lerp(target, t)
+		}
+		ref := hit.Path[hit.PathCount-1]
+		if h, st := q.PolyHeight(ref, target); StatusSucceed(st) {
+			target[1] = h
+		}
+		cur.Assign(target)
+		straightPathRefs[i] = ref
+	}
+	return Success
+}
+
+// SmoothPath returns a smoothed version of a straight path.
+//
+//  Arguments:
+//   straightPath      The straight path points.
+//   straightPathRefs  The reference ids of the polygons containing the
+//                     points.
+//   filter            The polygon filter to apply to the query.
+//   curve             The smoothing curve, SmoothCatmullRom or SmoothBezier.
+//   subdivs           The number of points generated for each curve
+//                     segment. [Limit: > 0]
+//
+//  Returns:
+//   points   The smoothed path points.
+//   refs     The reference ids of the polygons containing the points.
+//   st       The status flags for the query.
+//
+// The curves are sampled, then each sample is checked with a raycast from the
+// previous one. If a curve segment doesn't stay on the navigation mesh, the
+// original path is kept for that segment. The height of the generated points
+// is taken from the detail mesh.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) SmoothPath(
+	straightPath []d3.Vec3,
+	straightPathRefs []PolyRef,
+	filter QueryFilter,
+	curve, subdivs int) (points []d3.Vec3, refs []PolyRef, st Status) {
+
+	n := len(straightPath)
+	if n == 0 || len(straightPathRefs) < n || filter == nil || subdivs <= 0 {
+		return nil, nil, Failure | InvalidParam
+	}
+
+	ps := &pathSmoother{
+		q:      q,
+		filter: filter,
+		buf:    make([]PolyRef, pathRaycastSize),
+	}
+	ps.add(straightPath[0], straightPathRefs[0])
+	if n < 3 {
+		for i := 1; i < n; i++ {
+			ps.add(straightPath[i], straightPathRefs[i])
+		}
+		return ps.points, ps.refs, Success
+	}
+
+	switch curve {
+	case SmoothCatmullRom:
+		ps.catmullRom(straightPath, straightPathRefs, subdivs)
+	case SmoothBezier:
+		ps.bezier(straightPath, straightPathRefs, subdivs)
+	default:
+		return nil, nil, Failure | InvalidParam
+	}
+	return ps.points, ps.refs, Success
+}
+
+// pathSmoother accumulates the points of a smoothed path.
+type pathSmoother struct {
+	q      *NavMeshQuery
+	filter QueryFilter
+	buf    []PolyRef
+	points []d3.Vec3
+	refs   []PolyRef
+	span   []d3.Vec3 // samples of the current curve segment
+}
+
+func (ps *pathSmoother) add(pt d3.Vec3, ref PolyRef) {
+	ps.points = append(ps.points, d3.NewVec3From(pt))
+	ps.refs = append(ps.refs, ref)
+}
+
+// tryAddSpan checks that the samples of the current curve segment, starting
+// from pos in the polygon ref, stay on the navigation mesh and adds them if
+// they do.
+func (ps *pathSmoother) tryAddSpan(pos d3.Vec3, ref PolyRef) bool {
+	if ref == 0 {
+		return false
+	}
+	refs := make([]PolyRef, len(ps.span))
+	prev, prevRef := pos, ref
+	for i, pt := range ps.span {
+		r := ps.q.raycastRef(prevRef, prev, pt, ps.filter, ps.buf)
+		if r == 0 {
+			return false
+		}
+		if h, st := ps.q.PolyHeight(r, pt); StatusSucceed(st) {
+			pt[1] = h
+		}
+		refs[i] = r
+		prev, prevRef = pt, r
+	}
+	for i, pt := range ps.span {
+		ps.add(pt, refs[i])
+	}
+	return true
+}
+
+// catmullRom adds the Catmull-Rom spline going through the path points.
+func (ps *pathSmoother) catmullRom(path []d3.Vec3, refs []PolyRef, subdivs int) {
+	n := len(path)
+	for i := 0; i < n-1; i++ {
+		// duplicate the end points to get the tangents at the path ends.
+		p0, p1, p2, p3 := path[i], path[i], path[i+1], path[i+1]
+		if i > 0 {
+			p0 = path[i-1]
+		}
+		if i+2 < n {
+			p3 = path[i+2]
+		}
+
+		ps.span = ps.span[:0]
+		for j := 1; j <= subdivs; j++ {
+			t := float32(j) / float32(subdivs)
+			t2 := t * t
+			t3 := t2 * t
+			pt := d3.NewVec3()
+			for k := 0; k < 3; k++ {
+				pt[k] = 0.5 * (2*p1[k] +
+					(p2[k]-p0[k])*t +
+					(2*p0[k]-5*p1[k]+4*p2[k]-p3[k])*t2 +
+					(3*p1[k]-p0[k]-3*p2[k]+p3[k])*t3)
+			}
+			ps.span = append(ps.span, pt)
+		}
+		ps.span[len(ps.span)-1].Assign(p2)
+
+		if !ps.tryAddSpan(p1, refs[i]) {
+			ps.add(p2, refs[i+1])
+		} else if refs[i+1] != 0 {
+			// the path point polygon is more reliable than the one found
+			// by the raycast, as the point may lie on a polygon edge.
+			ps.refs[len(ps.refs)-1] = refs[i+1]
+		}
+	}
+}
+
+// bezier adds the quadratic Bezier curves joining the middles of the path
+// segments, with the path corners as control points.
+func (ps *pathSmoother) bezier(path []d3.Vec3, refs []PolyRef, subdivs int) {
+	n := len(path)
+
+	// Locate the middles of the segments.
+	mids := make([]d3.Vec3, n-1)
+	midRefs := make([]PolyRef, n-1)
+	for i := range mids {
+		mids[i] = path[i].Lerp(path[i+1], 0.5)
+		midRefs[i] = ps.q.locateRef(refs[i], path[i], mids[i], ps.filter, ps.buf)
+		if midRefs[i] != 0 {
+			if h, st := ps.q.PolyHeight(midRefs[i], mids[i]); StatusSucceed(st) {
+				mids[i][1] = h
+			}
+		}
+	}
+
+	for k := 1; k < n-1; k++ {
+		a, aref := mids[k-1], midRefs[k-1]
+		if k == 1 {
+			a, aref = path[0], refs[0]
+		}
+		b, bref := mids[k], midRefs[k]
+		if k == n-2 {
+			b, bref = path[n-1], PolyRef(0)
+		}
+		c := path[k]
+
+		ps.span = ps.span[:0]
+		for j := 1; j <= subdivs; j++ {
+			t := float32(j) / float32(subdivs)
+			u := 1 - t
+			pt := d3.NewVec3()
+			for i := 0; i < 3; i++ {
+				pt[i] = u*u*a[i] + 2*u*t*c[i] + t*t*b[i]
+			}
+			ps.span = append(ps.span, pt)
+		}
+		ps.span[len(ps.span)-1].Assign(b)
+
+		if !ps.tryAddSpan(a, aref) {
+			ps.add(c, refs[k])
+			if k == n-2 {
+				bref = refs[n-1]
+				if bref == 0 {
+					bref = ps.q.locateRef(refs[k], c, b, ps.filter, ps.buf)
+				}
+			}
+			ps.add(b, bref)
+		}
+	}
+}
+
+// ResamplePath returns the points placed at regular intervals along a path.
+//
+//  Arguments:
+//   path      The path points.
+//   pathRefs  The reference ids of the polygons containing the points.
+//   filter    The polygon filter to apply to the query.
+//   dist      The distance between 2 consecutive points, measured along the
+//             path. [Limit: > 0]
+//
+//  Returns:
+//   points   The resampled path points, the first and last points being
+//            those of path.
+//   refs     The reference ids of the polygons containing the points.
+//   st       The status flags for the query.
+//
+// The height of the generated points is taken from the detail mesh, so that
+// the resampled path follows the surface more closely than the original path.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) ResamplePath(
+	path []d3.Vec3,
+	pathRefs []PolyRef,
+	filter QueryFilter,
+	dist float32) (points []d3.Vec3, refs []PolyRef, st Status) {
+
+	n := len(path)
+	if n == 0 || len(pathRefs) < n || filter == nil || dist <= 0 {
+		return nil, nil, Failure | InvalidParam
+	}
+
+	buf := make([]PolyRef, pathRaycastSize)
+	points = append(points, d3.NewVec3From(path[0]))
+	refs = append(refs, pathRefs[0])
+
+	// distance travelled on the current segment before the next point.
+	next := dist
+	for i := 0; i < n-1; i++ {
+		pa, pb := path[i], path[i+1]
+		seglen := pa.Dist(pb)
+		for ; next < seglen; next += dist {
+			pt := pa.Lerp(pb, next/seglen)
+			ref := q.locateRef(pathRefs[i], pa, pt, filter, buf)
+			if ref != 0 {
+				if h, st := q.PolyHeight(ref, pt); StatusSucceed(st) {
+					pt[1] = h
+				}
+			} else {
+				st |= PartialResult
+			}
+			points = append(points, pt)
+			refs = append(refs, ref)
+		}
+		next -= seglen
+	}
+
+	last, lastRef := path[n-1], pathRefs[n-1]
+	if lastRef == 0 && n > 1 {
+		// FindStraightPath doesn't give the polygon of the end point.
+		lastRef = q.locateRef(pathRefs[n-2], path[n-2], last, filter, buf)
+	}
+	if points[len(points)-1].Dist(last) > dist*0.01 {
+		points = append(points, d3.NewVec3From(last))
+		refs = append(refs, lastRef)
+	} else {
+		points[len(points)-1].Assign(last)
+		refs[len(refs)-1] = lastRef
+	}
+	return points, refs, Success | st
+}
+
+// raycastRef casts a ray from pos, in the polygon ref, toward dst and returns
+// the reference id of the polygon containing dst, or 0 if the ray hits a wall
+// or visits more polygons than buf can hold.
+func (q *NavMeshQuery) raycastRef(ref PolyRef, pos, dst d3.Vec3, filter QueryFilter, buf []PolyRef) PolyRef {
+	if ref == 0 {
+		return 0
+	}
+	if pos.Dist2DSqr(dst) < 1e-12 {
+		return ref
+	}
+	hit := RaycastHit{Path: buf, MaxPath: len(buf)}
+	st := q.raycast(ref, pos, dst, filter, 0, 0, &hit)
+	if StatusFailed(st) || st&BufferTooSmall != 0 || hit.T != math.MaxFloat32 || hit.PathCount == 0 {
+		return 0
+	}
+	return hit.Path[hit.PathCount-1]
+}
+
+// locateRef returns the reference id of the polygon containing dst, which
+// must be reachable in straight line from pos, in the polygon ref.
+//
+// The straight path segments often run along the polygon edges, where the
+// raycasts are not reliable, so if the ray fails to reach dst, the polygon is
+// searched around dst instead.
+func (q *NavMeshQuery) locateRef(ref PolyRef, pos, dst d3.Vec3, filter QueryFilter, buf []PolyRef) PolyRef {
+	if r := q.raycastRef(ref, pos, dst, filter, buf); r != 0 {
+		return r
+	}
+
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+		return 0
+	}
+	ext := d3.Vec3{tile.Header.WalkableRadius, tile.Header.WalkableClimb, tile.Header.WalkableRadius}
+	st, nearest, pt := q.FindNearestPoly(dst, ext, filter)
+	if StatusFailed(st) || nearest == 0 || pt.Dist2DSqr(dst) > 1e-6 {
+		return 0
+	}
+	return nearest
+}
+
+// dir2D returns the normalized direction from a to b in the xz-plane, and the
+// distance between a and b in that plane.
+func dir2D(a, b d3.Vec3) (dx, dz, l float32) {
+	dx, dz = b[0]-a[0], b[2]-a[2]
+	l = math32.Sqrt(dx*dx + dz*dz)
+	if l > 0 {
+		dx /= l
+		dz /= l
+	}
+	return dx, dz, l
+}
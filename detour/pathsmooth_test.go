@@ -0,0 +1,179 @@
+package detour
+
+import (
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// testStraightPath returns a query on mesh1.bin and the straight path between
+// the ends of the path tested in TestFindPathFindStraightPath.
+func testStraightPath(t *testing.T) (*NavMeshQuery, QueryFilter, []d3.Vec3, []uint8, []PolyRef) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	filter := NewStandardQueryFilter()
+	extents := d3.Vec3{2, 4, 2}
+	st, orgRef, org := query.FindNearestPoly(d3.Vec3{37.298489, -1.776901, 11.652311}, extents, filter)
+	if StatusFailed(st) {
+		t.Fatalf("FindNearestPoly failed, status: 0x%x\n", st)
+	}
+	st, dstRef, dst := query.FindNearestPoly(d3.Vec3{42.457218, 7.797607, 17.778244}, extents, filter)
+	if StatusFailed(st) {
+		t.Fatalf("FindNearestPoly failed, status: 0x%x\n", st)
+	}
+
+	path := make([]PolyRef, 100)
+	n, st := query.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed with 0x%x\n", st)
+	}
+
+	straightPath := make([]d3.Vec3, 100)
+	for i := range straightPath {
+		straightPath[i] = d3.NewVec3()
+	}
+	flags := make([]uint8, 100)
+	refs := make([]PolyRef, 100)
+	count, st := query.FindStraightPath(org, dst, path[:n], straightPath, flags, refs, 0)
+	if StatusFailed(st) {
+		t.Fatalf("FindStraightPath failed with 0x%x\n", st)
+	}
+	return query, filter, straightPath[:count], flags[:count], refs[:count]
+}
+
+func TestOffsetPathCorners(t *testing.T) {
+	query, filter, path, flags, refs := testStraightPath(t)
+	orig := make([]d3.Vec3, len(path))
+	for i := range path {
+		orig[i] = d3.NewVec3From(path[i])
+	}
+
+	const radius = 0.6
+	if st := query.OffsetPathCorners(path, flags, refs, filter, radius); StatusFailed(st) {
+		t.Fatalf("OffsetPathCorners failed with 0x%x", st)
+	}
+
+	if !path[0].Approx(orig[0]) || !path[len(path)-1].Approx(orig[len(orig)-1]) {
+		t.Errorf("path ends should not move")
+	}
+	moved := 0
+	for i := range path {
+		d := path[i].Dist2D(orig[i])
+		if d > radius+1e-4 {
+			t.Errorf("corner %d moved by %f, want at most %f", i, d, radius)
+		}
+		if d > 1e-4 {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Errorf("no corner has been moved")
+	}
+}
+
+func TestSmoothPath(t *testing.T) {
+	query, filter, path, flags, refs := testStraightPath(t)
+	if st := query.OffsetPathCorners(path, flags, refs, filter, 0.6); StatusFailed(st) {
+		t.Fatalf("OffsetPathCorners failed with 0x%x", st)
+	}
+
+	for _, curve := range []int{SmoothCatmullRom, SmoothBezier} {
+		points, prefs, st := query.SmoothPath(path, refs, filter, curve, 4)
+		if StatusFailed(st) {
+			t.Fatalf("curve %d: SmoothPath failed with 0x%x", curve, st)
+		}
+		if len(points) != len(prefs) {
+			t.Fatalf("curve %d: got %d points and %d refs", curve, len(points), len(prefs))
+		}
+		if len(points) <= len(path) {
+			t.Errorf("curve %d: got %d points, want more than %d", curve, len(points), len(path))
+		}
+		for i, ref := range prefs {
+			if ref == 0 {
+				t.Errorf("curve %d: point %d has no polygon", curve, i)
+			}
+		}
+		if !points[0].Approx(path[0]) {
+			t.Errorf("curve %d: first point = %v, want %v", curve, points[0], path[0])
+		}
+		if last := points[len(points)-1]; last.Dist2D(path[len(path)-1]) > 1e-4 {
+			t.Errorf("curve %d: last point = %v, want %v", curve, last, path[len(path)-1])
+		}
+	}
+
+	if _, _, st := query.SmoothPath(path, refs, filter, 42, 4); !StatusFailed(st) {
+		t.Errorf("SmoothPath should fail with an unknown curve")
+	}
+}
+
+func TestResamplePath(t *testing.T) {
+	query, filter, path, _, refs := testStraightPath(t)
+
+	const dist = 0.5
+	points, prefs, st := query.ResamplePath(path, refs, filter, dist)
+	if StatusFailed(st) {
+		t.Fatalf("ResamplePath failed with 0x%x", st)
+	}
+	if !points[0].Approx(path[0]) || !points[len(points)-1].Approx(path[len(path)-1]) {
+		t.Errorf("resampled path should keep the path ends")
+	}
+
+	for i := 1; i < len(points)-1; i++ {
+		if d := points[i].Dist2D(points[i-1]); d > dist+1e-3 {
+			t.Errorf("points %d and %d are %f apart, want at most %f", i-1, i, d, dist)
+		}
+		if prefs[i] == 0 {
+			t.Errorf("point %d has no polygon", i)
+			continue
+		}
+		h, st := query.PolyHeight(prefs[i], points[i])
+		if StatusFailed(st) {
+			t.Errorf("point %d is not over its polygon %v", i, prefs[i])
+		} else if h != points[i][1] {
+			t.Errorf("point %d height = %f, want %f", i, points[i][1], h)
+		}
+	}
+}
+
+func TestPolyHeightVerticalOffMeshConnection(t *testing.T) {
+	mesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		for j := int32(0); j < tile.Header.PolyCount; j++ {
+			p := &tile.Polys[j]
+			if p.Type() != polyTypeOffMeshConnection {
+				continue
+			}
+			// make the connection vertical, its end points are at the
+			// same xz-position.
+			v0 := d3.Vec3(tile.Verts[p.Verts[0]*3 : p.Verts[0]*3+3])
+			v1 := d3.Vec3(tile.Verts[p.Verts[1]*3 : p.Verts[1]*3+3])
+			v1[0], v1[2] = v0[0], v0[2]
+
+			ref := mesh.PolyRefBase(tile) | PolyRef(j)
+			h, st := query.PolyHeight(ref, v0)
+			if StatusFailed(st) {
+				t.Fatalf("PolyHeight failed with status 0x%x", st)
+			}
+			if h != v0[1] {
+				t.Fatalf("got height %v, want %v", h, v0[1])
+			}
+			return
+		}
+	}
+	t.Fatalf("no off-mesh connection found")
+}
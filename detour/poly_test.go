@@ -29,7 +29,7 @@ func TestCalcPolyCenter(t *testing.T) {
 			tile *MeshTile
 			poly *Poly
 		)
-		mesh.TileAndPolyByRef(tt.ref, &tile, &poly)
+		mesh.TileAndPolyByRef(ref32(mesh, tt.ref), &tile, &poly)
 		got := CalcPolyCenter(poly.Verts[:], int32(poly.VertCount), tile.Verts)
 		if !got.Approx(tt.want) {
 			t.Errorf("want centroid of poly 0x%x = %v, got %v", tt.ref, tt.want, got)
@@ -77,6 +77,11 @@ type NavMeshQuery struct {
 	tinyNodePool *NodePool  // Pointer to small node pool.
 	nodePool     *NodePool  // Pointer to node pool.
 	openList     *nodeQueue // Pointer to open list queue.
+
+	// node pool and open list of the backward bidirectional searches,
+	// allocated on first use.
+	backNodePool *NodePool
+	backOpenList *nodeQueue
 }
 
 type queryData struct {
@@ -182,193 +187,65 @@ func (q *NavMeshQuery) FindPath(
 	startPos, endPos d3.Vec3,
 	filter QueryFilter,
 	path []PolyRef) (pathCount int, st Status) {
-	// Validate input
-	if !q.nav.IsValidPolyRef(startRef) || !q.nav.IsValidPolyRef(endRef) ||
-		len(startPos) < 3 || len(endPos) < 3 || filter == nil || path == nil || len(path) == 0 {
-		return pathCount, Failure | InvalidParam
-	}
-
-	if startRef == endRef {
-		path[0] = startRef
-		return 1, Success
-	}
 
-	q.nodePool.Clear()
-	q.openList.clear()
-
-	var (
-		startNode, lastBestNode *Node
-		lastBestNodeCost        float32
-	)
-	startNode = q.nodePool.Node(startRef, 0)
-	startNode.Pos.Assign(startPos)
-	startNode.PIdx = 0
-	startNode.Cost = 0
-	startNode.Total = startPos.Dist(endPos) * HScale
-	startNode.ID = startRef
-	startNode.Flags = nodeOpen
-	q.openList.push(startNode)
-
-	lastBestNode = startNode
-	lastBestNodeCost = startNode.Total
+	pathCount, _, st = q.FindPathWithOptions(startRef, endRef, startPos, endPos, filter, path, nil)
+	return pathCount, st
+}
 
-	outOfNodes := false
+// findPath implements the forward search of FindPathWithOptions.
+func (q *NavMeshQuery) findPath(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef,
+	opts *PathSearchOptions,
+	stats *PathSearchStats) (pathCount int, st Status) {
+
+	hscale := opts.heuristicScale()
+
+	search := q.newGraphSearch(filter)
+	search.maxCost = opts.MaxCost
+	search.estimate = func(cur, nei *searchPoly, cost float32) (float32, float32, bool) {
+		// Special case for last node.
+		if nei.ref == endRef {
+			endCost := filter.Cost(nei.node.Pos, endPos,
+				cur.ref, cur.tile, cur.poly,
+				nei.ref, nei.tile, nei.poly,
+				0, nil, nil)
+			return cost + endCost, 0, true
+		}
+		return cost, nei.node.Pos.Dist(endPos) * hscale, true
+	}
 
-	for !q.openList.empty() {
+	search.reset()
+	search.push(startRef, startPos, 0, startPos.Dist(endPos)*hscale)
 
-		var bestNode *Node
+	for !search.open.empty() {
+		if opts.MaxIterations > 0 && stats.NodesExpanded >= opts.MaxIterations {
+			break
+		}
+		stats.NodesExpanded++
 
 		// Remove node from open list and put it in closed list.
-		bestNode = q.openList.pop()
-		bestNode.Flags &= ^nodeOpen
-		bestNode.Flags |= nodeClosed
+		bestNode := search.pop()
 
 		// Reached the goal, stop searching.
 		if bestNode.ID == endRef {
-			lastBestNode = bestNode
+			search.lastBest = bestNode
 			break
 		}
-
-		// Get current poly and tile.
-		// The API input has been cheked already, skip checking internal data.
-		var (
-			bestRef  PolyRef
-			bestTile *MeshTile
-			bestPoly *Poly
-		)
-
-		bestRef = bestNode.ID
-		bestTile = nil
-		bestPoly = nil
-		q.nav.TileAndPolyByRefUnsafe(bestRef, &bestTile, &bestPoly)
-
-		// Get parent poly and tile.
-		var (
-			parentRef  PolyRef
-			parentTile *MeshTile
-			parentPoly *Poly
-		)
-		if bestNode.PIdx != 0 {
-			parentRef = q.nodePool.NodeAtIdx(int32(bestNode.PIdx)).ID
-		}
-		if parentRef != 0 {
-			q.nav.TileAndPolyByRefUnsafe(parentRef, &parentTile, &parentPoly)
-		}
-
-		var i uint32
-		for i = bestPoly.FirstLink; i != nullLink; i = bestTile.Links[i].Next {
-			neighbourRef := bestTile.Links[i].Ref
-
-			// Skip invalid ids and do not expand back to where we came from.
-			if neighbourRef == 0 || neighbourRef == parentRef {
-				continue
-			}
-
-			// Get neighbour poly and tile.
-			// The API input has been cheked already, skip checking internal data.
-			var (
-				neighbourTile *MeshTile
-				neighbourPoly *Poly
-			)
-			q.nav.TileAndPolyByRefUnsafe(neighbourRef, &neighbourTile, &neighbourPoly)
-
-			if !filter.PassFilter(neighbourRef, neighbourTile, neighbourPoly) {
-				continue
-			}
-
-			// deal explicitly with crossing tile boundaries
-			var crossSide uint8
-			if bestTile.Links[i].Side != 0xff {
-				crossSide = bestTile.Links[i].Side >> 1
-			}
-
-			// get the node
-			neighbourNode := q.nodePool.Node(neighbourRef, crossSide)
-			if neighbourNode == nil {
-				outOfNodes = true
-				continue
-			}
-
-			// If the node is visited the first time, calculate node position.
-			if neighbourNode.Flags == 0 {
-
-				status := q.edgeMidPoint(bestRef, bestPoly, bestTile,
-					neighbourRef, neighbourPoly, neighbourTile,
-					neighbourNode.Pos[:])
-				if StatusFailed(status) {
-					log.Println("getEdgeMidPoint failed:", status)
-				}
-			}
-
-			// Calculate cost and heuristic.
-			var cost, heuristic float32
-
-			// Special case for last node.
-			if neighbourRef == endRef {
-				// Cost
-				curCost := filter.Cost(bestNode.Pos[:], neighbourNode.Pos[:],
-					parentRef, parentTile, parentPoly,
-					bestRef, bestTile, bestPoly,
-					neighbourRef, neighbourTile, neighbourPoly)
-				endCost := filter.Cost(neighbourNode.Pos[:], endPos[:],
-					bestRef, bestTile, bestPoly,
-					neighbourRef, neighbourTile, neighbourPoly,
-					0, nil, nil)
-
-				cost = bestNode.Cost + curCost + endCost
-				heuristic = 0
-			} else {
-				// Cost
-				curCost := filter.Cost(bestNode.Pos[:], neighbourNode.Pos[:],
-					parentRef, parentTile, parentPoly,
-					bestRef, bestTile, bestPoly,
-					neighbourRef, neighbourTile, neighbourPoly)
-				cost = bestNode.Cost + curCost
-				heuristic = neighbourNode.Pos.Dist(endPos) * HScale
-			}
-
-			total := cost + heuristic
-
-			// The node is already in open list and the new result is worse, skip.
-			if (neighbourNode.Flags&nodeOpen) != 0 && total >= neighbourNode.Total {
-				continue
-			}
-			// The node is already visited and process, and the new result is worse, skip.
-			if (neighbourNode.Flags&nodeClosed) != 0 && total >= neighbourNode.Total {
-				continue
-			}
-
-			// Add or update the node.
-			neighbourNode.PIdx = q.nodePool.NodeIdx(bestNode)
-			neighbourNode.ID = neighbourRef
-			neighbourNode.Flags = (neighbourNode.Flags & NodeFlags(^NodeFlags(nodeClosed)))
-			neighbourNode.Cost = cost
-			neighbourNode.Total = total
-
-			if (neighbourNode.Flags & nodeOpen) != 0 {
-				// Already in open, update node location.
-				q.openList.modify(neighbourNode)
-			} else {
-				// Put the node in open list.
-				neighbourNode.Flags |= nodeOpen
-				q.openList.push(neighbourNode)
-			}
-
-			// Update nearest node to target so far.
-			if heuristic < lastBestNodeCost {
-				lastBestNodeCost = heuristic
-				lastBestNode = neighbourNode
-			}
-		}
+		search.expand(bestNode, search.parentRef(bestNode))
 	}
 
+	lastBestNode := search.lastBest
 	pathCount, status := q.pathToNode(lastBestNode, path)
+	stats.PathCost = lastBestNode.Cost
 
 	if lastBestNode.ID != endRef {
 		status |= PartialResult
 	}
 
-	if outOfNodes {
+	if search.outOfNodes {
 		status |= OutOfNodes
 	}
 
@@ -978,9 +855,6 @@ func (q *NavMeshQuery) ClosestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, pos
 		return Success
 	}
 
-	ip := (uintptr(unsafe.Pointer(poly)) - uintptr(unsafe.Pointer(&tile.Polys[0]))) / unsafe.Sizeof(*poly)
-	pd := &tile.DetailMeshes[uint32(ip)]
-
 	// Clamp point to be inside the polygon.
 	verts := make([]float32, VertsPerPolygon*3)
 	edged := make([]float32, VertsPerPolygon)
@@ -1020,27 +894,8 @@ func (q *NavMeshQuery) ClosestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, pos
 	}
 
 	// Find height at the location.
-	var j uint8
-	var idx int
-	for j = 0; j < pd.TriCount; j++ {
-		idx = int((pd.TriBase + uint32(j)) * 4)
-		t := tile.DetailTris[idx : idx+3]
-		v := make([][]float32, 3)
-		var k int
-		for k = 0; k < 3; k++ {
-			if t[k] < poly.VertCount {
-				idx = int(poly.Verts[t[k]] * 3)
-				v[k] = tile.Verts[idx : idx+3]
-			} else {
-				idx = int((pd.VertBase + uint32(t[k]-poly.VertCount)) * 3)
-				v[k] = tile.DetailVerts[idx : idx+3]
-			}
-		}
-		var h float32
-		if closestHeightPointTriangle(closest, v[0], v[1], v[2], &h) {
-			closest[1] = h
-			break
-		}
+	if h, ok := detailHeight(tile, poly, closest); ok {
+		closest[1] = h
 	}
 	return Success
 }
@@ -1098,6 +953,69 @@ func (q *NavMeshQuery) ClosestPointOnPolyBoundary(ref PolyRef, pos, closest d3.V
 	return Success
 }
 
+// PolyHeight returns the height of the polygon ref at the xz-position of
+// pos, using the detail mesh.
+//
+// It fails with InvalidParam if ref is not valid or if pos is not over the
+// polygon. The height of an off-mesh connection is interpolated between its
+// end points.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) PolyHeight(ref PolyRef, pos d3.Vec3) (float32, Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+		return 0, Failure | InvalidParam
+	}
+
+	if poly.Type() == polyTypeOffMeshConnection {
+		v0 := d3.Vec3(tile.Verts[poly.Verts[0]*3 : poly.Verts[0]*3+3])
+		v1 := d3.Vec3(tile.Verts[poly.Verts[1]*3 : poly.Verts[1]*3+3])
+		d0 := pos.Dist2D(v0)
+		d1 := pos.Dist2D(v1)
+		var u float32
+		if d0+d1 > 0 {
+			u = d0 / (d0 + d1)
+		}
+		return v0[1] + (v1[1]-v0[1])*u, Success
+	}
+
+	h, ok := detailHeight(tile, poly, pos)
+	if !ok {
+		return 0, Failure | InvalidParam
+	}
+	return h, Success
+}
+
+// detailHeight returns the height of the detail mesh of poly at the
+// xz-position of pos, and false if pos is not over the polygon.
+func detailHeight(tile *MeshTile, poly *Poly, pos d3.Vec3) (float32, bool) {
+	ip := (uintptr(unsafe.Pointer(poly)) - uintptr(unsafe.Pointer(&tile.Polys[0]))) / unsafe.Sizeof(*poly)
+	pd := &tile.DetailMeshes[uint32(ip)]
+
+	var v [3]d3.Vec3
+	for j := uint8(0); j < pd.TriCount; j++ {
+		idx := int((pd.TriBase + uint32(j)) * 4)
+		t := tile.DetailTris[idx : idx+3]
+		for k := 0; k < 3; k++ {
+			if t[k] < poly.VertCount {
+				idx = int(poly.Verts[t[k]] * 3)
+				v[k] = tile.Verts[idx : idx+3]
+			} else {
+				idx = int((pd.VertBase + uint32(t[k]-poly.VertCount)) * 3)
+				v[k] = tile.DetailVerts[idx : idx+3]
+			}
+		}
+		var h float32
+		if closestHeightPointTriangle(pos, v[0], v[1], v[2], &h) {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
 // FindNearestPoly finds the polygon nearest to the specified center point.
 //
 //  Arguments:
@@ -1275,7 +1193,7 @@ func (q *NavMeshQuery) queryPolygonsInTile(
 		bmax[2] = uint16(qfac*maxz+1) | 1
 
 		// Traverse tree
-		base := q.nav.polyRefBase(tile)
+		base := q.nav.PolyRefBase(tile)
 		// TODO: probably need to use an index or unsafe.Pointer here
 		for nodeIdx < endIdx {
 			node = &tile.BvTree[nodeIdx]
@@ -1308,7 +1226,7 @@ func (q *NavMeshQuery) queryPolygonsInTile(
 		var bmin, bmax d3.Vec3
 		bmin = d3.NewVec3()
 		bmax = d3.NewVec3()
-		base := q.nav.polyRefBase(tile)
+		base := q.nav.PolyRefBase(tile)
 		for i := int32(0); i < tile.Header.PolyCount; i++ {
 			p := &tile.Polys[i]
 			// Do not return off-mesh connection polygons.
@@ -1445,6 +1363,24 @@ func (q *NavMeshQuery) Raycast(
 	options int,
 	prevRef PolyRef) (hit RaycastHit, st Status) {
 
+	st = q.raycast(startRef, startPos, endPos, filter, options, prevRef, &hit)
+	return
+}
+
+// raycast implements Raycast, storing the visited polygons in hit.Path if
+// hit.MaxPath is not zero.
+func (q *NavMeshQuery) raycast(
+	startRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	options int,
+	prevRef PolyRef,
+	hit *RaycastHit) (st Status) {
+
+	hit.T = 0
+	hit.PathCount = 0
+	hit.PathCost = 0
+
 	// Validate input
 	if startRef == 0 || !q.nav.IsValidPolyRef(startRef) {
 		st = Failure | InvalidParam
@@ -1710,7 +1646,7 @@ func (q *NavMeshQuery) Raycast2(startRef PolyRef, startPos, endPos d3.Vec3,
 	hit.Path = path
 	hit.MaxPath = maxPath
 
-	hit, status := q.Raycast(startRef, startPos, endPos, filter, 0, 0)
+	status := q.raycast(startRef, startPos, endPos, filter, 0, 0, &hit)
 	copy(hitNormal, hit.HitNormal)
 	return hit.PathCount, hit.T, status
 }
@@ -1973,8 +1909,6 @@ func (q *NavMeshQuery) UpdateSlicedFindPath(maxIter int, doneIters *int) Status
 				cost = cost + endCost
 				heuristic = 0
 			} else {
-				fmt.Println("neighbourNode.Pos", neighbourNode.Pos)
-				fmt.Println("q.query.endPos", q.query.endPos)
 				heuristic = neighbourNode.Pos.Dist(q.query.endPos) * HScale
 			}
 
@@ -2027,6 +1961,10 @@ func (q *NavMeshQuery) UpdateSlicedFindPath(maxIter int, doneIters *int) Status
 		q.query.status = Success | details
 	}
 
+	if doneIters != nil {
+		*doneIters = iter
+	}
+
 	return q.query.status
 }
 
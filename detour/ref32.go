@@ -0,0 +1,61 @@
+//go:build !polyref64
+// +build !polyref64
+
+package detour
+
+import "encoding/binary"
+
+// PolyRef is a reference to a polygon of the navigation mesh.
+//
+// A polygon reference packs a salt, a tile index and a polygon index, the
+// number of bits of each being computed by NavMesh.Init from the maximum
+// number of tiles and polygons per tile. Build with the polyref64 tag to get
+// 64-bit references, for navigation meshes with many tiles.
+type PolyRef uint32
+
+// TileRef is a reference to a tile of the navigation mesh.
+type TileRef uint32
+
+const (
+	// MaxTileBits is the maximum number of bits of a reference that the
+	// builders use for the tile index, leaving at least 8 bits for the
+	// polygon index.
+	MaxTileBits = 14
+
+	// MaxPolyBits is the maximum number of bits of a reference used for the
+	// polygon index.
+	MaxPolyBits = 22
+
+	// MaxTilePolyBits is the maximum number of bits of a reference used for
+	// both the tile and the polygon indices, the remaining bits (at least 10)
+	// being used for the salt.
+	MaxTilePolyBits = 22
+)
+
+const (
+	// refSize is the size of a serialized reference.
+	refSize = 4
+
+	// A version number used to detect the compatibility of navigation mesh
+	// set files, which tile references have 32 bits.
+	navMeshSetVersion int32 = 1
+
+	// A version number used to detect compatibility of navigation tile data,
+	// which links have 32-bit references.
+	navMeshVersion = 7
+)
+
+func hashRef(a PolyRef) uint32 {
+	a += ^(a << 15)
+	a ^= (a >> 10)
+	a += (a << 3)
+	a ^= (a >> 6)
+	a += ^(a << 11)
+	a ^= (a >> 16)
+	return uint32(a)
+}
+
+// putPolyRef writes ref into dst, as little endian.
+func putPolyRef(dst []byte, ref PolyRef) {
+	binary.LittleEndian.PutUint32(dst, uint32(ref))
+}
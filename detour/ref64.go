@@ -0,0 +1,58 @@
+//go:build polyref64
+// +build polyref64
+
+package detour
+
+import "encoding/binary"
+
+// PolyRef is a reference to a polygon of the navigation mesh.
+//
+// A polygon reference packs a salt, a tile index and a polygon index. With
+// the polyref64 build tag, references have 64 bits, of which 16 are used for
+// the salt, 28 for the tile index and 20 for the polygon index.
+type PolyRef uint64
+
+// TileRef is a reference to a tile of the navigation mesh.
+type TileRef uint64
+
+const (
+	// MaxTileBits is the maximum number of bits of a reference used for the
+	// tile index.
+	MaxTileBits = ref64TileBits
+
+	// MaxPolyBits is the maximum number of bits of a reference used for the
+	// polygon index.
+	MaxPolyBits = ref64PolyBits
+
+	// MaxTilePolyBits is the maximum number of bits of a reference used for
+	// both the tile and the polygon indices.
+	MaxTilePolyBits = MaxTileBits + MaxPolyBits
+)
+
+const (
+	// refSize is the size of a serialized reference.
+	refSize = 8
+
+	// A version number used to detect the compatibility of navigation mesh
+	// set files, which tile references have 64 bits.
+	navMeshSetVersion int32 = 2
+
+	// A version number used to detect compatibility of navigation tile data,
+	// which links have 64-bit references.
+	navMeshVersion = 8
+)
+
+func hashRef(a PolyRef) uint32 {
+	a += ^(a << 31)
+	a ^= (a >> 20)
+	a += (a << 6)
+	a ^= (a >> 12)
+	a += ^(a << 22)
+	a ^= (a >> 32)
+	return uint32(a)
+}
+
+// putPolyRef writes ref into dst, as little endian.
+func putPolyRef(dst []byte, ref PolyRef) {
+	binary.LittleEndian.PutUint64(dst, uint64(ref))
+}
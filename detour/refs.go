@@ -0,0 +1,117 @@
+package detour
+
+import (
+	"encoding/binary"
+
+	"github.com/arl/math32"
+)
+
+// Number of salt, tile and poly bits of 64-bit references.
+const (
+	ref64SaltBits = 16
+	ref64TileBits = 28
+	ref64PolyBits = 20
+)
+
+// refBits returns the number of salt, tile and poly bits of the references of
+// a navigation mesh.
+//
+//  Arguments:
+//   size     The size of the references, in bytes. (4 or 8)
+//   params   The navigation mesh parameters.
+//
+// The returned status has the InvalidParam flag set if the maximum number of
+// tiles and polygons per tile don't fit in the references.
+func refBits(size int, params *NavMeshParams) (saltBits, tileBits, polyBits uint32, st Status) {
+	if size == 8 {
+		if uint64(params.MaxTiles) > 1<<ref64TileBits || uint64(params.MaxPolys) > 1<<ref64PolyBits {
+			return 0, 0, 0, Failure | InvalidParam
+		}
+		return ref64SaltBits, ref64TileBits, ref64PolyBits, Success
+	}
+
+	tileBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxTiles)))
+	polyBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxPolys)))
+	// Only allow 31 salt bits, since the salt mask is calculated using 32bit uint and it will overflow.
+	if 31 < 32-tileBits-polyBits {
+		saltBits = 31
+	} else {
+		saltBits = 32 - tileBits - polyBits
+	}
+
+	if saltBits < 10 {
+		return 0, 0, 0, Failure | InvalidParam
+	}
+	return saltBits, tileBits, polyBits, Success
+}
+
+// setVersionRefSize returns the size of the tile references stored in a
+// navigation mesh set file of the given version, or 0 if the version is
+// unknown.
+func setVersionRefSize(version int32) int {
+	switch version {
+	case 1:
+		return 4
+	case 2:
+		return 8
+	}
+	return 0
+}
+
+// tileVersionRefSize returns the size of the link references stored in
+// navigation tile data of the given version, or 0 if the version is unknown.
+func tileVersionRefSize(version int32) int {
+	switch version {
+	case 7:
+		return 4
+	case 8:
+		return 8
+	}
+	return 0
+}
+
+// readRef reads a little endian reference of size bytes from src.
+func readRef(src []byte, size int) uint64 {
+	if size == 8 {
+		return binary.LittleEndian.Uint64(src)
+	}
+	return uint64(binary.LittleEndian.Uint32(src))
+}
+
+// convertParams returns the navigation mesh parameters params, read from a
+// navigation mesh set file which references have size bytes, adapted to the
+// references of this build.
+//
+// 32-bit references can identify more polygons per tile than 64-bit ones, in
+// which case the maximum number of polygons per tile is clamped: the tiles
+// having more polygons than that are rejected when they are added.
+func convertParams(params NavMeshParams, size int) NavMeshParams {
+	if size != refSize && uint64(params.MaxPolys) > 1<<MaxPolyBits {
+		params.MaxPolys = 1 << MaxPolyBits
+	}
+	return params
+}
+
+// convertTileRef converts a tile reference read from a navigation mesh set
+// file, which references have size bytes and which navigation mesh parameters
+// are fileParams, to a reference of the navigation mesh.
+func (m *NavMesh) convertTileRef(ref uint64, size int, fileParams *NavMeshParams) (TileRef, Status) {
+	if size == refSize {
+		return TileRef(ref), Success
+	}
+	saltBits, tileBits, polyBits, st := refBits(size, fileParams)
+	if StatusFailed(st) {
+		return 0, st
+	}
+	salt := uint32(ref>>(polyBits+tileBits)) & (1<<saltBits - 1)
+	it := uint32(ref>>polyBits) & (1<<tileBits - 1)
+	if it >= uint32(m.MaxTiles) {
+		return 0, Failure | InvalidParam
+	}
+	// Keep the salt non zero, so that the reference is valid.
+	salt &= 1<<m.saltBits - 1
+	if salt == 0 {
+		salt = 1
+	}
+	return TileRef(m.encodePolyID(salt, it, 0)), Success
+}
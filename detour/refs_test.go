@@ -0,0 +1,128 @@
+package detour
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// putRef writes a little endian reference of size bytes into dst.
+func putRef(dst []byte, ref uint64, size int) {
+	if size == 8 {
+		binary.LittleEndian.PutUint64(dst, ref)
+	} else {
+		binary.LittleEndian.PutUint32(dst, uint32(ref))
+	}
+}
+
+// ref32 converts the 32-bit polygon reference ref, as stored in the test
+// navigation mesh files, to a reference of mesh, which size depends on the
+// build tags.
+func ref32(mesh *NavMesh, ref PolyRef) PolyRef {
+	_, _, polyBits, _ := refBits(4, &mesh.Params)
+	tref, _ := mesh.convertTileRef(uint64(ref), 4, &mesh.Params)
+	return PolyRef(tref) | ref&(1<<polyBits-1)
+}
+
+// encodeOtherRefSize encodes mesh as a navigation mesh set file which
+// references have the other size than the references of this build.
+func encodeOtherRefSize(t *testing.T, mesh *NavMesh) []byte {
+	size, setVersion, tileVersion := 8, int32(2), int32(8)
+	if refSize == 8 {
+		size, setVersion, tileVersion = 4, 1, 7
+	}
+	saltBits, tileBits, polyBits, st := refBits(size, &mesh.Params)
+	if StatusFailed(st) {
+		t.Fatalf("mesh params don't fit in %d-byte references", size)
+	}
+
+	var buf bytes.Buffer
+	hdr := navMeshSetHeader{Magic: navMeshSetMagic, Version: setVersion, Params: mesh.Params}
+	for i := range mesh.Tiles {
+		if mesh.Tiles[i].DataSize != 0 {
+			hdr.NumTiles++
+		}
+	}
+	hdr.WriteTo(&buf)
+
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.DataSize == 0 {
+			continue
+		}
+		data := make([]byte, tile.DataSize)
+		tile.Header.serialize(data)
+		tile.serialize(data[tile.Header.size():])
+
+		// Copy the tile data, resizing the link references.
+		linkSize := refSize + 8
+		linksOff := tile.Header.size() + 12*int(tile.Header.VertCount) + 32*int(tile.Header.PolyCount)
+		linksEnd := linksOff + linkSize*int(tile.Header.MaxLinkCount)
+		other := append([]byte(nil), data[:linksOff]...)
+		binary.LittleEndian.PutUint32(other[4:], uint32(tileVersion))
+		for off := linksOff; off < linksEnd; off += linkSize {
+			link := make([]byte, size+8)
+			putRef(link, uint64(PolyRef(readRef(data[off:], refSize))), size)
+			copy(link[size:], data[off+refSize:off+linkSize])
+			other = append(other, link...)
+		}
+		other = append(other, data[linksEnd:]...)
+
+		var salt, it, ip uint32
+		mesh.DecodePolyID(PolyRef(mesh.TileRef(tile)), &salt, &it, &ip)
+		tileHdr := make([]byte, size+4)
+		ref := uint64(salt)<<(polyBits+tileBits) | uint64(it)<<polyBits
+		if salt >= 1<<saltBits {
+			t.Fatalf("salt %d doesn't fit in %d bits", salt, saltBits)
+		}
+		putRef(tileHdr, ref, size)
+		binary.LittleEndian.PutUint32(tileHdr[size:], uint32(len(other)))
+		buf.Write(tileHdr)
+		buf.Write(other)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeOtherRefSize(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	conv, err := Decode(bytes.NewReader(encodeOtherRefSize(t, mesh)))
+	checkt(t, err)
+
+	for i := range mesh.Tiles {
+		want, got := &mesh.Tiles[i], &conv.Tiles[i]
+		if want.DataSize == 0 {
+			if got.DataSize != 0 {
+				t.Fatalf("tile %d should be empty", i)
+			}
+			continue
+		}
+		if got.Header == nil || got.Header.Version != navMeshVersion {
+			t.Fatalf("tile %d: header version should be converted to %d", i, navMeshVersion)
+		}
+		if got.DataSize != want.DataSize {
+			t.Errorf("tile %d: got data size %d, want %d", i, got.DataSize, want.DataSize)
+		}
+		if conv.TileRef(got) != mesh.TileRef(want) {
+			t.Errorf("tile %d: got ref 0x%x, want 0x%x", i, conv.TileRef(got), mesh.TileRef(want))
+		}
+	}
+
+	// links are rebuilt the same way, so the converted tiles serialize to
+	// the same data.
+	for i := range conv.Tiles {
+		got, want := &conv.Tiles[i], &mesh.Tiles[i]
+		if want.DataSize == 0 {
+			continue
+		}
+		gotData, wantData := make([]byte, got.DataSize), make([]byte, want.DataSize)
+		got.Header.serialize(gotData)
+		got.serialize(gotData[got.Header.size():])
+		want.Header.serialize(wantData)
+		want.serialize(wantData[want.Header.size():])
+		if !bytes.Equal(gotData, wantData) {
+			t.Errorf("tile %d: converted tile data differs", i)
+		}
+	}
+}
@@ -6,16 +6,13 @@ import (
 	"math"
 )
 
-// TileRef is a reference to a tile of the navigation mesh.
-type TileRef uint32
-
 type navMeshTileHeader struct {
 	TileRef  TileRef
 	DataSize int32
 }
 
 func (s *navMeshTileHeader) Size() int {
-	return 8
+	return refSize + 4
 }
 
 func (s *navMeshTileHeader) WriteTo(w io.Writer) (int64, error) {
@@ -36,8 +33,8 @@ func (s *navMeshTileHeader) Serialize(dst []byte) {
 	)
 
 	// write each field as little endian
-	little.PutUint32(dst[off:], uint32(s.TileRef))
-	little.PutUint32(dst[off+4:], uint32(s.DataSize))
+	putPolyRef(dst[off:], PolyRef(s.TileRef))
+	little.PutUint32(dst[off+refSize:], uint32(s.DataSize))
 }
 
 // MeshTile defines a navigation mesh tile.
@@ -101,8 +98,9 @@ func (s *MeshTile) serialize(dst []byte) {
 
 func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte) {
 	var (
-		little = binary.LittleEndian
-		i, off int
+		little      = binary.LittleEndian
+		i, off      int
+		linkRefSize = tileVersionRefSize(hdr.Version)
 	)
 
 	s.Verts = make([]float32, 3*hdr.VertCount)
@@ -135,14 +133,15 @@ func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte) {
 	for i := range s.Links {
 		l := &s.Links[i]
 
-		l.Ref = PolyRef(little.Uint32(src[off:]))
-		l.Next = little.Uint32(src[off+4:])
+		l.Ref = PolyRef(readRef(src[off:], linkRefSize))
+		off += linkRefSize
+		l.Next = little.Uint32(src[off:])
 
-		l.Edge = src[off+8]
-		l.Side = src[off+9]
-		l.BMin = src[off+10]
-		l.BMax = src[off+11]
-		off += 12
+		l.Edge = src[off+4]
+		l.Side = src[off+5]
+		l.BMin = src[off+6]
+		l.BMax = src[off+7]
+		off += 8
 	}
 
 	s.DetailMeshes = make([]PolyDetail, hdr.DetailMeshCount)
@@ -236,14 +235,15 @@ func serializeTileData(dst []byte,
 	for i := range links {
 		l := &links[i]
 
-		little.PutUint32(dst[off:], uint32(l.Ref))
-		little.PutUint32(dst[off+4:], l.Next)
+		putPolyRef(dst[off:], l.Ref)
+		off += refSize
+		little.PutUint32(dst[off:], l.Next)
 
-		dst[off+8] = l.Edge
-		dst[off+9] = l.Side
-		dst[off+10] = l.BMin
-		dst[off+11] = l.BMax
-		off += 12
+		dst[off+4] = l.Edge
+		dst[off+5] = l.Side
+		dst[off+6] = l.BMin
+		dst[off+7] = l.BMax
+		off += 8
 	}
 
 	for i := range dmeshes {
@@ -43,8 +43,8 @@ func TestFindNearestPolyInTile(t *testing.T) {
 
 		nearestPt := d3.NewVec3()
 		got := mesh.FindNearestPolyInTile(tile, tt.pt, tt.ext, nearestPt)
-		if got != tt.want {
-			t.Errorf("got polyref 0x%x for pt:%v ext:%v, want 0x%x", got, tt.pt, tt.ext, tt.want)
+		if want := ref32(mesh, tt.want); got != want {
+			t.Errorf("got polyref 0x%x for pt:%v ext:%v, want 0x%x", got, tt.pt, tt.ext, want)
 		}
 	}
 }
@@ -0,0 +1,444 @@
+package detour
+
+import (
+	"container/heap"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// TileGraph is a hierarchical representation of a tiled navigation mesh,
+// allowing to find paths across big worlds with a bounded number of search
+// nodes.
+//
+// The nodes of the graph are the tiles of the navigation mesh and its edges
+// are the portals, that is the links between polygons of neighbour tiles. A
+// path request is first planned at the tile level, then refined, segment by
+// segment, with NavMeshQuery.FindPath limited to a corridor made of the tiles
+// of the coarse route. As a consequence the number of search nodes required
+// by the refinement depends on the segment length and not on the length of
+// the whole path, at the cost of paths that may be slightly longer than the
+// ones found by a flat search.
+//
+// A TileGraph observes its navigation mesh, so it's incrementally updated when
+// tiles are added or removed.
+type TileGraph struct {
+	// SegmentTiles is the number of tiles of the coarse route refined by each
+	// search. [Limit: > 0]
+	SegmentTiles int
+
+	// CorridorWidth is the number of tiles, on each side of the coarse route,
+	// the refinement can search into. [Limit: >= 0]
+	CorridorWidth int32
+
+	nav   *NavMesh
+	nodes map[*MeshTile]*tileNode
+}
+
+// tileNode is a node of the tile graph.
+type tileNode struct {
+	tile    *MeshTile
+	center  d3.Vec3
+	portals map[*MeshTile][]tilePortal // portals toward each neighbour tile
+}
+
+// tilePortal is a link between 2 polygons of neighbour tiles.
+type tilePortal struct {
+	from, to PolyRef
+	pos      d3.Vec3 // position of the portal, on the 'from' polygon.
+}
+
+// NewTileGraph creates the tile graph of the navigation mesh nav and
+// registers it as a tile observer of nav.
+//
+// see TileGraph.Close
+func NewTileGraph(nav *NavMesh) *TileGraph {
+	g := &TileGraph{
+		SegmentTiles:  8,
+		CorridorWidth: 1,
+		nav:           nav,
+		nodes:         make(map[*MeshTile]*tileNode),
+	}
+	for i := range nav.Tiles {
+		tile := &nav.Tiles[i]
+		if tile.Header != nil {
+			g.nodes[tile] = g.newTileNode(tile)
+		}
+	}
+	nav.AddTileObserver(g)
+	return g
+}
+
+// Close unregisters the tile graph from its navigation mesh, after which the
+// graph doesn't get updated anymore.
+func (g *TileGraph) Close() {
+	g.nav.RemoveTileObserver(g)
+}
+
+// TileAdded implements the TileObserver interface.
+func (g *TileGraph) TileAdded(m *NavMesh, tile *MeshTile) {
+	g.nodes[tile] = g.newTileNode(tile)
+
+	// the neighbour tiles now have portals toward the added tile.
+	for _, nei := range g.neighbours(tile) {
+		g.nodes[nei] = g.newTileNode(nei)
+	}
+}
+
+// TileRemoved implements the TileObserver interface.
+func (g *TileGraph) TileRemoved(m *NavMesh, tile *MeshTile) {
+	delete(g.nodes, tile)
+	for _, nei := range g.neighbours(tile) {
+		if node, ok := g.nodes[nei]; ok {
+			delete(node.portals, tile)
+		}
+	}
+}
+
+// neighbours returns the tiles that can be connected to tile, that is the
+// other layers at the same location and the tiles of the 8 neighbour
+// locations.
+func (g *TileGraph) neighbours(tile *MeshTile) []*MeshTile {
+	const maxNeis = 32
+	var (
+		neis  [maxNeis]*MeshTile
+		all   []*MeshTile
+		nneis int32
+	)
+
+	nneis = g.nav.TilesAt(tile.Header.X, tile.Header.Y, neis[:], maxNeis)
+	for j := int32(0); j < nneis; j++ {
+		if neis[j] != tile {
+			all = append(all, neis[j])
+		}
+	}
+	for i := int32(0); i < 8; i++ {
+		nneis = g.nav.neighbourTilesAt(tile.Header.X, tile.Header.Y, i, neis[:], maxNeis)
+		all = append(all, neis[:nneis]...)
+	}
+	return all
+}
+
+// newTileNode creates the node of tile, with its portals toward all the tiles
+// it's connected to.
+func (g *TileGraph) newTileNode(tile *MeshTile) *tileNode {
+	hdr := tile.Header
+	node := &tileNode{
+		tile: tile,
+		center: d3.NewVec3XYZ(
+			(hdr.BMin[0]+hdr.BMax[0])*0.5,
+			(hdr.BMin[1]+hdr.BMax[1])*0.5,
+			(hdr.BMin[2]+hdr.BMax[2])*0.5),
+		portals: make(map[*MeshTile][]tilePortal),
+	}
+
+	base := g.nav.PolyRefBase(tile)
+	for i := int32(0); i < hdr.PolyCount; i++ {
+		poly := &tile.Polys[i]
+		for l := poly.FirstLink; l != nullLink; l = tile.Links[l].Next {
+			link := &tile.Links[l]
+			if link.Ref == 0 {
+				continue
+			}
+			var (
+				neiTile *MeshTile
+				neiPoly *Poly
+			)
+			g.nav.TileAndPolyByRefUnsafe(link.Ref, &neiTile, &neiPoly)
+			if neiTile == tile {
+				continue
+			}
+
+			var pos d3.Vec3
+			if poly.Type() == polyTypeOffMeshConnection {
+				// off-mesh connections links are attached to their end points.
+				v := int(poly.Verts[link.Edge]) * 3
+				pos = d3.NewVec3From(tile.Verts[v : v+3])
+			} else {
+				// middle of the polygon edge owning the link.
+				v0 := int(poly.Verts[link.Edge]) * 3
+				v1 := int(poly.Verts[(link.Edge+1)%poly.VertCount]) * 3
+				pos = d3.NewVec3()
+				d3.Vec3Lerp(pos, tile.Verts[v0:v0+3], tile.Verts[v1:v1+3], 0.5)
+			}
+			node.portals[neiTile] = append(node.portals[neiTile], tilePortal{
+				from: base | PolyRef(i),
+				to:   link.Ref,
+				pos:  pos,
+			})
+		}
+	}
+	return node
+}
+
+// FindPath finds a path from the start polygon to the end polygon, like
+// NavMeshQuery.FindPath does, by first finding a route over the tile graph,
+// then refining it with q.
+//
+//  Arguments:
+//   q         The query used to refine the coarse route, q must be attached
+//             to the navigation mesh of the graph.
+//   startRef  The reference id of the start polygon.
+//   endRef    The reference id of the end polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   endPos    A position within the end polygon. [(x, y, z)]
+//   filter    The polygon filter to apply to the query.
+//   path      This slice will be filled with an ordered list of polygon
+//             references representing the path. (Start to end.)
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   st        status code (may be a partial result)
+//
+// If there is no route between the start and end tiles, the path is searched
+// with q.FindPath alone. If a segment of the route can't be refined inside
+// its corridor, the rest of the path is also searched with q.FindPath alone.
+func (g *TileGraph) FindPath(
+	q *NavMeshQuery,
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef) (pathCount int, st Status) {
+	// Validate input
+	if !g.nav.IsValidPolyRef(startRef) || !g.nav.IsValidPolyRef(endRef) ||
+		len(startPos) < 3 || len(endPos) < 3 || filter == nil || path == nil || len(path) == 0 ||
+		g.SegmentTiles < 1 {
+		return pathCount, Failure | InvalidParam
+	}
+
+	var (
+		startTile, endTile *MeshTile
+		poly               *Poly
+	)
+	g.nav.TileAndPolyByRefUnsafe(startRef, &startTile, &poly)
+	g.nav.TileAndPolyByRefUnsafe(endRef, &endTile, &poly)
+
+	route := g.FindRoute(startTile, endTile, endPos, filter)
+	if route == nil {
+		return q.FindPath(startRef, endRef, startPos, endPos, filter, path)
+	}
+
+	corridor := &corridorFilter{QueryFilter: filter}
+	curRef := startRef
+	curPos := d3.NewVec3From(startPos)
+	var details Status
+
+	last := len(route) - 1
+	for i := 0; ; {
+		j := i + g.SegmentTiles
+		if j > last {
+			j = last
+		}
+
+		// target of the segment, either the end of the path or the best
+		// portal toward the last tile of the segment.
+		tgtRef, tgtPos := endRef, endPos
+		if j != last {
+			p := g.bestPortal(route[j-1], route[j], curPos, endPos, filter)
+			tgtRef, tgtPos = p.to, p.pos
+		}
+
+		// the first polygon of the segment is the last of the previous one.
+		off := pathCount
+		if off > 0 {
+			off--
+		}
+
+		corridor.tiles = g.corridorTiles(route[i:j+1], corridor.tiles)
+		n, st := q.FindPath(curRef, tgtRef, curPos, tgtPos, corridor, path[off:])
+		if StatusSucceed(st) && StatusDetail(st, PartialResult) && !StatusDetail(st, BufferTooSmall) {
+			// the segment target can't be reached inside the corridor,
+			// search the rest of the path without restriction.
+			n, st = q.FindPath(curRef, endRef, curPos, endPos, filter, path[off:])
+			return off + n, st | details
+		}
+		if StatusFailed(st) {
+			return pathCount, st
+		}
+		pathCount = off + n
+		details |= st & (OutOfNodes | BufferTooSmall | PartialResult)
+		if j == last || StatusDetail(st, BufferTooSmall) {
+			break
+		}
+		curRef = tgtRef
+		curPos.Assign(tgtPos)
+		i = j
+	}
+	if path[pathCount-1] != endRef {
+		details |= PartialResult
+	}
+	return pathCount, Success | details
+}
+
+// bestPortal returns the portal from tile a to tile b that minimizes the
+// distance from pos to the end position, through the portal.
+func (g *TileGraph) bestPortal(a, b *MeshTile, pos, endPos d3.Vec3, filter QueryFilter) tilePortal {
+	var (
+		best     tilePortal
+		bestDist float32 = -1
+	)
+	for _, p := range g.nodes[a].portals[b] {
+		if !g.passPortal(p, filter) {
+			continue
+		}
+		d := pos.Dist(p.pos) + p.pos.Dist(endPos)
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = p, d
+		}
+	}
+	return best
+}
+
+// passPortal reports whether both polygons of the portal pass the filter.
+func (g *TileGraph) passPortal(p tilePortal, filter QueryFilter) bool {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	g.nav.TileAndPolyByRefUnsafe(p.from, &tile, &poly)
+	if !filter.PassFilter(p.from, tile, poly) {
+		return false
+	}
+	g.nav.TileAndPolyByRefUnsafe(p.to, &tile, &poly)
+	return filter.PassFilter(p.to, tile, poly)
+}
+
+// corridorTiles fills and returns tiles with the tiles of the route, plus the
+// tiles located at less than CorridorWidth tiles from them.
+func (g *TileGraph) corridorTiles(route []*MeshTile, tiles map[*MeshTile]bool) map[*MeshTile]bool {
+	if tiles == nil {
+		tiles = make(map[*MeshTile]bool)
+	}
+	for t := range tiles {
+		delete(tiles, t)
+	}
+
+	const maxLayers = 32
+	var layers [maxLayers]*MeshTile
+	w := g.CorridorWidth
+	for _, t := range route {
+		tiles[t] = true
+		for y := t.Header.Y - w; y <= t.Header.Y+w; y++ {
+			for x := t.Header.X - w; x <= t.Header.X+w; x++ {
+				n := g.nav.TilesAt(x, y, layers[:], maxLayers)
+				for k := int32(0); k < n; k++ {
+					tiles[layers[k]] = true
+				}
+			}
+		}
+	}
+	return tiles
+}
+
+// routeNode is a node of the coarse search over the tile graph.
+type routeNode struct {
+	node   *tileNode
+	parent *routeNode
+	cost   float32
+	total  float32
+	index  int // index in the open list, -1 when closed.
+}
+
+// routeQueue is the open list of the coarse search.
+type routeQueue []*routeNode
+
+func (rq routeQueue) Len() int           { return len(rq) }
+func (rq routeQueue) Less(i, j int) bool { return rq[i].total < rq[j].total }
+func (rq routeQueue) Swap(i, j int) {
+	rq[i], rq[j] = rq[j], rq[i]
+	rq[i].index = i
+	rq[j].index = j
+}
+
+func (rq *routeQueue) Push(x interface{}) {
+	rn := x.(*routeNode)
+	rn.index = len(*rq)
+	*rq = append(*rq, rn)
+}
+
+func (rq *routeQueue) Pop() interface{} {
+	old := *rq
+	n := len(old)
+	rn := old[n-1]
+	rn.index = -1
+	*rq = old[:n-1]
+	return rn
+}
+
+// FindRoute finds the sequence of tiles to traverse in order to go from the
+// start tile to the end tile, endPos being the destination in the end tile.
+// Only the portals which polygons pass the filter are considered.
+//
+// It returns nil if the end tile can't be reached from the start tile.
+func (g *TileGraph) FindRoute(start, end *MeshTile, endPos d3.Vec3, filter QueryFilter) []*MeshTile {
+	startNode, endNode := g.nodes[start], g.nodes[end]
+	if startNode == nil || endNode == nil {
+		return nil
+	}
+
+	visited := make(map[*tileNode]*routeNode)
+	open := &routeQueue{}
+	first := &routeNode{node: startNode, total: startNode.center.Dist(endPos) * HScale}
+	visited[startNode] = first
+	heap.Push(open, first)
+
+	for open.Len() > 0 {
+		best := heap.Pop(open).(*routeNode)
+		if best.node == endNode {
+			// Reverse the route.
+			var route []*MeshTile
+			for rn := best; rn != nil; rn = rn.parent {
+				route = append(route, rn.node.tile)
+			}
+			for i, j := 0, len(route)-1; i < j; i, j = i+1, j-1 {
+				route[i], route[j] = route[j], route[i]
+			}
+			return route
+		}
+
+		for neiTile, portals := range best.node.portals {
+			nei := g.nodes[neiTile]
+			if nei == nil {
+				continue
+			}
+			passable := false
+			for _, p := range portals {
+				if g.passPortal(p, filter) {
+					passable = true
+					break
+				}
+			}
+			if !passable {
+				continue
+			}
+
+			cost := best.cost + best.node.center.Dist(nei.center)
+			rn, ok := visited[nei]
+			if ok && cost >= rn.cost {
+				continue
+			}
+			if !ok {
+				rn = &routeNode{node: nei, index: -1}
+				visited[nei] = rn
+			}
+			rn.parent = best
+			rn.cost = cost
+			rn.total = cost + nei.center.Dist(endPos)*HScale
+			if rn.index >= 0 {
+				heap.Fix(open, rn.index)
+			} else {
+				heap.Push(open, rn)
+			}
+		}
+	}
+	return nil
+}
+
+// corridorFilter is a query filter restricting the search to a set of tiles.
+type corridorFilter struct {
+	QueryFilter
+	tiles map[*MeshTile]bool
+}
+
+func (f *corridorFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	return f.tiles[tile] && f.QueryFilter.PassFilter(ref, tile, poly)
+}
@@ -0,0 +1,144 @@
+package detour
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// arePolysLinked reports whether there is a link from polygon a to polygon b.
+func arePolysLinked(mesh *NavMesh, a, b PolyRef) bool {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(a, &tile, &poly)
+	for i := poly.FirstLink; i != nullLink; i = tile.Links[i].Next {
+		if tile.Links[i].Ref == b {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTileGraphFindPath(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	// gather the ground polygons, with their centers
+	type poly struct {
+		ref PolyRef
+		pos d3.Vec3
+	}
+	var polys []poly
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		base := mesh.PolyRefBase(tile)
+		for j := int32(0); j < tile.Header.PolyCount; j++ {
+			p := &tile.Polys[j]
+			if p.Type() != polyTypeOffMeshConnection {
+				polys = append(polys, poly{
+					ref: base | PolyRef(j),
+					pos: CalcPolyCenter(p.Verts[:], int32(p.VertCount), tile.Verts),
+				})
+			}
+		}
+	}
+
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	filter := NewStandardQueryFilter()
+
+	graph := NewTileGraph(mesh)
+	defer graph.Close()
+	graph.SegmentTiles = 2
+
+	rnd := rand.New(rand.NewSource(1))
+	path := make([]PolyRef, 512)
+	for i := 0; i < 200; i++ {
+		org := polys[rnd.Intn(len(polys))]
+		dst := polys[rnd.Intn(len(polys))]
+
+		// only consider the pairs of polygons connected in the navmesh
+		n, st := query.FindPath(org.ref, dst.ref, org.pos, dst.pos, filter, path)
+		if StatusFailed(st) || path[n-1] != dst.ref {
+			continue
+		}
+
+		n, st = graph.FindPath(query, org.ref, dst.ref, org.pos, dst.pos, filter, path)
+		if StatusFailed(st) || StatusDetail(st, PartialResult) {
+			t.Fatalf("%v -> %v: got status 0x%x", org.ref, dst.ref, st)
+		}
+		if path[0] != org.ref || path[n-1] != dst.ref {
+			t.Fatalf("%v -> %v: path goes from %v to %v", org.ref, dst.ref, path[0], path[n-1])
+		}
+		for j := 1; j < n; j++ {
+			if !arePolysLinked(mesh, path[j-1], path[j]) {
+				t.Fatalf("%v -> %v: path polygons %v and %v are not linked", org.ref, dst.ref, path[j-1], path[j])
+			}
+		}
+	}
+}
+
+func TestTileGraphUpdate(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	graph := NewTileGraph(mesh)
+	defer graph.Close()
+
+	// find a tile connected to other tiles
+	var (
+		tile  *MeshTile
+		nnode *tileNode
+	)
+	for tile, nnode = range graph.nodes {
+		if len(nnode.portals) != 0 {
+			break
+		}
+	}
+	if nnode == nil || len(nnode.portals) == 0 {
+		t.Fatalf("no tile with portals found")
+	}
+	x, y := tile.Header.X, tile.Header.Y
+
+	neis := make([]*MeshTile, 0, len(nnode.portals))
+	for nei := range nnode.portals {
+		neis = append(neis, nei)
+	}
+	data := make([]byte, tile.DataSize)
+	tile.Header.serialize(data)
+	tile.serialize(data[tile.Header.size():])
+
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("couldn't remove tile, status 0x%x", st)
+	}
+	if _, ok := graph.nodes[tile]; ok {
+		t.Fatalf("removed tile should not be in the graph")
+	}
+	for _, nei := range neis {
+		if _, ok := graph.nodes[nei].portals[tile]; ok {
+			t.Fatalf("neighbour tile should not have portals toward the removed tile")
+		}
+	}
+
+	st, _ := mesh.AddTile(data, 0)
+	if StatusFailed(st) {
+		t.Fatalf("couldn't add tile, status 0x%x", st)
+	}
+	added := mesh.TileAt(x, y, 0)
+	if _, ok := graph.nodes[added]; !ok {
+		t.Fatalf("added tile should be in the graph")
+	}
+	for _, nei := range neis {
+		if _, ok := graph.nodes[nei].portals[added]; !ok {
+			t.Fatalf("neighbour tile should have portals toward the added tile")
+		}
+	}
+}
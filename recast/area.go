@@ -30,7 +30,7 @@ func ErodeWalkableArea(ctx *BuildContext, radius int32, chf *CompactHeightfield)
 	ctx.StartTimer(TimerErodeArea)
 	defer ctx.StopTimer(TimerErodeArea)
 
-	dist := make([]uint8, chf.SpanCount)
+	dist := ctx.arena.uint8Slice(int(chf.SpanCount))
 
 	// Init distance.
 	for i := range dist {
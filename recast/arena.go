@@ -0,0 +1,312 @@
+package recast
+
+// An Arena provides the memory of the intermediate structures of the build
+// process: heightfields, compact heightfields, contours, polygon meshes and
+// detail meshes, as well as of the largest temporary buffers.
+//
+// The memory allocated from an arena is only released, to be reused by later
+// builds, when the arena is reset. This spares most of the allocations, and
+// of the garbage collection, when building many navigation meshes, or many
+// tiles, of similar sizes.
+//
+// An arena is used by the build functions once it's been set to their build
+// context with BuildContext.SetArena. It is not safe for concurrent use.
+//
+// The zero value is an empty arena ready to use.
+type Arena struct {
+	uint8s    uint8Arena
+	uint16s   uint16Arena
+	int32s    int32Arena
+	float32s  float32Arena
+	columns   spanColumnArena
+	cells     compactCellArena
+	spans     compactSpanArena
+	pools     []*spanPool // Span pools, allocated or not.
+	usedPools int         // Number of allocated span pools.
+}
+
+// Reset releases all the memory allocated from the arena, so that it can be
+// reused by the next allocations.
+//
+// The structures built before the call to Reset, with the build context the
+// arena is set to, must not be used anymore. Data copied out of them, such as
+// the navigation mesh data created from a polygon mesh, stays valid.
+//
+// The allocations that didn't fit in the arena since the last reset are
+// summed up, so that the arena grows and is able to hold them next time.
+func (a *Arena) Reset() {
+	a.uint8s.reset()
+	a.uint16s.reset()
+	a.int32s.reset()
+	a.float32s.reset()
+	a.columns.reset()
+	a.cells.reset()
+	a.spans.reset()
+	a.usedPools = 0
+}
+
+// NewHeightfield returns a new heightfield which memory, spans included, is
+// allocated from the arena. a can be nil, in which case the heightfield is
+// allocated normally, like with NewHeightfield.
+//
+// See the Config documentation for more information on the configuration parameters.
+func (a *Arena) NewHeightfield(width, height int32, bmin, bmax []float32, cs, ch float32) *Heightfield {
+	hf := &Heightfield{
+		Width:  width,
+		Height: height,
+		Cs:     cs,
+		Ch:     ch,
+		Spans:  a.spanColumns(int(width * height)),
+		arena:  a,
+	}
+	copy(hf.BMin[:], bmin)
+	copy(hf.BMax[:], bmax)
+	return hf
+}
+
+// spanPool returns a span pool. The items of a reused pool are not cleared.
+func (a *Arena) spanPool() *spanPool {
+	if a == nil {
+		return &spanPool{}
+	}
+	if a.usedPools == len(a.pools) {
+		a.pools = append(a.pools, &spanPool{})
+	}
+	pool := a.pools[a.usedPools]
+	a.usedPools++
+	pool.next = nil
+	return pool
+}
+
+// The following methods return zeroed slices of n elements. a can be nil, in
+// which case the slices are allocated with make.
+
+func (a *Arena) uint8Slice(n int) []uint8 {
+	if a == nil {
+		return make([]uint8, n)
+	}
+	return a.uint8s.alloc(n)
+}
+
+func (a *Arena) uint16Slice(n int) []uint16 {
+	if a == nil {
+		return make([]uint16, n)
+	}
+	return a.uint16s.alloc(n)
+}
+
+func (a *Arena) int32Slice(n int) []int32 {
+	if a == nil {
+		return make([]int32, n)
+	}
+	return a.int32s.alloc(n)
+}
+
+func (a *Arena) float32Slice(n int) []float32 {
+	if a == nil {
+		return make([]float32, n)
+	}
+	return a.float32s.alloc(n)
+}
+
+func (a *Arena) spanColumns(n int) []*Span {
+	if a == nil {
+		return make([]*Span, n)
+	}
+	return a.columns.alloc(n)
+}
+
+func (a *Arena) compactCells(n int) []CompactCell {
+	if a == nil {
+		return make([]CompactCell, n)
+	}
+	return a.cells.alloc(n)
+}
+
+func (a *Arena) compactSpans(n int) []CompactSpan {
+	if a == nil {
+		return make([]CompactSpan, n)
+	}
+	return a.spans.alloc(n)
+}
+
+// The typed arenas below are bump allocators: slices are cut one after the
+// other in a single buffer. The allocations that don't fit are made with
+// make, and their size is accounted for to grow the buffer at the next reset.
+// The returned slices have their capacity limited to their length so that
+// appending to them never overwrites the next slices.
+
+type uint8Arena struct {
+	buf       []uint8
+	off, over int
+}
+
+func (a *uint8Arena) alloc(n int) []uint8 {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]uint8, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+func (a *uint8Arena) reset() {
+	if a.over > 0 {
+		a.buf = make([]uint8, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type uint16Arena struct {
+	buf       []uint16
+	off, over int
+}
+
+func (a *uint16Arena) alloc(n int) []uint16 {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]uint16, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+func (a *uint16Arena) reset() {
+	if a.over > 0 {
+		a.buf = make([]uint16, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type int32Arena struct {
+	buf       []int32
+	off, over int
+}
+
+func (a *int32Arena) alloc(n int) []int32 {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]int32, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+func (a *int32Arena) reset() {
+	if a.over > 0 {
+		a.buf = make([]int32, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type float32Arena struct {
+	buf       []float32
+	off, over int
+}
+
+func (a *float32Arena) alloc(n int) []float32 {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]float32, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+func (a *float32Arena) reset() {
+	if a.over > 0 {
+		a.buf = make([]float32, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type spanColumnArena struct {
+	buf       []*Span
+	off, over int
+}
+
+func (a *spanColumnArena) alloc(n int) []*Span {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]*Span, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = nil
+	}
+	return s
+}
+
+func (a *spanColumnArena) reset() {
+	if a.over > 0 {
+		a.buf = make([]*Span, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type compactCellArena struct {
+	buf       []CompactCell
+	off, over int
+}
+
+func (a *compactCellArena) alloc(n int) []CompactCell {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]CompactCell, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = CompactCell{}
+	}
+	return s
+}
+
+func (a *compactCellArena) reset() {
+	if a.over > 0 {
+		a.buf = make([]CompactCell, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
+
+type compactSpanArena struct {
+	buf       []CompactSpan
+	off, over int
+}
+
+func (a *compactSpanArena) alloc(n int) []CompactSpan {
+	if a.off+n > len(a.buf) {
+		a.over += n
+		return make([]CompactSpan, n)
+	}
+	s := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range s {
+		s[i] = CompactSpan{}
+	}
+	return s
+}
+
+func (a *compactSpanArena) reset() {
+	if a.over > 0 {
+		a.buf = make([]CompactSpan, a.off+a.over)
+	}
+	a.off, a.over = 0, 0
+}
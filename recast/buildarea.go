@@ -0,0 +1,123 @@
+package recast
+
+import (
+	"github.com/arl/assertgo"
+	"github.com/arl/math32"
+)
+
+// A BuildBox is an axis aligned box, in world units, delimiting the part of
+// the input geometry to build a navigation mesh for.
+type BuildBox struct {
+	Min [3]float32 // The minimum bounds of the box. [(x, y, z)]
+	Max [3]float32 // The maximum bounds of the box. [(x, y, z)]
+}
+
+// contains reports whether the point (x, y, z) is inside the box.
+func (b *BuildBox) contains(x, y, z float32) bool {
+	return x >= b.Min[0] && x <= b.Max[0] &&
+		y >= b.Min[1] && y <= b.Max[1] &&
+		z >= b.Min[2] && z <= b.Max[2]
+}
+
+// ClipHeightfield removes the spans of the heightfield which surface is
+// outside of all the include boxes, if any, or inside one of the exclude
+// boxes, so that the geometry they come from is ignored.
+//
+//  Arguments:
+//   ctx      The build context to use during the operation.
+//   include  The boxes in which the spans are kept. If empty, all the spans
+//            are kept, except those in the exclude boxes.
+//   exclude  The boxes in which the spans are removed.
+//   solid    A fully built heightfield.  (All spans have been added.)
+//
+// The surface of a span is the center of its top, within its column.
+//
+// Removed spans are treated as missing neighbours by the filters, the
+// walkable spans at the border of the removed area thus become ledges if the
+// drop is too high.
+//
+// see Heightfield, BuildBox
+func ClipHeightfield(ctx *BuildContext, include, exclude []BuildBox, solid *Heightfield) {
+	assert.True(ctx != nil, "ctx should not be nil")
+	if len(include) == 0 && len(exclude) == 0 {
+		return
+	}
+
+	keep := func(x, y, z float32) bool {
+		for i := range exclude {
+			if exclude[i].contains(x, y, z) {
+				return false
+			}
+		}
+		if len(include) == 0 {
+			return true
+		}
+		for i := range include {
+			if include[i].contains(x, y, z) {
+				return true
+			}
+		}
+		return false
+	}
+
+	w := solid.Width
+	h := solid.Height
+	for y := int32(0); y < h; y++ {
+		for x := int32(0); x < w; x++ {
+			cx := solid.BMin[0] + (float32(x)+0.5)*solid.Cs
+			cz := solid.BMin[2] + (float32(y)+0.5)*solid.Cs
+
+			var prev *Span
+			s := solid.Spans[x+y*w]
+			for s != nil {
+				next := s.next
+				if keep(cx, solid.BMin[1]+float32(s.smax)*solid.Ch, cz) {
+					prev = s
+				} else {
+					// unlink and free the span
+					if prev == nil {
+						solid.Spans[x+y*w] = next
+					} else {
+						prev.next = next
+					}
+					solid.freeSpan(s)
+				}
+				s = next
+			}
+		}
+	}
+}
+
+// clipBounds intersects the bounds bmin, bmax with the build box b, and with
+// the union of the include boxes, if any.
+//
+// The resulting bounds are empty, with bmax equal to bmin, when they don't
+// overlap.
+func clipBounds(bmin, bmax []float32, b *BuildBox, include []BuildBox) {
+	if b != nil {
+		for i := 0; i < 3; i++ {
+			bmin[i] = math32.Max(bmin[i], b.Min[i])
+			bmax[i] = math32.Min(bmax[i], b.Max[i])
+		}
+	}
+	if len(include) != 0 {
+		var umin, umax [3]float32
+		copy(umin[:], include[0].Min[:])
+		copy(umax[:], include[0].Max[:])
+		for _, ib := range include[1:] {
+			for i := 0; i < 3; i++ {
+				umin[i] = math32.Min(umin[i], ib.Min[i])
+				umax[i] = math32.Max(umax[i], ib.Max[i])
+			}
+		}
+		for i := 0; i < 3; i++ {
+			bmin[i] = math32.Max(bmin[i], umin[i])
+			bmax[i] = math32.Min(bmax[i], umax[i])
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if bmax[i] < bmin[i] {
+			bmax[i] = bmin[i]
+		}
+	}
+}
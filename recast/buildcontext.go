@@ -1,20 +1,11 @@
 package recast
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
-// Recast log categories.
-// see BuildContext
-type logCategory int
-
-const (
-	logProgress logCategory = 1 + iota // A progress log entry.
-	logWarning                         // A warning log entry.
-	logError                           // An error log entry.
-)
-
 const maxMessages = 1000
 
 // BuildContext provides an interface for optional logging and performance
@@ -27,6 +18,17 @@ type BuildContext struct {
 	numMessages int
 	textPool    string
 
+	sinks    []LogSink    // Sinks receiving the log entries.
+	fields   []Field      // Fields added to all the log entries.
+	progress ProgressFunc // Called to report the build progress.
+
+	// Context interrupting the builds, if any.
+	context context.Context
+	done    <-chan struct{}
+
+	// Arena providing the memory of the built structures, if any.
+	arena *Arena
+
 	// True if logging is enabled.
 	logEnabled bool
 
@@ -74,7 +76,7 @@ func (ctx *BuildContext) ResetTimers() {
 // The format string and arguments are forwarded to fmt.Sprintf and thus accepts
 // the same format specifiers.
 func (ctx *BuildContext) Progressf(format string, v ...interface{}) {
-	ctx.log(logProgress, format, v...)
+	ctx.log(LogProgress, fmt.Sprintf(format, v...), nil)
 }
 
 // Warningf writes a new log entry in the 'warning' category.
@@ -82,7 +84,7 @@ func (ctx *BuildContext) Progressf(format string, v ...interface{}) {
 // The format string and arguments are forwarded to fmt.Sprintf and thus accepts
 // the same format specifiers.
 func (ctx *BuildContext) Warningf(format string, v ...interface{}) {
-	ctx.log(logWarning, format, v...)
+	ctx.log(LogWarning, fmt.Sprintf(format, v...), nil)
 }
 
 // Errorf writes a new log entry in the 'error' category.
@@ -90,26 +92,90 @@ func (ctx *BuildContext) Warningf(format string, v ...interface{}) {
 // The format string and arguments are forwarded to fmt.Sprintf and thus accepts
 // the same format specifiers.
 func (ctx *BuildContext) Errorf(format string, v ...interface{}) {
-	ctx.log(logError, format, v...)
+	ctx.log(LogError, fmt.Sprintf(format, v...), nil)
 }
 
-// log writes a new log entry in the specified category.
+// Log writes a new log entry, with structured fields, in the specified
+// category.
 //
-// The format string and arguments are forwarded to fmt.Sprintf and thus accepts
-// the same format specifiers.
-func (ctx *BuildContext) log(category logCategory, format string, v ...interface{}) {
-	if ctx.logEnabled && ctx.numMessages < maxMessages {
+// The entry is stored as text, with its fields, in the log entries of the
+// build context. It is also sent to the log sinks, with the fields of the
+// build context followed by its own fields.
+//
+// see AddLogSink, SetField
+func (ctx *BuildContext) Log(level LogLevel, msg string, fields ...Field) {
+	ctx.log(level, msg, fields)
+}
+
+// log writes a new log entry in the specified category.
+func (ctx *BuildContext) log(level LogLevel, msg string, fields []Field) {
+	if !ctx.logEnabled {
+		return
+	}
+	e := LogEntry{Level: level, Msg: msg, Fields: fields}
+	if ctx.numMessages < maxMessages {
 		// Store message
-		switch category {
-		case logProgress:
-			ctx.messages[ctx.numMessages] = "PROG " + fmt.Sprintf(format, v...)
-		case logWarning:
-			ctx.messages[ctx.numMessages] = "WARN " + fmt.Sprintf(format, v...)
-		case logError:
-			ctx.messages[ctx.numMessages] = "ERR " + fmt.Sprintf(format, v...)
-		}
+		ctx.messages[ctx.numMessages] = e.String()
 		ctx.numMessages++
 	}
+	if len(ctx.sinks) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	if len(ctx.fields) != 0 {
+		e.Fields = append(append([]Field(nil), ctx.fields...), fields...)
+	}
+	for _, s := range ctx.sinks {
+		s.Log(e)
+	}
+}
+
+// AddLogSink adds a sink to which the log entries are sent, as they are
+// written, while logging is enabled.
+func (ctx *BuildContext) AddLogSink(s LogSink) {
+	ctx.sinks = append(ctx.sinks, s)
+}
+
+// SetField sets a field added to all the log entries sent to the log sinks,
+// replacing the value of the field with the same key, if any. It gives
+// context to the entries, such as the build stage or the tile being built.
+func (ctx *BuildContext) SetField(key string, value interface{}) {
+	for i := range ctx.fields {
+		if ctx.fields[i].Key == key {
+			ctx.fields[i].Value = value
+			return
+		}
+	}
+	ctx.fields = append(ctx.fields, Field{Key: key, Value: value})
+}
+
+// RemoveField removes a field previously set with SetField.
+func (ctx *BuildContext) RemoveField(key string) {
+	for i := range ctx.fields {
+		if ctx.fields[i].Key == key {
+			ctx.fields = append(ctx.fields[:i], ctx.fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetProgressFunc sets the function called to report the progress of the
+// builds, or removes it if f is nil.
+func (ctx *BuildContext) SetProgressFunc(f ProgressFunc) {
+	ctx.progress = f
+}
+
+// ReportProgress reports the progress of a build made of several steps, to
+// the function set with SetProgressFunc, if any.
+//
+//  Arguments:
+//   stage   The name of what is being built, such as "tiles".
+//   done    The number of steps done.
+//   total   The total number of steps.
+func (ctx *BuildContext) ReportProgress(stage string, done, total int) {
+	if ctx.progress != nil {
+		ctx.progress(Progress{Stage: stage, Done: done, Total: total})
+	}
 }
 
 // DumpLog dumps all the log entries to stdout, preceded by a message.
@@ -156,6 +222,98 @@ func (ctx *BuildContext) StopTimer(label TimerLabel) {
 	}
 }
 
+// CanceledError is the error returned when a build is interrupted because the
+// context of its BuildContext is done.
+//
+// see BuildContext.SetContext
+type CanceledError struct {
+	Err error // The context error, context.Canceled or context.DeadlineExceeded.
+}
+
+func (e *CanceledError) Error() string {
+	return "build canceled: " + e.Err.Error()
+}
+
+// Unwrap returns the context error.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// SetArena sets the arena from which the build functions allocate the
+// structures they build, and their largest temporary buffers, or removes it
+// if a is nil.
+//
+// see Arena
+func (ctx *BuildContext) SetArena(a *Arena) {
+	ctx.arena = a
+}
+
+// Arena returns the arena set with SetArena, or nil.
+func (ctx *BuildContext) Arena() *Arena {
+	return ctx.arena
+}
+
+// SetContext sets the context which cancellation, or deadline, interrupts the
+// builds using this build context.
+//
+// The long running build functions, such as RasterizeTriangles,
+// BuildRegionsMonotone, BuildContours, BuildPolyMesh or BuildPolyMeshDetail
+// return false as soon as they notice that c is done, without logging an
+// error. Err then returns a *CanceledError. The partially built data should be
+// discarded.
+func (ctx *BuildContext) SetContext(c context.Context) {
+	ctx.context = c
+	ctx.done = nil
+	if c != nil {
+		ctx.done = c.Done()
+	}
+}
+
+// Context returns the context set with SetContext, or context.Background if
+// none has been set.
+func (ctx *BuildContext) Context() context.Context {
+	if ctx.context == nil {
+		return context.Background()
+	}
+	return ctx.context
+}
+
+// Err returns a *CanceledError if the context set with SetContext is done,
+// or nil if the builds can go on.
+func (ctx *BuildContext) Err() error {
+	if !ctx.canceled() {
+		return nil
+	}
+	return &CanceledError{Err: ctx.context.Err()}
+}
+
+// canceled reports whether the context set with SetContext is done.
+func (ctx *BuildContext) canceled() bool {
+	if ctx.done == nil {
+		return false
+	}
+	select {
+	case <-ctx.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// BuildTimes returns the accumulated time of all the performance timers that
+// have been used since the last call to ResetTimers.
+func (ctx *BuildContext) BuildTimes() BuildTimes {
+	bt := make(BuildTimes)
+	if ctx.timerEnabled {
+		for i := 0; i < maxTimers; i++ {
+			if ctx.accTime[i] != 0 {
+				bt[TimerLabel(i)] = ctx.accTime[i]
+			}
+		}
+	}
+	return bt
+}
+
 // AccumulatedTime returns the total accumulated time of the specified
 // performance timer.
 //
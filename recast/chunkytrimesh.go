@@ -16,6 +16,7 @@ type ChunkyTriMesh struct {
 	Nodes           []ChunkyTriMeshNode
 	Nnodes          int32
 	Tris            []int32
+	Areas           []uint8 // area ids of Tris, nil if the mesh has none
 	Ntris           int32
 	MaxTrisPerChunk int32
 }
@@ -60,7 +61,7 @@ func longestAxis(x, y float32) int {
 
 func subdivide(items []BoundsItem, nitems, imin, imax, trisPerChunk int32,
 	curNode *int32, nodes []ChunkyTriMeshNode, maxNodes int32,
-	curTri *int32, outTris, inTris []int32) {
+	curTri *int32, outTris, inTris []int32, outAreas, inAreas []uint8) {
 
 	inum := imax - imin
 	icur := *curNode
@@ -83,6 +84,9 @@ func subdivide(items []BoundsItem, nitems, imin, imax, trisPerChunk int32,
 		for i := imin; i < imax; i++ {
 			src := inTris[items[i].i*3:]
 			dst := outTris[(*curTri)*3:]
+			if inAreas != nil {
+				outAreas[*curTri] = inAreas[items[i].i]
+			}
 			(*curTri)++
 			copy(dst, src[:3])
 		}
@@ -107,9 +111,9 @@ func subdivide(items []BoundsItem, nitems, imin, imax, trisPerChunk int32,
 		isplit := imin + inum/2
 
 		// Left
-		subdivide(items, nitems, imin, isplit, trisPerChunk, curNode, nodes, maxNodes, curTri, outTris, inTris)
+		subdivide(items, nitems, imin, isplit, trisPerChunk, curNode, nodes, maxNodes, curTri, outTris, inTris, outAreas, inAreas)
 		// Right
-		subdivide(items, nitems, isplit, imax, trisPerChunk, curNode, nodes, maxNodes, curTri, outTris, inTris)
+		subdivide(items, nitems, isplit, imax, trisPerChunk, curNode, nodes, maxNodes, curTri, outTris, inTris, outAreas, inAreas)
 
 		iescape := (*curNode) - icur
 		// Negative index means escape.
@@ -119,7 +123,9 @@ func subdivide(items []BoundsItem, nitems, imin, imax, trisPerChunk int32,
 
 // Creates partitioned triangle mesh (AABB tree),
 // where each node contains at max trisPerChunk triangles.
-func createChunkyTriMesh(verts []float32, tris []int32, ntris, trisPerChunk int32, cm *ChunkyTriMesh) bool {
+//
+// areas, the triangle area ids, may be nil.
+func createChunkyTriMesh(verts []float32, tris []int32, areas []uint8, ntris, trisPerChunk int32, cm *ChunkyTriMesh) bool {
 	nchunks := (ntris + trisPerChunk - 1) / trisPerChunk
 	cm.Nodes = make([]ChunkyTriMeshNode, nchunks*4)
 	if len(cm.Nodes) == 0 {
@@ -131,6 +137,11 @@ func createChunkyTriMesh(verts []float32, tris []int32, ntris, trisPerChunk int3
 		return false
 	}
 
+	cm.Areas = nil
+	if areas != nil {
+		cm.Areas = make([]uint8, ntris)
+	}
+
 	cm.Ntris = ntris
 
 	// Build tree
@@ -168,7 +179,7 @@ func createChunkyTriMesh(verts []float32, tris []int32, ntris, trisPerChunk int3
 	}
 
 	var curTri, curNode int32
-	subdivide(items, ntris, 0, ntris, trisPerChunk, &curNode, cm.Nodes, nchunks*4, &curTri, cm.Tris, tris)
+	subdivide(items, ntris, 0, ntris, trisPerChunk, &curNode, cm.Nodes, nchunks*4, &curTri, cm.Tris, tris, cm.Areas, areas)
 
 	items = nil
 
@@ -234,7 +234,7 @@ func BuildContours(ctx *BuildContext, chf *CompactHeightfield,
 	cset.Conts = make([]Contour, maxContours)
 	cset.NConts = 0
 
-	flags := make([]uint8, chf.SpanCount)
+	flags := ctx.arena.uint8Slice(int(chf.SpanCount))
 
 	ctx.StartTimer(TimerBuildContoursTrace)
 
@@ -273,6 +273,9 @@ func BuildContours(ctx *BuildContext, chf *CompactHeightfield,
 	simplified := make([]int32, 64)
 
 	for y := int32(0); y < h; y++ {
+		if ctx.canceled() {
+			return false
+		}
 		for x := int32(0); x < w; x++ {
 			c := &chf.Cells[x+y*w]
 			i := int32(c.Index)
@@ -323,7 +326,7 @@ func BuildContours(ctx *BuildContext, chf *CompactHeightfield,
 					cont := &cset.Conts[cset.NConts]
 					cset.NConts++
 					cont.NVerts = int32(len(simplified) / 4)
-					cont.Verts = make([]int32, cont.NVerts*4)
+					cont.Verts = ctx.arena.int32Slice(int(cont.NVerts * 4))
 					copy(cont.Verts, simplified[:cont.NVerts*4])
 					if borderSize > 0 {
 						// If the heightfield was build with bordersize, remove the offset.
@@ -335,7 +338,7 @@ func BuildContours(ctx *BuildContext, chf *CompactHeightfield,
 					}
 
 					cont.NRVerts = int32(len(verts) / 4)
-					cont.RVerts = make([]int32, cont.NRVerts*4)
+					cont.RVerts = ctx.arena.int32Slice(int(cont.NRVerts * 4))
 					copy(cont.RVerts, verts[:cont.NRVerts*4])
 					if borderSize > 0 {
 						// If the heightfield was build with bordersize, remove the offset.
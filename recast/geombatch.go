@@ -0,0 +1,267 @@
+package recast
+
+import (
+	"fmt"
+)
+
+// Transform is an affine transformation, stored as a column-major 4x4
+// matrix.
+type Transform [16]float32
+
+// IdentityTransform is the transform that leaves the points unchanged.
+var IdentityTransform = Transform{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+// Mul returns the transform applying b, then t.
+func (t Transform) Mul(b Transform) Transform {
+	var m Transform
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			var s float32
+			for k := 0; k < 4; k++ {
+				s += t[k*4+r] * b[c*4+k]
+			}
+			m[c*4+r] = s
+		}
+	}
+	return m
+}
+
+// Apply transforms the point v and stores the result in dst, dst and v may
+// be the same slice.
+func (t *Transform) Apply(dst, v []float32) {
+	x, y, z := v[0], v[1], v[2]
+	dst[0] = t[0]*x + t[4]*y + t[8]*z + t[12]
+	dst[1] = t[1]*x + t[5]*y + t[9]*z + t[13]
+	dst[2] = t[2]*x + t[6]*y + t[10]*z + t[14]
+}
+
+// mirrors reports whether t mirrors the geometry, i.e if the determinant of
+// its linear part is negative.
+func (t *Transform) mirrors() bool {
+	det := t[0]*(t[5]*t[10]-t[9]*t[6]) -
+		t[4]*(t[1]*t[10]-t[9]*t[2]) +
+		t[8]*(t[1]*t[6]-t[5]*t[2])
+	return det < 0
+}
+
+// BatchHandle identifies a batch of triangles added to an InputGeom.
+type BatchHandle uint32
+
+// geomBatch is a batch of triangles, with its own chunky mesh.
+type geomBatch struct {
+	handle BatchHandle
+	verts  []float32 // transformed vertices
+	tris   []int32   // indices local to the batch vertices
+	areas  []uint8
+	chunky *ChunkyTriMesh // nil if the batch has no triangles
+}
+
+// batchMesh is the InputMesh made of the concatenation of the batches added
+// to an InputGeom.
+type batchMesh struct {
+	triMesh
+	areas   []uint8
+	batches []*geomBatch
+	next    BatchHandle
+}
+
+// Areas returns the area ids of the triangles. [Length: TriCount]
+func (bm *batchMesh) Areas() []uint8 {
+	return bm.areas
+}
+
+// AddBatch appends a batch of triangles to the input geometry, and returns
+// the handle to use to remove it.
+//
+// The mesh previously loaded with LoadMesh or SetMesh, if any, is kept as the
+// batch of handle 0. The chunky mesh is rebuilt without subdividing the
+// triangles of the other batches again, which makes adding and removing
+// batches much cheaper than loading the whole geometry again.
+//
+//  Arguments:
+//   verts   The batch vertices. [(x, y, z) * nverts]
+//   tris    The triangle vertex indices, relative to verts.
+//           [(vertA, vertB, vertC) * ntris]
+//   xform   The transform applied to verts, or nil for the identity.
+//   areas   The area id of each triangle, or nil to give WalkableArea to all
+//           triangles. [Length: ntris]
+func (ig *InputGeom) AddBatch(verts []float32, tris []int32, xform *Transform, areas []uint8) (BatchHandle, error) {
+	if len(verts)%3 != 0 || len(tris)%3 != 0 {
+		return 0, fmt.Errorf("vertices and triangles slices must have a length multiple of 3")
+	}
+	nverts := int32(len(verts) / 3)
+	ntris := len(tris) / 3
+	for _, i := range tris {
+		if i < 0 || i >= nverts {
+			return 0, fmt.Errorf("triangle vertex index %d out of range [0, %d)", i, nverts)
+		}
+	}
+	if areas != nil && len(areas) != ntris {
+		return 0, fmt.Errorf("got %d areas for %d triangles", len(areas), ntris)
+	}
+
+	b := &geomBatch{
+		verts: make([]float32, len(verts)),
+		tris:  make([]int32, len(tris)),
+		areas: make([]uint8, ntris),
+	}
+	copy(b.verts, verts)
+	copy(b.tris, tris)
+	if areas != nil {
+		copy(b.areas, areas)
+	} else {
+		for i := range b.areas {
+			b.areas[i] = WalkableArea
+		}
+	}
+	if xform != nil {
+		for i := 0; i < len(b.verts); i += 3 {
+			xform.Apply(b.verts[i:i+3], b.verts[i:i+3])
+		}
+		if xform.mirrors() {
+			// keep the triangles facing the same side
+			for i := 0; i < len(b.tris); i += 3 {
+				b.tris[i+1], b.tris[i+2] = b.tris[i+2], b.tris[i+1]
+			}
+		}
+	}
+	if err := b.buildChunky(); err != nil {
+		return 0, err
+	}
+
+	bm := ig.batchMesh()
+	b.handle = bm.next
+	bm.next++
+	bm.batches = append(bm.batches, b)
+	ig.updateBatches()
+	return b.handle, nil
+}
+
+// RemoveBatch removes the batch of triangles identified by h from the input
+// geometry. It returns false if there is no such batch.
+func (ig *InputGeom) RemoveBatch(h BatchHandle) bool {
+	bm := ig.batchMesh()
+	for i, b := range bm.batches {
+		if b.handle == h {
+			bm.batches = append(bm.batches[:i], bm.batches[i+1:]...)
+			ig.updateBatches()
+			return true
+		}
+	}
+	return false
+}
+
+// batchMesh returns the batches of the input geometry, converting the mesh
+// previously loaded, if any, into the batch of handle 0.
+func (ig *InputGeom) batchMesh() *batchMesh {
+	if ig.batches != nil {
+		return ig.batches
+	}
+	bm := &batchMesh{next: 1}
+	if m := ig.mesh; m != nil && m.TriCount() > 0 {
+		b := &geomBatch{
+			verts: m.Verts()[:m.VertCount()*3],
+			tris:  m.Tris()[:m.TriCount()*3],
+			areas: make([]uint8, m.TriCount()),
+		}
+		if areas := MeshAreas(m); areas != nil {
+			copy(b.areas, areas)
+		} else {
+			for i := range b.areas {
+				b.areas[i] = WalkableArea
+			}
+		}
+		// reuse the chunky mesh already built for the whole mesh
+		b.chunky = ig.chunkyMesh
+		bm.batches = append(bm.batches, b)
+	}
+	ig.batches = bm
+	return bm
+}
+
+// buildChunky builds the chunky mesh of the batch triangles.
+func (b *geomBatch) buildChunky() error {
+	b.chunky = nil
+	ntris := int32(len(b.tris) / 3)
+	if ntris == 0 {
+		return nil
+	}
+	b.chunky = new(ChunkyTriMesh)
+	if !createChunkyTriMesh(b.verts, b.tris, b.areas, ntris, 256, b.chunky) {
+		return fmt.Errorf("failed to build chunky mesh")
+	}
+	return nil
+}
+
+// updateBatches rebuilds the input geometry mesh and chunky mesh from the
+// batches.
+//
+// The chunky mesh is made of the concatenation of the batches chunky meshes,
+// the escape index of the root of each batch tree allowing the traversal to
+// skip to the next one.
+func (ig *InputGeom) updateBatches() {
+	bm := ig.batches
+	var nverts, ntris, nnodes int
+	for _, b := range bm.batches {
+		nverts += len(b.verts)
+		ntris += len(b.tris) / 3
+		if b.chunky != nil {
+			nnodes += int(b.chunky.Nnodes)
+		}
+	}
+
+	bm.verts = make([]float32, 0, nverts)
+	bm.tris = make([]int32, 0, ntris*3)
+	bm.areas = make([]uint8, 0, ntris)
+	cm := &ChunkyTriMesh{
+		Nodes: make([]ChunkyTriMeshNode, 0, nnodes),
+		Tris:  make([]int32, 0, ntris*3),
+		Areas: make([]uint8, 0, ntris),
+	}
+	for _, b := range bm.batches {
+		vbase := int32(len(bm.verts) / 3)
+		bm.verts = append(bm.verts, b.verts...)
+		for _, i := range b.tris {
+			bm.tris = append(bm.tris, vbase+i)
+		}
+		bm.areas = append(bm.areas, b.areas...)
+
+		if b.chunky == nil {
+			continue
+		}
+		tbase := int32(len(cm.Tris) / 3)
+		for _, n := range b.chunky.Nodes[:b.chunky.Nnodes] {
+			if n.I >= 0 {
+				n.I += tbase
+			}
+			cm.Nodes = append(cm.Nodes, n)
+		}
+		for _, i := range b.chunky.Tris[:b.chunky.Ntris*3] {
+			cm.Tris = append(cm.Tris, vbase+i)
+		}
+		if b.chunky.Areas != nil {
+			cm.Areas = append(cm.Areas, b.chunky.Areas...)
+		} else {
+			// the mesh loaded before adding batches had no areas
+			for i := int32(0); i < b.chunky.Ntris; i++ {
+				cm.Areas = append(cm.Areas, WalkableArea)
+			}
+		}
+		if b.chunky.MaxTrisPerChunk > cm.MaxTrisPerChunk {
+			cm.MaxTrisPerChunk = b.chunky.MaxTrisPerChunk
+		}
+	}
+	cm.Nnodes = int32(len(cm.Nodes))
+	cm.Ntris = int32(len(cm.Tris) / 3)
+	bm.calcNormals()
+
+	ig.mesh = bm
+	ig.chunkyMesh = cm
+	if len(bm.verts) == 0 {
+		ig.meshBMin = [3]float32{}
+		ig.meshBMax = [3]float32{}
+	} else {
+		CalcBounds(bm.verts, bm.VertCount(), ig.meshBMin[:], ig.meshBMax[:])
+	}
+	ig.updateNavMeshBounds()
+}
@@ -41,20 +41,14 @@ type Heightfield struct {
 	Spans    []*Span    // Heightfield of spans (width*height).
 	Pools    *spanPool  // Linked list of span pools.
 	Freelist *Span      // The next free span.
+
+	arena *Arena // Arena the heightfield is allocated from, if any.
 }
 
 // See the Config documentation for more information on the configuration parameters.
 func NewHeightfield(width, height int32, bmin, bmax []float32, cs, ch float32) *Heightfield {
-	hf := &Heightfield{
-		Width:  width,
-		Height: height,
-		Cs:     cs,
-		Ch:     ch,
-		Spans:  make([]*Span, width*height),
-	}
-	copy(hf.BMin[:], bmin)
-	copy(hf.BMax[:], bmax)
-	return hf
+	var a *Arena
+	return a.NewHeightfield(width, height, bmin, bmax, cs, ch)
 }
 
 func (hf *Heightfield) Free() {
@@ -72,12 +66,38 @@ func (hf *Heightfield) Free() {
 	}
 }
 
+// Clone returns a deep copy of the heightfield.
+//
+// As the heightfield filters modify the spans in place, cloning allows to
+// rasterize the input geometry once, then to filter it with different agent
+// settings.
+func (hf *Heightfield) Clone() *Heightfield {
+	clone := hf.arena.NewHeightfield(hf.Width, hf.Height, hf.BMin[:], hf.BMax[:], hf.Cs, hf.Ch)
+	for i, s := range hf.Spans {
+		var prev *Span
+		for ; s != nil; s = s.next {
+			cs := clone.allocSpan()
+			cs.smin = s.smin
+			cs.smax = s.smax
+			cs.area = s.area
+			cs.next = nil
+			if prev == nil {
+				clone.Spans[i] = cs
+			} else {
+				prev.next = cs
+			}
+			prev = cs
+		}
+	}
+	return clone
+}
+
 func (hf *Heightfield) allocSpan() *Span {
 	// If running out of memory, allocate new page and update the freelist.
 	if hf.Freelist == nil || hf.Freelist.next == nil {
 		// Create new page.
 		// Allocate memory for the new pool.
-		pool := &spanPool{}
+		pool := hf.arena.spanPool()
 		if pool == nil {
 			return nil
 		}
@@ -285,9 +305,9 @@ func BuildCompactHeightfield(ctx *BuildContext, walkableHeight, walkableClimb in
 	chf.BMax[1] += float32(walkableHeight) * hf.Ch
 	chf.Cs = hf.Cs
 	chf.Ch = hf.Ch
-	chf.Cells = make([]CompactCell, w*h)
-	chf.Spans = make([]CompactSpan, spanCount)
-	chf.Areas = make([]uint8, spanCount)
+	chf.Cells = ctx.arena.compactCells(int(w * h))
+	chf.Spans = ctx.arena.compactSpans(int(spanCount))
+	chf.Areas = ctx.arena.uint8Slice(int(spanCount))
 	for i := range chf.Areas {
 		chf.Areas[i] = nullArea
 	}
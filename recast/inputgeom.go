@@ -65,15 +65,93 @@ type BuildSettings struct {
 
 	// Size of the tiles in voxels
 	TileSize float32
+
+	// AgentProfiles is an optional list of agent profiles. When not empty, a
+	// navigation mesh is built for each profile, the agent properties of the
+	// profile replacing those of the build settings.
+	AgentProfiles []AgentProfile `yaml:"agentprofiles,omitempty" json:",omitempty"`
+
+	// MaterialAreas maps the names of the materials of an OBJ input geometry
+	// to the area ids given to the triangles using them.
+	MaterialAreas map[string]uint8 `yaml:"materialareas,omitempty" json:",omitempty"`
+
+	// Areas defines the area ids of the navigation mesh polygons, with the
+	// flags given to them and their default traversal cost. If empty, the
+	// navigation mesh builders use their own default definitions.
+	Areas []AreaDefinition `yaml:"areas,omitempty" json:",omitempty"`
+
+	// BuildBounds, if not nil, limits the navigation mesh to this box. The
+	// input geometry outside of it is ignored.
+	BuildBounds *BuildBox `yaml:"buildbounds,omitempty" json:",omitempty"`
+
+	// IncludeBoxes, if not empty, limits the navigation mesh to these boxes.
+	// The input geometry outside of all of them is ignored.
+	IncludeBoxes []BuildBox `yaml:"includeboxes,omitempty" json:",omitempty"`
+
+	// ExcludeBoxes are boxes in which the input geometry is ignored.
+	ExcludeBoxes []BuildBox `yaml:"excludeboxes,omitempty" json:",omitempty"`
+}
+
+// AreaDefinition describes an area id used in a navigation mesh.
+type AreaDefinition struct {
+	// Name of the area
+	Name string
+
+	// Area id, in [0, WalkableArea]
+	ID uint8
+
+	// Flags of the polygons of this area
+	Flags uint16
+
+	// Default cost multiplier of the traversal of this area
+	Cost float32
+}
+
+// AgentProfile describes the properties of a kind of agent, for which a
+// specific navigation mesh should be built.
+type AgentProfile struct {
+	// Name of the profile, must be unique among the profiles
+	Name string
+
+	// Agent height in world units
+	Height float32
+
+	// Agent radius in world units
+	Radius float32
+
+	// Agent max climb in world units
+	MaxClimb float32
+
+	// Agent max slope in degrees
+	MaxSlope float32
+}
+
+// WithProfile returns a copy of the build settings in which the agent
+// properties are replaced by those of the agent profile p. The returned
+// settings have no agent profiles.
+func (s BuildSettings) WithProfile(p AgentProfile) BuildSettings {
+	s.AgentHeight = p.Height
+	s.AgentRadius = p.Radius
+	s.AgentMaxClimb = p.MaxClimb
+	s.AgentMaxSlope = p.MaxSlope
+	s.AgentProfiles = nil
+	return s
 }
 
 // InputGeom gathers the geometry used as input for navigation mesh building.
 type InputGeom struct {
 	chunkyMesh *ChunkyTriMesh
-	mesh       *MeshLoaderOBJ
+	mesh       InputMesh
+	batches    *batchMesh
 
 	meshBMin, meshBMax [3]float32
 
+	// Build area.
+	buildBounds              *BuildBox
+	includeBoxes             []BuildBox
+	excludeBoxes             []BuildBox
+	navMeshBMin, navMeshBMax [3]float32
+
 	// Off-Mesh connections.
 	offMeshConVerts [maxOffMeshConnections * 3 * 2]float32
 	offMeshConRads  [maxOffMeshConnections]float32
@@ -90,23 +168,36 @@ type InputGeom struct {
 
 // LoadOBJMesh loads the geometry from a reader on a OBJ file.
 func (ig *InputGeom) LoadOBJMesh(r io.Reader) error {
-	var err error
-	if ig.mesh != nil {
-		ig.chunkyMesh = nil
-		ig.mesh = nil
-	}
+	return ig.LoadMesh(NewMeshLoaderOBJ(), r)
+}
+
+// LoadMesh loads the geometry from r with the mesh loader ml.
+//
+// The off-mesh connections and convex volumes are reset.
+func (ig *InputGeom) LoadMesh(ml MeshLoader, r io.Reader) error {
+	ig.chunkyMesh = nil
+	ig.mesh = nil
 	ig.offMeshConCount = 0
 	ig.volumeCount = 0
 
-	ig.mesh = NewMeshLoaderOBJ()
-	if err = ig.mesh.Load(r); err != nil {
+	if err := ml.Load(r); err != nil {
 		return err
 	}
+	return ig.SetMesh(ml)
+}
 
-	CalcBounds(ig.mesh.Verts(), ig.mesh.VertCount(), ig.meshBMin[:], ig.meshBMax[:])
+// SetMesh sets the triangle mesh to use as input geometry, replacing the
+// previously loaded mesh and added batches.
+func (ig *InputGeom) SetMesh(m InputMesh) error {
+	ig.chunkyMesh = nil
+	ig.mesh = m
+	ig.batches = nil
+
+	CalcBounds(m.Verts(), m.VertCount(), ig.meshBMin[:], ig.meshBMax[:])
+	ig.updateNavMeshBounds()
 
 	ig.chunkyMesh = new(ChunkyTriMesh)
-	if !createChunkyTriMesh(ig.mesh.Verts(), ig.mesh.Tris(), ig.mesh.TriCount(), 256, ig.ChunkyMesh()) {
+	if !createChunkyTriMesh(m.Verts(), m.Tris(), MeshAreas(m), m.TriCount(), 256, ig.ChunkyMesh()) {
 		return fmt.Errorf("failed to build chunky mesh")
 	}
 
@@ -114,7 +205,7 @@ func (ig *InputGeom) LoadOBJMesh(r io.Reader) error {
 }
 
 // Mesh returns static mesh data.
-func (ig *InputGeom) Mesh() *MeshLoaderOBJ {
+func (ig *InputGeom) Mesh() InputMesh {
 	return ig.mesh
 }
 
@@ -130,18 +221,61 @@ func (ig *InputGeom) MeshBoundsMax() []float32 {
 
 // NavMeshBoundsMin return the min point of the navmesh bounding box.
 //
-// TODO: currently returns the same point as MeshBoundsMin but it should
-// normally depend on the nav mesh build settings
+// The navmesh bounding box is the bounding box of the mesh, clipped by the
+// build area.
+//
+// see SetBuildArea
 func (ig *InputGeom) NavMeshBoundsMin() []float32 {
-	return ig.meshBMin[:3]
+	return ig.navMeshBMin[:3]
 }
 
 // NavMeshBoundsMax return the max point of the navmesh bounding box.
 //
-// TODO: currently returns the same point as MeshBoundsMax but it should
-// normally depend on the nav mesh build settings
+// see NavMeshBoundsMin
 func (ig *InputGeom) NavMeshBoundsMax() []float32 {
-	return ig.meshBMax[:3]
+	return ig.navMeshBMax[:3]
+}
+
+// SetBuildArea sets the part of the input geometry to build a navigation
+// mesh for. It is kept when another mesh is loaded.
+//
+//  Arguments:
+//   bounds   If not nil, the navmesh bounds are limited to this box.
+//   include  If not empty, the navmesh is limited to these boxes.
+//   exclude  The boxes in which the geometry is ignored.
+//
+// The navmesh bounds are clipped to bounds and to the bounding box of the
+// include boxes. The spans of the rasterized geometry outside the include
+// boxes, or inside the exclude boxes, should be removed with ClipHeightfield.
+//
+// see BuildSettings.BuildBounds, ClipHeightfield
+func (ig *InputGeom) SetBuildArea(bounds *BuildBox, include, exclude []BuildBox) {
+	ig.buildBounds = nil
+	if bounds != nil {
+		b := *bounds
+		ig.buildBounds = &b
+	}
+	ig.includeBoxes = append([]BuildBox(nil), include...)
+	ig.excludeBoxes = append([]BuildBox(nil), exclude...)
+	ig.updateNavMeshBounds()
+}
+
+// IncludeBoxes returns the include boxes of the build area.
+func (ig *InputGeom) IncludeBoxes() []BuildBox {
+	return ig.includeBoxes
+}
+
+// ExcludeBoxes returns the exclude boxes of the build area.
+func (ig *InputGeom) ExcludeBoxes() []BuildBox {
+	return ig.excludeBoxes
+}
+
+// updateNavMeshBounds computes the navmesh bounds from the mesh bounds and
+// the build area.
+func (ig *InputGeom) updateNavMeshBounds() {
+	ig.navMeshBMin = ig.meshBMin
+	ig.navMeshBMax = ig.meshBMax
+	clipBounds(ig.navMeshBMin[:], ig.navMeshBMax[:], ig.buildBounds, ig.includeBoxes)
 }
 
 // ChunkyMesh returns the underlying chunky triangle mesh.
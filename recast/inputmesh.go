@@ -0,0 +1,182 @@
+package recast
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arl/math32"
+)
+
+// InputMesh is the interface implemented by the triangle meshes that can be
+// used as input geometry for navigation mesh building.
+type InputMesh interface {
+	// Verts returns the mesh vertices. [(x, y, z) * VertCount]
+	Verts() []float32
+
+	// Tris returns the triangle vertex indices. [(vertA, vertB, vertC) * TriCount]
+	Tris() []int32
+
+	// Normals returns the triangle normals. [(x, y, z) * TriCount]
+	Normals() []float32
+
+	// VertCount returns the number of vertices.
+	VertCount() int32
+
+	// TriCount returns the number of triangles.
+	TriCount() int32
+}
+
+// AreaMesh is the interface implemented by the input meshes defining an area
+// id for each triangle.
+type AreaMesh interface {
+	InputMesh
+
+	// Areas returns the area ids of the triangles. [Length: TriCount]
+	Areas() []uint8
+}
+
+// MeshAreas returns the triangle area ids of m, or nil if m doesn't define
+// any.
+func MeshAreas(m InputMesh) []uint8 {
+	am, ok := m.(AreaMesh)
+	if !ok {
+		return nil
+	}
+	areas := am.Areas()
+	if areas == nil || len(areas) != int(m.TriCount()) {
+		return nil
+	}
+	return areas
+}
+
+// MeshLoader is the interface implemented by the input meshes that can be
+// loaded from a reader.
+type MeshLoader interface {
+	InputMesh
+
+	// Load loads the mesh from r.
+	Load(r io.Reader) error
+}
+
+// NewMeshLoader returns the mesh loader matching the extension of filename.
+//
+// The supported extensions are .obj, .stl, .ply, .gltf, .glb, .png, .raw and
+// .r16, the last 3 being heightmaps. The external buffers referenced by a glTF
+// file are resolved relatively to the directory containing filename.
+func NewMeshLoader(filename string) (MeshLoader, error) {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".obj":
+		return NewMeshLoaderOBJ(), nil
+	case ".stl":
+		return NewMeshLoaderSTL(), nil
+	case ".ply":
+		return NewMeshLoaderPLY(), nil
+	case ".gltf", ".glb":
+		ml := NewMeshLoaderGLTF()
+		dir := filepath.Dir(filename)
+		ml.ResolveURI = func(uri string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(dir, filepath.FromSlash(uri)))
+		}
+		return ml, nil
+	case ".png", ".raw", ".r16":
+		return NewMeshLoaderHeightmap(), nil
+	default:
+		return nil, fmt.Errorf("unsupported mesh format %q", ext)
+	}
+}
+
+// triMesh is the triangle mesh storage shared by the mesh loaders.
+type triMesh struct {
+	verts   []float32
+	tris    []int32
+	normals []float32
+}
+
+func (m *triMesh) reset() {
+	m.verts = m.verts[:0]
+	m.tris = m.tris[:0]
+	m.normals = m.normals[:0]
+}
+
+// Verts implements the InputMesh interface.
+func (m *triMesh) Verts() []float32 {
+	return m.verts
+}
+
+// Tris implements the InputMesh interface.
+func (m *triMesh) Tris() []int32 {
+	return m.tris
+}
+
+// Normals implements the InputMesh interface.
+func (m *triMesh) Normals() []float32 {
+	return m.normals
+}
+
+// VertCount implements the InputMesh interface.
+func (m *triMesh) VertCount() int32 {
+	return int32(len(m.verts) / 3)
+}
+
+// TriCount implements the InputMesh interface.
+func (m *triMesh) TriCount() int32 {
+	return int32(len(m.tris) / 3)
+}
+
+// calcNormals computes the normals of all the mesh triangles.
+func (m *triMesh) calcNormals() {
+	m.normals = triNormals(m.verts, m.tris)
+}
+
+// triNormals returns the normals of the triangles tris.
+func triNormals(verts []float32, tris []int32) []float32 {
+	var e0, e1 [3]float32
+	normals := make([]float32, len(tris))
+	for i := 0; i < len(tris); i += 3 {
+		v0 := verts[tris[i]*3 : 3+tris[i]*3]
+		v1 := verts[tris[i+1]*3 : 3+tris[i+1]*3]
+		v2 := verts[tris[i+2]*3 : 3+tris[i+2]*3]
+		for j := 0; j < 3; j++ {
+			e0[j] = v1[j] - v0[j]
+			e1[j] = v2[j] - v0[j]
+		}
+		n := normals[i : 3+i]
+		n[0] = e0[1]*e1[2] - e0[2]*e1[1]
+		n[1] = e0[2]*e1[0] - e0[0]*e1[2]
+		n[2] = e0[0]*e1[1] - e0[1]*e1[0]
+		d := math32.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+		if d > 0 {
+			d = 1.0 / d
+			n[0] *= d
+			n[1] *= d
+			n[2] *= d
+		}
+	}
+	return normals
+}
+
+// vertexWelder merges the identical vertices of the meshes loaded from
+// formats where triangles are not indexed.
+type vertexWelder struct {
+	m   *triMesh
+	idx map[[3]float32]int32
+}
+
+func newVertexWelder(m *triMesh) *vertexWelder {
+	return &vertexWelder{m: m, idx: make(map[[3]float32]int32)}
+}
+
+// add returns the index of v, adding it to the mesh vertices if it is not
+// already present.
+func (w *vertexWelder) add(v [3]float32) int32 {
+	if i, ok := w.idx[v]; ok {
+		return i
+	}
+	i := int32(len(w.m.verts) / 3)
+	w.m.verts = append(w.m.verts, v[0], v[1], v[2])
+	w.idx[v] = i
+	return i
+}
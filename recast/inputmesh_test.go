@@ -0,0 +1,392 @@
+package recast
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+// checkMesh checks the vertices and triangles of m.
+func checkMesh(t *testing.T, m InputMesh, verts []float32, tris []int32) {
+	if m.VertCount() != int32(len(verts)/3) || m.TriCount() != int32(len(tris)/3) {
+		t.Fatalf("got %d verts and %d tris, want %d and %d",
+			m.VertCount(), m.TriCount(), len(verts)/3, len(tris)/3)
+	}
+	for i, v := range verts {
+		if math.Abs(float64(m.Verts()[i]-v)) > 1e-5 {
+			t.Fatalf("verts = %v, want %v", m.Verts(), verts)
+		}
+	}
+	for i, v := range tris {
+		if m.Tris()[i] != v {
+			t.Fatalf("tris = %v, want %v", m.Tris(), tris)
+		}
+	}
+	if len(m.Normals()) != len(tris) {
+		t.Fatalf("got %d normals coordinates, want %d", len(m.Normals()), len(tris))
+	}
+}
+
+// a quad made of 2 triangles sharing an edge, facing up.
+var (
+	quadVerts = []float32{0, 0, 0, 0, 0, 1, 1, 0, 0, 1, 0, 1}
+	quadTris  = []int32{0, 1, 2, 2, 1, 3}
+)
+
+func TestMeshLoaderSTL(t *testing.T) {
+	ascii := `solid quad
+facet normal 0 1 0
+  outer loop
+    vertex 0 0 0
+    vertex 0 0 1
+    vertex 1 0 0
+  endloop
+endfacet
+facet normal 0 1 0
+  outer loop
+    vertex 1 0 0
+    vertex 0 0 1
+    vertex 1 0 1
+  endloop
+endfacet
+endsolid quad
+`
+	ml := NewMeshLoaderSTL()
+	if err := ml.Load(strings.NewReader(ascii)); err != nil {
+		t.Fatal(err)
+	}
+	checkMesh(t, ml, quadVerts, quadTris)
+	if ml.Normals()[1] != 1 {
+		t.Fatalf("normal = %v, want +Y", ml.Normals()[:3])
+	}
+
+	// same mesh in binary, with a header starting with "solid"
+	var buf bytes.Buffer
+	header := make([]byte, 80)
+	copy(header, "solid binary")
+	buf.Write(header)
+	binary.Write(&buf, binary.LittleEndian, uint32(2))
+	for i := 0; i < len(quadTris); i += 3 {
+		binary.Write(&buf, binary.LittleEndian, [3]float32{0, 1, 0})
+		for j := 0; j < 3; j++ {
+			v := quadTris[i+j] * 3
+			binary.Write(&buf, binary.LittleEndian, quadVerts[v:v+3])
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(0))
+	}
+	ml = NewMeshLoaderSTL()
+	if err := ml.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkMesh(t, ml, quadVerts, quadTris)
+}
+
+func TestMeshLoaderPLY(t *testing.T) {
+	ascii := `ply
+format ascii 1.0
+comment a quad
+element vertex 4
+property float x
+property float y
+property float z
+property uchar red
+element face 1
+property list uchar int vertex_indices
+end_header
+0 0 0 255
+0 0 1 255
+1 0 1 255
+1 0 0 255
+4 0 1 2 3
+`
+	ml := NewMeshLoaderPLY()
+	if err := ml.Load(strings.NewReader(ascii)); err != nil {
+		t.Fatal(err)
+	}
+	checkMesh(t, ml,
+		[]float32{0, 0, 0, 0, 0, 1, 1, 0, 1, 1, 0, 0},
+		[]int32{0, 1, 2, 0, 2, 3})
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		format := "binary_little_endian"
+		if order == binary.BigEndian {
+			format = "binary_big_endian"
+		}
+		fmt.Fprintf(&buf, "ply\nformat %s 1.0\nelement vertex 4\n"+
+			"property float x\nproperty float y\nproperty float z\n"+
+			"element face 2\nproperty list uchar ushort vertex_index\nend_header\n", format)
+		binary.Write(&buf, order, quadVerts)
+		for i := 0; i < len(quadTris); i += 3 {
+			buf.WriteByte(3)
+			for j := 0; j < 3; j++ {
+				binary.Write(&buf, order, uint16(quadTris[i+j]))
+			}
+		}
+		ml = NewMeshLoaderPLY()
+		if err := ml.Load(&buf); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		checkMesh(t, ml, quadVerts, quadTris)
+	}
+}
+
+func TestMeshLoaderGLTF(t *testing.T) {
+	// one triangle, referenced by 2 nodes
+	var bin bytes.Buffer
+	binary.Write(&bin, binary.LittleEndian, []float32{0, 0, 0, 0, 0, 1, 1, 0, 0})
+	binary.Write(&bin, binary.LittleEndian, []uint16{0, 1, 2})
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(bin.Bytes())
+
+	doc := `{
+  "asset": {"version": "2.0"},
+  "scene": 0,
+  "scenes": [{"nodes": [0]}],
+  "nodes": [
+    {"children": [1, 2], "translation": [10, 0, 0], "extras": {"area": 5}},
+    {"mesh": 0},
+    {"mesh": 0, "scale": [-1, 1, 1], "extras": {"area": 7}}
+  ],
+  "meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1}]}],
+  "accessors": [
+    {"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+    {"bufferView": 1, "componentType": 5123, "count": 3, "type": "SCALAR"}
+  ],
+  "bufferViews": [
+    {"buffer": 0, "byteOffset": 0, "byteLength": 36},
+    {"buffer": 0, "byteOffset": 36, "byteLength": 6}
+  ],
+  "buffers": [{"byteLength": 42, "uri": "` + uri + `"}]
+}`
+
+	ml := NewMeshLoaderGLTF()
+	if err := ml.Load(strings.NewReader(doc)); err != nil {
+		t.Fatal(err)
+	}
+	checkMesh(t, ml,
+		[]float32{10, 0, 0, 10, 0, 1, 11, 0, 0, 10, 0, 0, 10, 0, 1, 9, 0, 0},
+		// the mirrored triangle winding is reversed
+		[]int32{0, 1, 2, 3, 5, 4})
+	for i := 0; i < 2; i++ {
+		if n := ml.Normals()[i*3+1]; n != 1 {
+			t.Errorf("triangle %d normal y = %v, want 1", i, n)
+		}
+	}
+	if areas := ml.Areas(); len(areas) != 2 || areas[0] != 5 || areas[1] != 7 {
+		t.Errorf("areas = %v, want [5 7]", areas)
+	}
+
+	// same document in a glb container, with the buffer in the binary chunk
+	glbDoc := strings.Replace(doc, `, "uri": "`+uri+`"`, "", 1)
+	for len(glbDoc)%4 != 0 {
+		glbDoc += " "
+	}
+	chunk := bin.Bytes()
+	for len(chunk)%4 != 0 {
+		chunk = append(chunk, 0)
+	}
+	var glb bytes.Buffer
+	glb.WriteString("glTF")
+	binary.Write(&glb, binary.LittleEndian, []uint32{2, uint32(12 + 8 + len(glbDoc) + 8 + len(chunk))})
+	binary.Write(&glb, binary.LittleEndian, []uint32{uint32(len(glbDoc)), 0x4E4F534A})
+	glb.WriteString(glbDoc)
+	binary.Write(&glb, binary.LittleEndian, []uint32{uint32(len(chunk)), 0x004E4942})
+	glb.Write(chunk)
+
+	ml = NewMeshLoaderGLTF()
+	if err := ml.Load(&glb); err != nil {
+		t.Fatal(err)
+	}
+	if ml.TriCount() != 2 {
+		t.Fatalf("got %d tris, want 2", ml.TriCount())
+	}
+}
+
+func TestMeshLoaderGLTFInvalidAccessor(t *testing.T) {
+	var bin bytes.Buffer
+	binary.Write(&bin, binary.LittleEndian, []float32{0, 0, 0, 0, 0, 1, 1, 0, 0})
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(bin.Bytes())
+
+	tests := []struct {
+		name       string
+		accessor   string
+		bufferView string
+	}{
+		{"negative count", `"count": -3`, `"byteLength": 36`},
+		{"negative count without buffer view", `"count": -3`, ""},
+		{"no buffer view", `"count": 3`, ""},
+		{"huge count without buffer view", `"count": 4611686018427387904`, ""},
+		{"huge count", `"count": 4611686018427387904`, `"byteLength": 36`},
+		{"negative byte stride", `"count": 3`, `"byteLength": 36, "byteStride": -12`},
+		{"short byte stride", `"count": 3`, `"byteLength": 36, "byteStride": 4`},
+		{"negative byte offset", `"count": 3, "byteOffset": -12`, `"byteLength": 36`},
+	}
+	for _, tt := range tests {
+		accessor, bufferView := `{"componentType": 5126, "type": "VEC3", `+tt.accessor+`}`, `"byteLength": 36`
+		if tt.bufferView != "" {
+			accessor = `{"bufferView": 0, "componentType": 5126, "type": "VEC3", ` + tt.accessor + `}`
+			bufferView = tt.bufferView
+		}
+		doc := `{
+  "asset": {"version": "2.0"},
+  "nodes": [{"mesh": 0}],
+  "meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+  "accessors": [` + accessor + `],
+  "bufferViews": [{"buffer": 0, ` + bufferView + `}],
+  "buffers": [{"byteLength": 36, "uri": "` + uri + `"}]
+}`
+		ml := NewMeshLoaderGLTF()
+		if err := ml.Load(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+	}
+}
+
+func TestMeshLoaderHeightmap(t *testing.T) {
+	samples := []uint16{0, 0xffff, 0, 0x8000, 0, 0, 0, 0, 0}
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, samples)
+
+	img := image.NewGray16(image.Rect(0, 0, 3, 3))
+	for i, s := range samples {
+		img.SetGray16(i%3, i/3, color.Gray16{Y: s})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"raw": raw.Bytes(), "png": buf.Bytes()} {
+		ml := NewMeshLoaderHeightmap()
+		ml.CellSize = 2
+		ml.MaxHeight = 10
+		if err := ml.Load(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if ml.VertCount() != 9 || ml.TriCount() != 8 {
+			t.Fatalf("%s: got %d verts and %d tris, want 9 and 8", name, ml.VertCount(), ml.TriCount())
+		}
+		v := ml.Verts()
+		if v[3] != 2 || v[4] != 10 || v[5] != 0 {
+			t.Errorf("%s: vertex 1 = %v, want [2 10 0]", name, v[3:6])
+		}
+		if v[9] != 0 || math.Abs(float64(v[10]-5)) > 1e-3 || v[11] != 2 {
+			t.Errorf("%s: vertex 3 = %v, want [0 5 2]", name, v[9:12])
+		}
+		for i := int32(0); i < ml.TriCount(); i++ {
+			if ml.Normals()[i*3+1] <= 0 {
+				t.Errorf("%s: triangle %d is facing down", name, i)
+			}
+		}
+	}
+}
+
+func TestNewMeshLoader(t *testing.T) {
+	for _, name := range []string{"a.obj", "a.STL", "a.ply", "a.gltf", "a.glb", "a.png", "a.raw", "a.r16"} {
+		if _, err := NewMeshLoader(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := NewMeshLoader("a.fbx"); err == nil {
+		t.Errorf("a.fbx: want an error")
+	}
+}
+
+func TestInputGeomBatches(t *testing.T) {
+	var geom InputGeom
+	ml := NewMeshLoaderPLY()
+	ml.verts = append(ml.verts, quadVerts...)
+	ml.tris = append(ml.tris, quadTris...)
+	ml.calcNormals()
+	if err := geom.SetMesh(ml); err != nil {
+		t.Fatal(err)
+	}
+
+	// mirrored along x and moved up
+	xform := IdentityTransform
+	xform[0] = -1
+	xform[13] = 3
+	h, err := geom.AddBatch(quadVerts, quadTris[:3], &xform, []uint8{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = geom.AddBatch(quadVerts, []int32{0, 1, 4}, nil, nil); err == nil {
+		t.Fatalf("want an error for out of range vertex index")
+	}
+	if geom.Mesh().TriCount() != 3 || geom.ChunkyMesh().Ntris != 3 {
+		t.Fatalf("got %d tris, want 3", geom.Mesh().TriCount())
+	}
+	if geom.MeshBoundsMin()[0] != -1 || geom.MeshBoundsMax()[1] != 3 {
+		t.Fatalf("bounds = %v %v", geom.MeshBoundsMin(), geom.MeshBoundsMax())
+	}
+
+	// remove the loaded mesh
+	if !geom.RemoveBatch(0) {
+		t.Fatalf("couldn't remove the loaded mesh")
+	}
+	checkMesh(t, geom.Mesh(),
+		[]float32{0, 3, 0, 0, 3, 1, -1, 3, 0, -1, 3, 1},
+		[]int32{0, 2, 1})
+	if n := geom.Mesh().Normals()[1]; n != 1 {
+		t.Fatalf("mirrored triangle normal y = %v, want 1", n)
+	}
+	cm := geom.ChunkyMesh()
+	if cm.Nnodes != 1 || cm.Nodes[0].I != 0 || cm.Nodes[0].N != 1 {
+		t.Fatalf("unexpected chunky mesh nodes %v", cm.Nodes[:cm.Nnodes])
+	}
+	if !geom.RemoveBatch(h) || geom.Mesh().TriCount() != 0 {
+		t.Fatalf("couldn't remove batch %v", h)
+	}
+}
+
+func TestOBJMaterialAreas(t *testing.T) {
+	obj := `v 0 0 0
+v 0 0 1
+v 1 0 0
+v 1 0 1
+f 1 2 3
+usemtl water
+f 3 2 4
+usemtl unknown
+f 1 2 4
+`
+	ml := NewMeshLoaderOBJ()
+	ml.MaterialAreas = map[string]uint8{"water": 1}
+	var geom InputGeom
+	if err := geom.LoadMesh(ml, strings.NewReader(obj)); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{WalkableArea, 1, WalkableArea}
+	if !bytes.Equal(MeshAreas(geom.Mesh()), want) {
+		t.Fatalf("areas = %v, want %v", MeshAreas(geom.Mesh()), want)
+	}
+
+	// the chunky mesh areas follow its triangles
+	cm := geom.ChunkyMesh()
+	tris := geom.Mesh().Tris()
+	for i := int32(0); i < cm.Ntris; i++ {
+		for j := 0; j < 3; j++ {
+			if cm.Tris[i*3] == tris[j*3] && cm.Tris[i*3+1] == tris[j*3+1] && cm.Tris[i*3+2] == tris[j*3+2] {
+				if cm.Areas[i] != want[j] {
+					t.Errorf("chunky triangle %d area = %v, want %v", i, cm.Areas[i], want[j])
+				}
+			}
+		}
+	}
+
+	// without material areas, the mesh has no areas
+	ml = NewMeshLoaderOBJ()
+	if err := geom.LoadMesh(ml, strings.NewReader(obj)); err != nil {
+		t.Fatal(err)
+	}
+	if MeshAreas(geom.Mesh()) != nil || geom.ChunkyMesh().Areas != nil {
+		t.Fatalf("want no areas")
+	}
+}
@@ -0,0 +1,202 @@
+package recast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogLevel is the category of a log entry.
+// see BuildContext
+type LogLevel int
+
+// Recast log categories.
+const (
+	LogProgress LogLevel = 1 + iota // A progress log entry.
+	LogWarning                      // A warning log entry.
+	LogError                        // An error log entry.
+)
+
+// String returns the short name of the log level, as it appears in the text
+// log entries.
+func (l LogLevel) String() string {
+	switch l {
+	case LogProgress:
+		return "PROG"
+	case LogWarning:
+		return "WARN"
+	case LogError:
+		return "ERR"
+	}
+	return "unknown"
+}
+
+// A Field is a key/value pair giving structured information to a log entry,
+// such as the build stage, the tile being built or a count of polygons.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// KV returns the field with the given key and value.
+func KV(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// A LogEntry is an entry of the build log.
+type LogEntry struct {
+	Time   time.Time // The time at which the entry has been written.
+	Level  LogLevel  // The category of the entry.
+	Msg    string    // The message of the entry.
+	Fields []Field   // The structured fields of the entry.
+}
+
+// String returns the text form of the entry: its level, its message then
+// its fields, in key=value form.
+func (e LogEntry) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(e.Level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(e.Msg)
+	for _, f := range e.Fields {
+		fmt.Fprintf(&buf, " %s=%v", f.Key, f.Value)
+	}
+	return buf.String()
+}
+
+// A LogSink receives the log entries of a BuildContext.
+//
+// see BuildContext.AddLogSink
+type LogSink interface {
+	Log(e LogEntry)
+}
+
+// LogSinkFunc is an adapter allowing to use a function as a LogSink.
+type LogSinkFunc func(e LogEntry)
+
+// Log calls f(e).
+func (f LogSinkFunc) Log(e LogEntry) {
+	f(e)
+}
+
+// writerSink writes the log entries to an io.Writer, one per line.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format func(buf *bytes.Buffer, e LogEntry)
+	buf    bytes.Buffer
+}
+
+func (s *writerSink) Log(e LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.format(&s.buf, e)
+	s.buf.WriteByte('\n')
+	s.w.Write(s.buf.Bytes())
+}
+
+// NewTextSink returns a log sink writing the entries to w, in text form, one
+// per line.
+//
+// see LogEntry.String
+func NewTextSink(w io.Writer) LogSink {
+	return &writerSink{
+		w: w,
+		format: func(buf *bytes.Buffer, e LogEntry) {
+			buf.WriteString(e.String())
+		},
+	}
+}
+
+// NewJSONSink returns a log sink writing the entries to w as JSON objects,
+// one per line. The objects have the "time", "level" and "msg" keys, followed
+// by the keys of the entry fields.
+func NewJSONSink(w io.Writer) LogSink {
+	return &writerSink{w: w, format: formatJSON}
+}
+
+var jsonLevels = map[LogLevel]string{
+	LogProgress: "progress",
+	LogWarning:  "warning",
+	LogError:    "error",
+}
+
+func formatJSON(buf *bytes.Buffer, e LogEntry) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	writeKV := func(key string, value interface{}) {
+		enc.Encode(key)
+		buf.Truncate(buf.Len() - 1) // remove the newline added by Encode
+		buf.WriteByte(':')
+		if err := enc.Encode(value); err != nil {
+			enc.Encode(fmt.Sprint(value))
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+
+	buf.WriteByte('{')
+	writeKV("time", e.Time.Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeKV("level", jsonLevels[e.Level])
+	buf.WriteByte(',')
+	writeKV("msg", e.Msg)
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		writeKV(f.Key, f.Value)
+	}
+	buf.WriteByte('}')
+}
+
+// BuildTimes maps the performance timers to their accumulated time.
+//
+// BuildTimes are encoded in JSON as an object which keys are the timer names
+// suffixed with "_ms", and values the times in milliseconds, for example
+// {"rasterize_triangles_ms": 1.25}.
+type BuildTimes map[TimerLabel]time.Duration
+
+// MarshalJSON implements the json.Marshaler interface.
+func (bt BuildTimes) MarshalJSON() ([]byte, error) {
+	labels := make([]int, 0, len(bt))
+	for l := range bt {
+		labels = append(labels, int(l))
+	}
+	sort.Ints(labels)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, l := range labels {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		ms := float64(bt[TimerLabel(l)]) / float64(time.Millisecond)
+		fmt.Fprintf(&buf, "%q:%g", TimerLabel(l).String()+"_ms", ms)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Progress is the progress of a build made of several steps, such as the
+// tiles of a tiled navigation mesh.
+type Progress struct {
+	Stage string // The name of what is being built, such as "tiles".
+	Done  int    // The number of steps done.
+	Total int    // The total number of steps.
+}
+
+// Percent returns the percentage of the steps done.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return 100
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+// ProgressFunc is the type of the functions receiving the build progress.
+//
+// see BuildContext.SetProgressFunc
+type ProgressFunc func(p Progress)
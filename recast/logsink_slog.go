@@ -0,0 +1,41 @@
+//go:build go1.21
+// +build go1.21
+
+package recast
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogSink sends the log entries to a slog.Handler.
+type slogSink struct {
+	h slog.Handler
+}
+
+// NewSlogSink returns a log sink sending the entries to the slog handler h.
+// The progress entries are logged at the info level, the warnings at the warn
+// level and the errors at the error level. The entry fields become the record
+// attributes.
+func NewSlogSink(h slog.Handler) LogSink {
+	return &slogSink{h: h}
+}
+
+func (s *slogSink) Log(e LogEntry) {
+	level := slog.LevelInfo
+	switch e.Level {
+	case LogWarning:
+		level = slog.LevelWarn
+	case LogError:
+		level = slog.LevelError
+	}
+	ctx := context.Background()
+	if !s.h.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(e.Time, level, e.Msg, 0)
+	for _, f := range e.Fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	s.h.Handle(ctx, r)
+}
@@ -367,7 +367,7 @@ func BuildPolyMeshDetail(ctx *BuildContext, mesh *PolyMesh, chf *CompactHeightfi
 	)
 	verts = make([]float32, 256*3)
 
-	bounds := make([]int32, mesh.NPolys*4)
+	bounds := ctx.arena.int32Slice(int(mesh.NPolys * 4))
 	poly := make([]float32, nvp*3)
 
 	// Find max size for a polygon area.
@@ -404,21 +404,24 @@ func BuildPolyMeshDetail(ctx *BuildContext, mesh *PolyMesh, chf *CompactHeightfi
 		maxhh = iMax(maxhh, *ymax-*ymin)
 	}
 
-	hp.data = make([]uint16, maxhw*maxhh)
+	hp.data = ctx.arena.uint16Slice(int(maxhw * maxhh))
 
 	dmesh.NMeshes = mesh.NPolys
 	dmesh.NVerts = 0
 	dmesh.NTris = 0
-	dmesh.Meshes = make([]int32, dmesh.NMeshes*4)
+	dmesh.Meshes = ctx.arena.int32Slice(int(dmesh.NMeshes * 4))
 
 	vcap := nPolyVerts + nPolyVerts/2
 	tcap := vcap * 2
 
 	dmesh.NVerts = 0
-	dmesh.Verts = make([]float32, vcap*3)
-	dmesh.Tris = make([]uint8, tcap*4)
+	dmesh.Verts = ctx.arena.float32Slice(int(vcap * 3))
+	dmesh.Tris = ctx.arena.uint8Slice(int(tcap * 4))
 
 	for i := int32(0); i < mesh.NPolys; i++ {
+		if ctx.canceled() {
+			return nil, false
+		}
 		p := mesh.Polys[i*nvp*2:]
 
 		// Store polygon vertices for processing.
@@ -0,0 +1,620 @@
+package recast
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strings"
+)
+
+// MeshLoaderGLTF loads triangle meshes from glTF 2.0 files, either in JSON
+// (.gltf) or binary (.glb) form.
+//
+// The meshes of the default scene are loaded, transformed by their node
+// transforms. If the file has no scene, the meshes of all the root nodes are
+// loaded. Only the POSITION attribute of the triangle primitives is used.
+//
+// An area id can be given to the triangles of a node, mesh or primitive, with
+// an "area" number in its extras:
+//
+//  "extras": { "area": 10 }
+//
+// The area of a primitive overrides the area of its mesh, which overrides the
+// area of its node, which itself overrides the area of its parent node. The
+// triangles without area are given WalkableArea.
+type MeshLoaderGLTF struct {
+	triMesh
+	areas []uint8
+
+	// ResolveURI opens the external buffers referenced by URI. The data URIs
+	// and the binary chunk of a .glb file are resolved by the loader itself.
+	// If nil, loading a file referencing external buffers fails.
+	ResolveURI func(uri string) (io.ReadCloser, error)
+}
+
+// NewMeshLoaderGLTF returns a new glTF mesh loader.
+func NewMeshLoaderGLTF() *MeshLoaderGLTF {
+	return &MeshLoaderGLTF{}
+}
+
+// Areas returns the area ids of the triangles. [Length: TriCount]
+func (mlg *MeshLoaderGLTF) Areas() []uint8 {
+	return mlg.areas
+}
+
+type gltfDoc struct {
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfNode struct {
+	Children    []int           `json:"children"`
+	Mesh        *int            `json:"mesh"`
+	Matrix      []float64       `json:"matrix"`
+	Translation []float64       `json:"translation"`
+	Rotation    []float64       `json:"rotation"`
+	Scale       []float64       `json:"scale"`
+	Extras      json.RawMessage `json:"extras"`
+}
+
+type gltfMesh struct {
+	Primitives []gltfPrimitive `json:"primitives"`
+	Extras     json.RawMessage `json:"extras"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int  `json:"attributes"`
+	Indices    *int            `json:"indices"`
+	Mode       *int            `json:"mode"`
+	Extras     json.RawMessage `json:"extras"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int   `json:"bufferView"`
+	ByteOffset    int    `json:"byteOffset"`
+	ComponentType int    `json:"componentType"`
+	Count         int    `json:"count"`
+	Type          string `json:"type"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+// glTF accessor component types
+const (
+	gltfUnsignedByte  = 5121
+	gltfUnsignedShort = 5123
+	gltfUnsignedInt   = 5125
+	gltfFloat         = 5126
+)
+
+// glTF primitive modes
+const (
+	gltfTriangles     = 4
+	gltfTriangleStrip = 5
+	gltfTriangleFan   = 6
+)
+
+// gltfMat4 is a column-major 4x4 matrix.
+type gltfMat4 [16]float64
+
+var gltfIdentity = gltfMat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+func (a gltfMat4) mul(b gltfMat4) gltfMat4 {
+	var m gltfMat4
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			var s float64
+			for k := 0; k < 4; k++ {
+				s += a[k*4+r] * b[c*4+k]
+			}
+			m[c*4+r] = s
+		}
+	}
+	return m
+}
+
+// det3 returns the determinant of the upper 3x3 part of m.
+func (m gltfMat4) det3() float64 {
+	return m[0]*(m[5]*m[10]-m[9]*m[6]) -
+		m[4]*(m[1]*m[10]-m[9]*m[2]) +
+		m[8]*(m[1]*m[6]-m[5]*m[2])
+}
+
+// transform returns the node local transform.
+func (n *gltfNode) transform() (gltfMat4, error) {
+	if n.Matrix != nil {
+		if len(n.Matrix) != 16 {
+			return gltfMat4{}, fmt.Errorf("invalid node matrix")
+		}
+		var m gltfMat4
+		copy(m[:], n.Matrix)
+		return m, nil
+	}
+
+	t, r, s := gltfIdentity, gltfIdentity, gltfIdentity
+	if n.Translation != nil {
+		if len(n.Translation) != 3 {
+			return gltfMat4{}, fmt.Errorf("invalid node translation")
+		}
+		copy(t[12:15], n.Translation)
+	}
+	if n.Rotation != nil {
+		if len(n.Rotation) != 4 {
+			return gltfMat4{}, fmt.Errorf("invalid node rotation")
+		}
+		x, y, z, w := n.Rotation[0], n.Rotation[1], n.Rotation[2], n.Rotation[3]
+		r = gltfMat4{
+			1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0,
+			2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0,
+			2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0,
+			0, 0, 0, 1,
+		}
+	}
+	if n.Scale != nil {
+		if len(n.Scale) != 3 {
+			return gltfMat4{}, fmt.Errorf("invalid node scale")
+		}
+		s[0], s[5], s[10] = n.Scale[0], n.Scale[1], n.Scale[2]
+	}
+	return t.mul(r).mul(s), nil
+}
+
+// gltfArea returns the area id found in extras, or def if there is none.
+func gltfArea(extras json.RawMessage, def uint8) (uint8, error) {
+	if len(extras) == 0 {
+		return def, nil
+	}
+	var e struct {
+		Area *int `json:"area"`
+	}
+	// extras may be of any type, only objects can hold an area
+	if err := json.Unmarshal(extras, &e); err != nil || e.Area == nil {
+		return def, nil
+	}
+	if *e.Area < 0 || *e.Area > int(WalkableArea) {
+		return 0, fmt.Errorf("area %d out of range [0, %d]", *e.Area, WalkableArea)
+	}
+	return uint8(*e.Area), nil
+}
+
+// gltfLoader holds the state of a glTF file being loaded.
+type gltfLoader struct {
+	mlg     *MeshLoaderGLTF
+	doc     gltfDoc
+	bin     []byte   // binary chunk of a glb file
+	buffers [][]byte // loaded buffers, nil if not loaded yet
+}
+
+// Load implements the MeshLoader interface.
+func (mlg *MeshLoaderGLTF) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	ld := &gltfLoader{mlg: mlg}
+	js := data
+	if len(data) >= 4 && string(data[:4]) == "glTF" {
+		if js, ld.bin, err = parseGLB(data); err != nil {
+			return err
+		}
+	}
+	if err = json.Unmarshal(js, &ld.doc); err != nil {
+		return fmt.Errorf("gltf: %v", err)
+	}
+	ld.buffers = make([][]byte, len(ld.doc.Buffers))
+
+	mlg.reset()
+	mlg.areas = mlg.areas[:0]
+	if err = ld.load(); err != nil {
+		return fmt.Errorf("gltf: %v", err)
+	}
+	mlg.calcNormals()
+	return nil
+}
+
+// parseGLB returns the JSON and binary chunks of a glb file.
+func parseGLB(data []byte) (js, bin []byte, err error) {
+	if len(data) < 12 {
+		return nil, nil, fmt.Errorf("glb: truncated header")
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != 2 {
+		return nil, nil, fmt.Errorf("glb: unsupported version %d", v)
+	}
+	if l := binary.LittleEndian.Uint32(data[8:]); uint64(l) <= uint64(len(data)) {
+		data = data[:l]
+	}
+	for off := 12; off+8 <= len(data); {
+		l := int(binary.LittleEndian.Uint32(data[off:]))
+		typ := binary.LittleEndian.Uint32(data[off+4:])
+		off += 8
+		if l < 0 || off+l > len(data) {
+			return nil, nil, fmt.Errorf("glb: truncated chunk")
+		}
+		switch typ {
+		case 0x4E4F534A: // JSON
+			if js == nil {
+				js = data[off : off+l]
+			}
+		case 0x004E4942: // BIN
+			if bin == nil {
+				bin = data[off : off+l]
+			}
+		}
+		off += l
+	}
+	if js == nil {
+		return nil, nil, fmt.Errorf("glb: missing JSON chunk")
+	}
+	return js, bin, nil
+}
+
+func (ld *gltfLoader) load() error {
+	doc := &ld.doc
+	if len(doc.Nodes) == 0 {
+		// no node hierarchy, load the meshes as they are
+		for i := range doc.Meshes {
+			if err := ld.loadMesh(i, gltfIdentity, WalkableArea); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var roots []int
+	switch {
+	case doc.Scene != nil:
+		if *doc.Scene < 0 || *doc.Scene >= len(doc.Scenes) {
+			return fmt.Errorf("invalid scene %d", *doc.Scene)
+		}
+		roots = doc.Scenes[*doc.Scene].Nodes
+	case len(doc.Scenes) > 0:
+		roots = doc.Scenes[0].Nodes
+	default:
+		isChild := make([]bool, len(doc.Nodes))
+		for _, n := range doc.Nodes {
+			for _, c := range n.Children {
+				if c >= 0 && c < len(isChild) {
+					isChild[c] = true
+				}
+			}
+		}
+		for i := range doc.Nodes {
+			if !isChild[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	visited := make([]bool, len(doc.Nodes))
+	for _, n := range roots {
+		if err := ld.loadNode(n, gltfIdentity, WalkableArea, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ld *gltfLoader) loadNode(idx int, parent gltfMat4, area uint8, visited []bool) error {
+	if idx < 0 || idx >= len(ld.doc.Nodes) {
+		return fmt.Errorf("invalid node %d", idx)
+	}
+	if visited[idx] {
+		return fmt.Errorf("node %d: cycle in node hierarchy", idx)
+	}
+	visited[idx] = true
+
+	n := &ld.doc.Nodes[idx]
+	local, err := n.transform()
+	if err != nil {
+		return fmt.Errorf("node %d: %v", idx, err)
+	}
+	m := parent.mul(local)
+	if area, err = gltfArea(n.Extras, area); err != nil {
+		return fmt.Errorf("node %d: %v", idx, err)
+	}
+	if n.Mesh != nil {
+		if err := ld.loadMesh(*n.Mesh, m, area); err != nil {
+			return err
+		}
+	}
+	for _, c := range n.Children {
+		if err := ld.loadNode(c, m, area, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ld *gltfLoader) loadMesh(idx int, m gltfMat4, area uint8) error {
+	if idx < 0 || idx >= len(ld.doc.Meshes) {
+		return fmt.Errorf("invalid mesh %d", idx)
+	}
+	mesh := &ld.doc.Meshes[idx]
+	area, err := gltfArea(mesh.Extras, area)
+	if err != nil {
+		return fmt.Errorf("mesh %d: %v", idx, err)
+	}
+	for i := range mesh.Primitives {
+		if err := ld.loadPrimitive(&mesh.Primitives[i], m, area); err != nil {
+			return fmt.Errorf("mesh %d: primitive %d: %v", idx, i, err)
+		}
+	}
+	return nil
+}
+
+func (ld *gltfLoader) loadPrimitive(p *gltfPrimitive, m gltfMat4, area uint8) error {
+	mode := gltfTriangles
+	if p.Mode != nil {
+		mode = *p.Mode
+	}
+	if mode != gltfTriangles && mode != gltfTriangleStrip && mode != gltfTriangleFan {
+		// points and lines have no surface
+		return nil
+	}
+	area, err := gltfArea(p.Extras, area)
+	if err != nil {
+		return err
+	}
+
+	pos, ok := p.Attributes["POSITION"]
+	if !ok {
+		return nil
+	}
+	verts, err := ld.readPositions(pos)
+	if err != nil {
+		return fmt.Errorf("POSITION: %v", err)
+	}
+	nverts := len(verts) / 3
+
+	var indices []int32
+	if p.Indices != nil {
+		if indices, err = ld.readIndices(*p.Indices); err != nil {
+			return fmt.Errorf("indices: %v", err)
+		}
+	} else {
+		indices = make([]int32, nverts)
+		for i := range indices {
+			indices[i] = int32(i)
+		}
+	}
+
+	// add the transformed vertices
+	base := int32(len(ld.mlg.verts) / 3)
+	for i := 0; i < len(verts); i += 3 {
+		x, y, z := verts[i], verts[i+1], verts[i+2]
+		ld.mlg.verts = append(ld.mlg.verts,
+			float32(m[0]*x+m[4]*y+m[8]*z+m[12]),
+			float32(m[1]*x+m[5]*y+m[9]*z+m[13]),
+			float32(m[2]*x+m[6]*y+m[10]*z+m[14]))
+	}
+
+	// a transform with a negative determinant mirrors the triangles, the
+	// winding is then reversed to keep the normals consistent.
+	flip := m.det3() < 0
+	addTri := func(a, b, c int32) {
+		if a < 0 || int(a) >= nverts || b < 0 || int(b) >= nverts || c < 0 || int(c) >= nverts {
+			return
+		}
+		if flip {
+			b, c = c, b
+		}
+		ld.mlg.tris = append(ld.mlg.tris, base+a, base+b, base+c)
+		ld.mlg.areas = append(ld.mlg.areas, area)
+	}
+
+	switch mode {
+	case gltfTriangles:
+		for i := 0; i+2 < len(indices); i += 3 {
+			addTri(indices[i], indices[i+1], indices[i+2])
+		}
+	case gltfTriangleStrip:
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				addTri(indices[i], indices[i+1], indices[i+2])
+			} else {
+				addTri(indices[i], indices[i+2], indices[i+1])
+			}
+		}
+	case gltfTriangleFan:
+		for i := 0; i+2 < len(indices); i++ {
+			addTri(indices[i+1], indices[i+2], indices[0])
+		}
+	}
+	return nil
+}
+
+// accessorData returns the bytes of the elements of an accessor, and the
+// stride between 2 consecutive elements.
+//
+// Accessors without buffer view, which elements are all zeros unless they
+// are sparse, are not supported: their count couldn't be checked against the
+// size of a buffer.
+func (ld *gltfLoader) accessorData(idx, elemSize int) (data []byte, stride int, count int, err error) {
+	if idx < 0 || idx >= len(ld.doc.Accessors) {
+		return nil, 0, 0, fmt.Errorf("invalid accessor %d", idx)
+	}
+	acc := &ld.doc.Accessors[idx]
+	if acc.Count < 0 {
+		return nil, 0, 0, fmt.Errorf("accessor %d has a negative count", idx)
+	}
+	if acc.BufferView == nil {
+		return nil, 0, 0, fmt.Errorf("accessor %d has no buffer view", idx)
+	}
+	if *acc.BufferView < 0 || *acc.BufferView >= len(ld.doc.BufferViews) {
+		return nil, 0, 0, fmt.Errorf("invalid buffer view %d", *acc.BufferView)
+	}
+	bv := &ld.doc.BufferViews[*acc.BufferView]
+	buf, err := ld.buffer(bv.Buffer)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if bv.ByteOffset < 0 || bv.ByteLength < 0 || bv.ByteOffset+bv.ByteLength > len(buf) {
+		return nil, 0, 0, fmt.Errorf("buffer view %d out of buffer bounds", *acc.BufferView)
+	}
+	view := buf[bv.ByteOffset : bv.ByteOffset+bv.ByteLength]
+
+	stride = elemSize
+	if bv.ByteStride != 0 {
+		if bv.ByteStride < elemSize {
+			return nil, 0, 0, fmt.Errorf("invalid byte stride %d in buffer view %d", bv.ByteStride, *acc.BufferView)
+		}
+		stride = bv.ByteStride
+	}
+	if acc.ByteOffset < 0 || acc.ByteOffset > len(view) {
+		return nil, 0, 0, fmt.Errorf("accessor %d out of buffer view bounds", idx)
+	}
+	if acc.Count > 0 {
+		// check the count first, so that the end offset can't overflow.
+		if acc.Count-1 > len(view)/stride {
+			return nil, 0, 0, fmt.Errorf("accessor %d out of buffer view bounds", idx)
+		}
+		end := acc.ByteOffset + (acc.Count-1)*stride + elemSize
+		if end > len(view) {
+			return nil, 0, 0, fmt.Errorf("accessor %d out of buffer view bounds", idx)
+		}
+	}
+	return view[acc.ByteOffset:], stride, acc.Count, nil
+}
+
+// readPositions returns the vertices of a VEC3 float accessor.
+func (ld *gltfLoader) readPositions(idx int) ([]float64, error) {
+	if idx < 0 || idx >= len(ld.doc.Accessors) {
+		return nil, fmt.Errorf("invalid accessor %d", idx)
+	}
+	acc := &ld.doc.Accessors[idx]
+	if acc.Type != "VEC3" || acc.ComponentType != gltfFloat {
+		return nil, fmt.Errorf("unsupported accessor %s/%d", acc.Type, acc.ComponentType)
+	}
+	data, stride, count, err := ld.accessorData(idx, 12)
+	if err != nil {
+		return nil, err
+	}
+	verts := make([]float64, count*3)
+	for i := 0; i < count; i++ {
+		for j := 0; j < 3; j++ {
+			bits := binary.LittleEndian.Uint32(data[i*stride+j*4:])
+			verts[i*3+j] = float64(math.Float32frombits(bits))
+		}
+	}
+	return verts, nil
+}
+
+// readIndices returns the indices of a SCALAR unsigned integer accessor.
+func (ld *gltfLoader) readIndices(idx int) ([]int32, error) {
+	if idx < 0 || idx >= len(ld.doc.Accessors) {
+		return nil, fmt.Errorf("invalid accessor %d", idx)
+	}
+	acc := &ld.doc.Accessors[idx]
+	var size int
+	switch acc.ComponentType {
+	case gltfUnsignedByte:
+		size = 1
+	case gltfUnsignedShort:
+		size = 2
+	case gltfUnsignedInt:
+		size = 4
+	}
+	if acc.Type != "SCALAR" || size == 0 {
+		return nil, fmt.Errorf("unsupported accessor %s/%d", acc.Type, acc.ComponentType)
+	}
+	data, stride, count, err := ld.accessorData(idx, size)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]int32, count)
+	for i := range indices {
+		e := data[i*stride:]
+		switch size {
+		case 1:
+			indices[i] = int32(e[0])
+		case 2:
+			indices[i] = int32(binary.LittleEndian.Uint16(e))
+		case 4:
+			u := binary.LittleEndian.Uint32(e)
+			if u > math.MaxInt32 {
+				return nil, fmt.Errorf("index %d out of range", u)
+			}
+			indices[i] = int32(u)
+		}
+	}
+	return indices, nil
+}
+
+// buffer returns the content of a buffer, loading it if necessary.
+func (ld *gltfLoader) buffer(idx int) ([]byte, error) {
+	if idx < 0 || idx >= len(ld.doc.Buffers) {
+		return nil, fmt.Errorf("invalid buffer %d", idx)
+	}
+	if ld.buffers[idx] != nil {
+		return ld.buffers[idx], nil
+	}
+
+	b := &ld.doc.Buffers[idx]
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case b.URI == "":
+		if idx != 0 || ld.bin == nil {
+			return nil, fmt.Errorf("buffer %d: missing uri", idx)
+		}
+		data = ld.bin
+	case strings.HasPrefix(b.URI, "data:"):
+		i := strings.Index(b.URI, ";base64,")
+		if i < 0 {
+			return nil, fmt.Errorf("buffer %d: unsupported data uri", idx)
+		}
+		if data, err = base64.StdEncoding.DecodeString(b.URI[i+len(";base64,"):]); err != nil {
+			return nil, fmt.Errorf("buffer %d: %v", idx, err)
+		}
+	default:
+		if ld.mlg.ResolveURI == nil {
+			return nil, fmt.Errorf("buffer %d: can't resolve external uri %q", idx, b.URI)
+		}
+		uri, err := url.PathUnescape(b.URI)
+		if err != nil {
+			uri = b.URI
+		}
+		rc, err := ld.mlg.ResolveURI(uri)
+		if err != nil {
+			return nil, fmt.Errorf("buffer %d: %v", idx, err)
+		}
+		data, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("buffer %d: %v", idx, err)
+		}
+	}
+	if len(data) < b.ByteLength {
+		return nil, fmt.Errorf("buffer %d: got %d bytes, want %d", idx, len(data), b.ByteLength)
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	ld.buffers[idx] = data
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package recast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// MeshLoaderHeightmap loads terrain meshes from heightmap images, either PNG
+// images or RAW16 files (unsigned 16-bit little-endian samples, row by row).
+//
+// Each sample becomes a vertex, the samples of the image row y and column x
+// being placed at (x*CellSize, h*MaxHeight, y*CellSize), where h is the
+// sample value normalized to [0, 1].
+type MeshLoaderHeightmap struct {
+	triMesh
+
+	// CellSize is the distance between 2 adjacent samples, on the x and z
+	// axes.
+	CellSize float32
+
+	// MaxHeight is the height of the highest possible sample value.
+	MaxHeight float32
+
+	// Width and Height are the dimensions of a RAW16 heightmap, in samples.
+	// If both are 0, the heightmap is considered square. They are ignored
+	// for PNG images.
+	Width, Height int
+}
+
+// NewMeshLoaderHeightmap returns a new heightmap mesh loader, with a cell
+// size of 1 and a maximum height of 100.
+func NewMeshLoaderHeightmap() *MeshLoaderHeightmap {
+	return &MeshLoaderHeightmap{
+		CellSize:  1,
+		MaxHeight: 100,
+	}
+}
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// Load implements the MeshLoader interface.
+func (mlh *MeshLoaderHeightmap) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w, h    int
+		samples []uint16
+	)
+	if bytes.HasPrefix(data, pngMagic) {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("heightmap: %v", err)
+		}
+		w, h, samples = imageSamples(img)
+	} else {
+		if len(data)%2 != 0 {
+			return fmt.Errorf("heightmap: odd RAW16 file size")
+		}
+		n := len(data) / 2
+		w, h = mlh.Width, mlh.Height
+		if w == 0 && h == 0 {
+			w = int(math.Sqrt(float64(n)))
+			h = w
+		}
+		if w <= 0 || h <= 0 || w*h != n {
+			return fmt.Errorf("heightmap: RAW16 file of %d samples doesn't match %dx%d", n, w, h)
+		}
+		samples = make([]uint16, n)
+		for i := range samples {
+			samples[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+	}
+	if w < 2 || h < 2 {
+		return fmt.Errorf("heightmap: %dx%d is too small", w, h)
+	}
+
+	mlh.reset()
+	mlh.verts = make([]float32, 0, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s := float32(samples[y*w+x]) / math.MaxUint16
+			mlh.verts = append(mlh.verts,
+				float32(x)*mlh.CellSize, s*mlh.MaxHeight, float32(y)*mlh.CellSize)
+		}
+	}
+
+	// 2 triangles per cell, wound so that their normals point up.
+	mlh.tris = make([]int32, 0, (w-1)*(h-1)*6)
+	for y := 0; y < h-1; y++ {
+		for x := 0; x < w-1; x++ {
+			a := int32(y*w + x)
+			b := a + int32(w)
+			c := a + 1
+			d := b + 1
+			mlh.tris = append(mlh.tris, a, b, c, c, b, d)
+		}
+	}
+
+	mlh.calcNormals()
+	return nil
+}
+
+// imageSamples returns the dimensions of img and its 16-bit gray samples.
+func imageSamples(img image.Image) (w, h int, samples []uint16) {
+	bounds := img.Bounds()
+	w, h = bounds.Dx(), bounds.Dy()
+	samples = make([]uint16, 0, w*h)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			g := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+			samples = append(samples, g.Y)
+		}
+	}
+	return w, h, samples
+}
@@ -1,10 +1,13 @@
 package recast
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/arl/gobj"
-	"github.com/arl/math32"
 )
 
 type MeshLoaderOBJ struct {
@@ -12,6 +15,14 @@ type MeshLoaderOBJ struct {
 	verts   []float32
 	tris    []int32
 	normals []float32
+	areas   []uint8
+
+	// MaterialAreas maps OBJ material names, as referenced by usemtl
+	// statements, to the area id given to the triangles of the faces using
+	// them. The triangles of the faces without material, or using a material
+	// not present in the map, are given WalkableArea. If nil, the mesh has no
+	// area ids.
+	MaterialAreas map[string]uint8
 }
 
 func NewMeshLoaderOBJ() *MeshLoaderOBJ {
@@ -28,11 +39,24 @@ func (mlo *MeshLoaderOBJ) Load(r io.Reader) error {
 		obj *gobj.OBJFile
 		err error
 	)
+	var data []byte
+	if mlo.MaterialAreas != nil {
+		// the material of the faces is not provided by the OBJ decoder
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
 	obj, err = gobj.Decode(r)
 	if err != nil {
 		return err
 	}
 
+	var faceAreas []uint8
+	if mlo.MaterialAreas != nil {
+		faceAreas = objFaceAreas(data, mlo.MaterialAreas)
+	}
+
 	// copy vertices indices from OBJ,
 	// multiplying them by the scale factor
 	verts := obj.Verts()
@@ -46,7 +70,12 @@ func (mlo *MeshLoaderOBJ) Load(r io.Reader) error {
 	vertcount := int32(len(verts))
 
 	// add polygons
-	for _, p := range obj.Polys() {
+	mlo.tris = mlo.tris[:0]
+	mlo.areas = nil
+	if faceAreas != nil {
+		mlo.areas = make([]uint8, 0)
+	}
+	for fi, p := range obj.Polys() {
 		for i := 2; i < len(p); i++ {
 			a := p[0]
 			b := p[i-1]
@@ -55,37 +84,42 @@ func (mlo *MeshLoaderOBJ) Load(r io.Reader) error {
 				continue
 			}
 			mlo.tris = append(mlo.tris, a, b, c)
+			if faceAreas != nil {
+				mlo.areas = append(mlo.areas, faceAreas[fi])
+			}
 		}
 	}
 
-	// Calculate normals.
-	// TODO: factor this with recast.calcTriNormal
-	var e0, e1 [3]float32
-	mlo.normals = make([]float32, len(mlo.tris))
-	for i := 0; i < len(mlo.tris); i += 3 {
-		v0 := mlo.verts[mlo.tris[i]*3 : 3+mlo.tris[i]*3]
-		v1 := mlo.verts[mlo.tris[i+1]*3 : 3+mlo.tris[i+1]*3]
-		v2 := mlo.verts[mlo.tris[i+2]*3 : 3+mlo.tris[i+2]*3]
-		for j := 0; j < 3; j++ {
-			e0[j] = v1[j] - v0[j]
-			e1[j] = v2[j] - v0[j]
-		}
-		n := mlo.normals[i : 3+i]
-		n[0] = e0[1]*e1[2] - e0[2]*e1[1]
-		n[1] = e0[2]*e1[0] - e0[0]*e1[2]
-		n[2] = e0[0]*e1[1] - e0[1]*e1[0]
-		d := math32.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
-		if d > 0 {
-			d = 1.0 / d
-			n[0] *= d
-			n[1] *= d
-			n[2] *= d
-		}
-	}
+	mlo.normals = triNormals(mlo.verts, mlo.tris)
 
 	return nil
 }
 
+// objFaceAreas returns the area id of each face of an OBJ file, in the order
+// in which they appear, according to the material they use.
+func objFaceAreas(data []byte, materials map[string]uint8) []uint8 {
+	var areas []uint8
+	area := WalkableArea
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// split the same way as the OBJ decoder, so that the same lines are
+		// considered as faces.
+		line := strings.Split(scanner.Text(), " ")
+		switch line[0] {
+		case "f":
+			areas = append(areas, area)
+		case "usemtl":
+			area = WalkableArea
+			if len(line) > 1 {
+				if a, ok := materials[strings.TrimSpace(strings.Join(line[1:], " "))]; ok {
+					area = a
+				}
+			}
+		}
+	}
+	return areas
+}
+
 func (mlo *MeshLoaderOBJ) Scale() float32 {
 	return mlo.scale
 }
@@ -98,6 +132,12 @@ func (mlo *MeshLoaderOBJ) Tris() []int32 {
 	return mlo.tris
 }
 
+// Areas returns the area ids of the triangles, or nil if MaterialAreas was nil
+// when the mesh was loaded. [Length: TriCount]
+func (mlo *MeshLoaderOBJ) Areas() []uint8 {
+	return mlo.areas
+}
+
 func (mlo *MeshLoaderOBJ) Normals() []float32 {
 	return mlo.normals
 }
@@ -0,0 +1,258 @@
+package recast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MeshLoaderPLY loads triangle meshes from ASCII or binary (little or big
+// endian) PLY files.
+//
+// Only the x, y, z vertex properties and the vertex_indices (or vertex_index)
+// face property are used, the other elements and properties are skipped.
+// Faces with more than 3 vertices are triangulated as fans.
+type MeshLoaderPLY struct {
+	triMesh
+}
+
+// NewMeshLoaderPLY returns a new PLY mesh loader.
+func NewMeshLoaderPLY() *MeshLoaderPLY {
+	return &MeshLoaderPLY{}
+}
+
+type plyProperty struct {
+	name      string
+	typ       string // scalar type, or list item type
+	countType string // list count type, empty for scalars
+}
+
+type plyElement struct {
+	name  string
+	count int
+	props []plyProperty
+}
+
+// plyReader reads the scalar values of a PLY body.
+type plyReader interface {
+	read(typ string) (float64, error)
+}
+
+type plyASCIIReader struct {
+	s *bufio.Scanner
+}
+
+func (r *plyASCIIReader) read(typ string) (float64, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseFloat(r.s.Text(), 64)
+}
+
+type plyBinaryReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (r *plyBinaryReader) read(typ string) (float64, error) {
+	n := plyTypeSize(typ)
+	if n == 0 {
+		return 0, fmt.Errorf("ply: unknown property type %q", typ)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return 0, err
+	}
+	switch typ {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(r.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(r.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(r.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(r.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(r.order.Uint32(b))), nil
+	default: // "double", "float64"
+		return math.Float64frombits(r.order.Uint64(b)), nil
+	}
+}
+
+// plyTypeSize returns the size in bytes of a PLY scalar type, or 0 if typ is
+// not a valid type.
+func plyTypeSize(typ string) int {
+	switch typ {
+	case "char", "int8", "uchar", "uint8":
+		return 1
+	case "short", "int16", "ushort", "uint16":
+		return 2
+	case "int", "int32", "uint", "uint32", "float", "float32":
+		return 4
+	case "double", "float64":
+		return 8
+	}
+	return 0
+}
+
+// Load implements the MeshLoader interface.
+func (mlp *MeshLoaderPLY) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	format, elems, err := readPLYHeader(br)
+	if err != nil {
+		return err
+	}
+
+	var pr plyReader
+	switch format {
+	case "ascii":
+		s := bufio.NewScanner(br)
+		s.Split(bufio.ScanWords)
+		pr = &plyASCIIReader{s: s}
+	case "binary_little_endian":
+		pr = &plyBinaryReader{r: br, order: binary.LittleEndian}
+	case "binary_big_endian":
+		pr = &plyBinaryReader{r: br, order: binary.BigEndian}
+	default:
+		return fmt.Errorf("ply: unsupported format %q", format)
+	}
+
+	mlp.reset()
+	var idx []int32
+	for _, e := range elems {
+		for i := 0; i < e.count; i++ {
+			var v [3]float32
+			for _, p := range e.props {
+				if p.countType == "" {
+					f, err := pr.read(p.typ)
+					if err != nil {
+						return fmt.Errorf("ply: element %s: %v", e.name, err)
+					}
+					if e.name == "vertex" {
+						switch p.name {
+						case "x":
+							v[0] = float32(f)
+						case "y":
+							v[1] = float32(f)
+						case "z":
+							v[2] = float32(f)
+						}
+					}
+					continue
+				}
+
+				n, err := pr.read(p.countType)
+				if err != nil {
+					return fmt.Errorf("ply: element %s: %v", e.name, err)
+				}
+				idx = idx[:0]
+				for j := 0; j < int(n); j++ {
+					f, err := pr.read(p.typ)
+					if err != nil {
+						return fmt.Errorf("ply: element %s: %v", e.name, err)
+					}
+					idx = append(idx, int32(f))
+				}
+				if e.name == "face" && (p.name == "vertex_indices" || p.name == "vertex_index") {
+					for j := 2; j < len(idx); j++ {
+						mlp.tris = append(mlp.tris, idx[0], idx[j-1], idx[j])
+					}
+				}
+			}
+			if e.name == "vertex" {
+				mlp.verts = append(mlp.verts, v[0], v[1], v[2])
+			}
+		}
+	}
+
+	// drop the faces referencing missing vertices
+	nverts := mlp.VertCount()
+	tris := mlp.tris[:0]
+	for i := 0; i < len(mlp.tris); i += 3 {
+		a, b, c := mlp.tris[i], mlp.tris[i+1], mlp.tris[i+2]
+		if a < 0 || a >= nverts || b < 0 || b >= nverts || c < 0 || c >= nverts {
+			continue
+		}
+		tris = append(tris, a, b, c)
+	}
+	mlp.tris = tris
+
+	mlp.calcNormals()
+	return nil
+}
+
+// readPLYHeader reads the header of a PLY file, up to and including the
+// end_header line.
+func readPLYHeader(br *bufio.Reader) (string, []plyElement, error) {
+	var (
+		format string
+		elems  []plyElement
+	)
+	for nline := 1; ; nline++ {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("ply: reading header: %v", err)
+		}
+		fields := strings.Fields(line)
+		if nline == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return "", nil, fmt.Errorf("ply: not a PLY file")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("ply: line %d: malformed format", nline)
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("ply: line %d: malformed element", nline)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, fmt.Errorf("ply: line %d: invalid element count", nline)
+			}
+			elems = append(elems, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elems) == 0 {
+				return "", nil, fmt.Errorf("ply: line %d: property outside of an element", nline)
+			}
+			var p plyProperty
+			switch {
+			case len(fields) == 5 && fields[1] == "list":
+				p = plyProperty{countType: fields[2], typ: fields[3], name: fields[4]}
+				if plyTypeSize(p.countType) == 0 {
+					return "", nil, fmt.Errorf("ply: line %d: unknown type %q", nline, p.countType)
+				}
+			case len(fields) == 3:
+				p = plyProperty{typ: fields[1], name: fields[2]}
+			default:
+				return "", nil, fmt.Errorf("ply: line %d: malformed property", nline)
+			}
+			if plyTypeSize(p.typ) == 0 {
+				return "", nil, fmt.Errorf("ply: line %d: unknown type %q", nline, p.typ)
+			}
+			e := &elems[len(elems)-1]
+			e.props = append(e.props, p)
+		case "end_header":
+			return format, elems, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package recast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MeshLoaderSTL loads triangle meshes from binary or ASCII STL files.
+//
+// STL triangles are not indexed, the identical vertices are merged during
+// loading. The normals stored in the file are ignored and recomputed from the
+// triangle vertices.
+type MeshLoaderSTL struct {
+	triMesh
+}
+
+// NewMeshLoaderSTL returns a new STL mesh loader.
+func NewMeshLoaderSTL() *MeshLoaderSTL {
+	return &MeshLoaderSTL{}
+}
+
+// Load implements the MeshLoader interface.
+func (mls *MeshLoaderSTL) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	mls.reset()
+	w := newVertexWelder(&mls.triMesh)
+
+	// an ASCII STL file may start with "solid" as well as a binary one, the
+	// size of the binary file is thus used to detect its format.
+	if len(data) >= 84 {
+		ntris := binary.LittleEndian.Uint32(data[80:84])
+		if uint64(len(data)) == 84+50*uint64(ntris) {
+			mls.loadBinary(w, data[84:], int(ntris))
+			mls.calcNormals()
+			return nil
+		}
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("solid")) {
+		return fmt.Errorf("stl: unrecognized file format")
+	}
+	if err := mls.loadASCII(w, data); err != nil {
+		return err
+	}
+	mls.calcNormals()
+	return nil
+}
+
+func (mls *MeshLoaderSTL) loadBinary(w *vertexWelder, data []byte, ntris int) {
+	var v [3]float32
+	for i := 0; i < ntris; i++ {
+		// skip the normal
+		tri := data[i*50+12:]
+		var idx [3]int32
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				v[k] = math.Float32frombits(binary.LittleEndian.Uint32(tri[j*12+k*4:]))
+			}
+			idx[j] = w.add(v)
+		}
+		mls.tris = append(mls.tris, idx[0], idx[1], idx[2])
+	}
+}
+
+func (mls *MeshLoaderSTL) loadASCII(w *vertexWelder, data []byte) error {
+	var (
+		idx  []int32
+		line int
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "vertex":
+			if len(fields) != 4 {
+				return fmt.Errorf("stl: line %d: malformed vertex", line)
+			}
+			var v [3]float32
+			for k := 0; k < 3; k++ {
+				f, err := strconv.ParseFloat(fields[k+1], 32)
+				if err != nil {
+					return fmt.Errorf("stl: line %d: %v", line, err)
+				}
+				v[k] = float32(f)
+			}
+			idx = append(idx, w.add(v))
+		case "endloop":
+			// facets are triangles, but accept convex polygons
+			for i := 2; i < len(idx); i++ {
+				mls.tris = append(mls.tris, idx[0], idx[i-1], idx[i])
+			}
+			idx = idx[:0]
+		}
+	}
+	return scanner.Err()
+}
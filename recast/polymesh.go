@@ -85,10 +85,10 @@ func BuildPolyMesh(ctx *BuildContext, cset *ContourSet, nvp int32) (*PolyMesh, b
 		Ch:           cset.Ch,
 		BorderSize:   cset.BorderSize,
 		MaxEdgeError: cset.MaxError,
-		Verts:        make([]uint16, maxVertices*3),
-		Polys:        make([]uint16, maxTris*nvp*2),
-		Regs:         make([]uint16, maxTris),
-		Areas:        make([]uint8, maxTris),
+		Verts:        ctx.arena.uint16Slice(int(maxVertices * 3)),
+		Polys:        ctx.arena.uint16Slice(int(maxTris * nvp * 2)),
+		Regs:         ctx.arena.uint16Slice(int(maxTris)),
+		Areas:        ctx.arena.uint8Slice(int(maxTris)),
 		NVerts:       0,
 		NPolys:       0,
 		Nvp:          nvp,
@@ -98,14 +98,14 @@ func BuildPolyMesh(ctx *BuildContext, cset *ContourSet, nvp int32) (*PolyMesh, b
 	copy(mesh.BMin[:], cset.BMin[:])
 	copy(mesh.BMax[:], cset.BMax[:])
 
-	vflags := make([]uint8, maxVertices)
+	vflags := ctx.arena.uint8Slice(int(maxVertices))
 
 	for i := range mesh.Polys {
 		mesh.Polys[i] = 0xffff
 	}
 
-	nextVert := make([]int32, maxVertices)
-	firstVert := make([]int32, VERTEX_BUCKET_COUNT)
+	nextVert := ctx.arena.int32Slice(int(maxVertices))
+	firstVert := ctx.arena.int32Slice(int(VERTEX_BUCKET_COUNT))
 
 	for i := range firstVert {
 		firstVert[i] = -1
@@ -117,6 +117,9 @@ func BuildPolyMesh(ctx *BuildContext, cset *ContourSet, nvp int32) (*PolyMesh, b
 	tmpPoly := polys[maxVertsPerCont*nvp:]
 
 	for i := int32(0); i < cset.NConts; i++ {
+		if ctx.canceled() {
+			return nil, false
+		}
 		cont := &cset.Conts[i]
 
 		// Skip null contours.
@@ -299,7 +302,7 @@ func BuildPolyMesh(ctx *BuildContext, cset *ContourSet, nvp int32) (*PolyMesh, b
 	}
 
 	// Just allocate the mesh flags array. The user is resposible to fill it.
-	mesh.Flags = make([]uint16, mesh.NPolys)
+	mesh.Flags = ctx.arena.uint16Slice(int(mesh.NPolys))
 	if mesh.NVerts > 0xffff {
 		ctx.Errorf("BuildPolyMesh: The resulting mesh has too many vertices %d (max %d). Data can be corrupted.", mesh.NVerts, 0xffff)
 	}
@@ -72,6 +72,9 @@ func RasterizeTriangles(ctx *BuildContext, verts []float32, nv int32,
 	ich := 1.0 / solid.Ch
 	// Rasterize triangles.
 	for i := int32(0); i < nt; i++ {
+		if i%1024 == 0 && ctx.canceled() {
+			return false
+		}
 		v0 := verts[tris[i*3+0]*3:]
 		v1 := verts[tris[i*3+1]*3:]
 		v2 := verts[tris[i*3+2]*3:]
@@ -114,6 +117,9 @@ func RasterizeTriangles2(ctx *BuildContext, verts []float32, areas []uint8, nt i
 	ich := float32(1.0 / solid.Ch)
 	// Rasterize triangles.
 	for i := int32(0); i < nt; i++ {
+		if i%1024 == 0 && ctx.canceled() {
+			return false
+		}
 		v0 := verts[(i*3+0)*3:]
 		v1 := verts[(i*3+1)*3:]
 		v2 := verts[(i*3+2)*3:]
@@ -65,6 +65,9 @@ func calcTriNormal(v0, v1, v2, norm d3.Vec3) {
 // Only sets the area id's for the walkable triangles. Does not alter the area
 // id's for unwalkable triangles.
 //
+// To keep the area id's defined by the input mesh (see MeshAreas), copy them
+// into areas and use ClearUnwalkableTriangles instead.
+//
 // See the cConfig documentation for more information on the configuration
 // parameters.
 //
@@ -189,6 +192,54 @@ const (
 	maxTimers
 )
 
+var timerNames = [maxTimers]string{
+	TimerTotal:                   "total",
+	TimerTemp:                    "temp",
+	TimerRasterizeTriangles:      "rasterize_triangles",
+	TimerBuildCompactHeightfield: "build_compact_heightfield",
+	TimerBuildContours:           "build_contours",
+	TimerBuildContoursTrace:      "build_contours_trace",
+	TimerBuildContoursSimplify:   "build_contours_simplify",
+	TimerFilterBorder:            "filter_border",
+	TimerFilterWalkable:          "filter_walkable",
+	TimerMedianArea:              "median_area",
+	TimerFilterLowObstacles:      "filter_low_obstacles",
+	TimerBuildPolymesh:           "build_polymesh",
+	TimerMergePolymesh:           "merge_polymesh",
+	TimerErodeArea:               "erode_area",
+	TimerMarkBoxArea:             "mark_box_area",
+	TimerMarkCylinderArea:        "mark_cylinder_area",
+	TimerMarkConvexPolyArea:      "mark_convex_poly_area",
+	TimerBuildDistanceField:      "build_distance_field",
+	TimerBuildDistanceFieldDist:  "build_distance_field_dist",
+	TimerBuildDistanceFieldBlur:  "build_distance_field_blur",
+	TimerBuildRegions:            "build_regions",
+	TimerBuildRegionsWatershed:   "build_regions_watershed",
+	TimerBuildRegionsExpand:      "build_regions_expand",
+	TimerBuildRegionsFlood:       "build_regions_flood",
+	TimerBuildRegionsFilter:      "build_regions_filter",
+	TimerBuildLayers:             "build_layers",
+	TimerBuildPolyMeshDetail:     "build_polymesh_detail",
+	TimerMergePolyMeshDetail:     "merge_polymesh_detail",
+}
+
+// String returns the name of the timer label, in snake case.
+func (tl TimerLabel) String() string {
+	if tl < 0 || tl >= maxTimers {
+		return "unknown"
+	}
+	return timerNames[tl]
+}
+
+// TimerLabels returns all the performance timer labels, in declaration order.
+func TimerLabels() []TimerLabel {
+	labels := make([]TimerLabel, maxTimers)
+	for i := range labels {
+		labels[i] = TimerLabel(i)
+	}
+	return labels
+}
+
 var (
 	xOffset, yOffset [4]int32
 	dirOffset        [5]int32
@@ -1,7 +1,11 @@
 package recast
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/arl/math32"
 )
@@ -412,6 +416,44 @@ func TestAddSpan(t *testing.T) {
 	})
 }
 
+func TestCloneHeightfield(t *testing.T) {
+	var bmin, bmax [3]float32
+	bmax = [3]float32{3, 3, 3}
+	hf := NewHeightfield(2, 2, bmin[:], bmax[:], 1.5, 2)
+	hf.addSpan(0, 0, 0, 1, 42, 1)
+	hf.addSpan(0, 0, 3, 4, 43, 1)
+	hf.addSpan(1, 1, 2, 5, 44, 1)
+
+	clone := hf.Clone()
+
+	// modifying the original heightfield should not modify the clone
+	hf.Spans[0].area = 0
+	hf.addSpan(1, 0, 0, 1, 45, 1)
+
+	want := [][][3]int{
+		{{0, 1, 42}, {3, 4, 43}},
+		nil,
+		nil,
+		{{2, 5, 44}},
+	}
+	for i, col := range want {
+		s := clone.Spans[i]
+		for j, ws := range col {
+			if s == nil {
+				t.Fatalf("column %d: want %d spans, got %d", i, len(col), j)
+			}
+			got := [3]int{int(s.smin), int(s.smax), int(s.area)}
+			if got != ws {
+				t.Errorf("column %d, span %d: want %v, got %v", i, j, ws, got)
+			}
+			s = s.next
+		}
+		if s != nil {
+			t.Errorf("column %d: got more than %d spans", i, len(col))
+		}
+	}
+}
+
 func TestRasterizeTriangle(t *testing.T) {
 	var ctx BuildContext
 	verts := []float32{
@@ -636,3 +678,257 @@ func TestRasterizeTriangles(t *testing.T) {
 	require(t, solid.Spans[1+2*w].area == 2, "solid.Spans[1 + 2 * w].area == 2")
 	require(t, solid.Spans[1+2*w].next == nil, "!solid.Spans[1 + 2 * w].next")
 }
+
+func TestClipHeightfield(t *testing.T) {
+	var ctx BuildContext
+	var bmin, bmax [3]float32
+	bmax = [3]float32{3, 10, 3}
+	hf := NewHeightfield(2, 2, bmin[:], bmax[:], 1.5, 2)
+	hf.addSpan(0, 0, 0, 1, 42, 1)
+	hf.addSpan(0, 0, 3, 4, 43, 1)
+	hf.addSpan(1, 0, 0, 1, 44, 1)
+	hf.addSpan(1, 1, 2, 5, 45, 1)
+
+	include := []BuildBox{{Min: [3]float32{0, 0, 0}, Max: [3]float32{3, 9, 3}}}
+	exclude := []BuildBox{{Min: [3]float32{0, 0, 0}, Max: [3]float32{1, 3, 1}}}
+	ClipHeightfield(&ctx, include, exclude, hf)
+
+	want := [][][3]int{
+		{{3, 4, 43}}, // span top at 2 is in the exclude box
+		{{0, 1, 44}},
+		nil,
+		nil, // span top at 10 is outside the include box
+	}
+	for i, col := range want {
+		s := hf.Spans[i]
+		for j, ws := range col {
+			if s == nil {
+				t.Fatalf("column %d: want %d spans, got %d", i, len(col), j)
+			}
+			got := [3]int{int(s.smin), int(s.smax), int(s.area)}
+			if got != ws {
+				t.Errorf("column %d, span %d: want %v, got %v", i, j, ws, got)
+			}
+			s = s.next
+		}
+		if s != nil {
+			t.Errorf("column %d: got more than %d spans", i, len(col))
+		}
+	}
+}
+
+func TestClipBounds(t *testing.T) {
+	tests := []struct {
+		box              *BuildBox
+		include          []BuildBox
+		wantMin, wantMax [3]float32
+	}{
+		{nil, nil, [3]float32{0, 0, 0}, [3]float32{10, 10, 10}},
+		{
+			&BuildBox{Min: [3]float32{-1, 2, 3}, Max: [3]float32{5, 12, 8}}, nil,
+			[3]float32{0, 2, 3}, [3]float32{5, 10, 8},
+		},
+		{
+			nil,
+			[]BuildBox{
+				{Min: [3]float32{1, 1, 1}, Max: [3]float32{2, 2, 2}},
+				{Min: [3]float32{3, 3, 3}, Max: [3]float32{4, 4, 4}},
+			},
+			[3]float32{1, 1, 1}, [3]float32{4, 4, 4},
+		},
+		{
+			&BuildBox{Min: [3]float32{0, 0, 0}, Max: [3]float32{1, 1, 1}},
+			[]BuildBox{{Min: [3]float32{3, 3, 3}, Max: [3]float32{4, 4, 4}}},
+			[3]float32{3, 3, 3}, [3]float32{3, 3, 3},
+		},
+	}
+
+	for i, tt := range tests {
+		bmin, bmax := [3]float32{0, 0, 0}, [3]float32{10, 10, 10}
+		clipBounds(bmin[:], bmax[:], tt.box, tt.include)
+		if bmin != tt.wantMin || bmax != tt.wantMax {
+			t.Errorf("test %d: got bounds %v %v, want %v %v", i, bmin, bmax, tt.wantMin, tt.wantMax)
+		}
+	}
+}
+
+func TestBuildContextSinks(t *testing.T) {
+	ctx := NewBuildContext(true)
+	var text, js bytes.Buffer
+	var entries []LogEntry
+	ctx.AddLogSink(NewTextSink(&text))
+	ctx.AddLogSink(NewJSONSink(&js))
+	ctx.AddLogSink(LogSinkFunc(func(e LogEntry) { entries = append(entries, e) }))
+
+	ctx.SetField("tile_x", 1)
+	ctx.SetField("tile_y", 2)
+	ctx.Progressf("building <%d>", 3)
+	ctx.RemoveField("tile_x")
+	ctx.Log(LogWarning, "polymesh", KV("polys", 42))
+
+	if got, want := text.String(), "PROG building <3> tile_x=1 tile_y=2\nWARN polymesh tile_y=2 polys=42\n"; got != want {
+		t.Errorf("text sink wrote %q, want %q", got, want)
+	}
+	// the stored log entries don't have the fields of the build context
+	if got, want := ctx.LogText(1), "WARN polymesh polys=42"; got != want {
+		t.Errorf("log entry 1 is %q, want %q", got, want)
+	}
+	if len(entries) != 2 || entries[1].Level != LogWarning || len(entries[1].Fields) != 2 || entries[1].Time.IsZero() {
+		t.Errorf("callback sink received unexpected entries %+v", entries)
+	}
+
+	lines := strings.Split(strings.TrimSpace(js.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("JSON sink wrote %d lines, want 2", len(lines))
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &obj); err != nil {
+		t.Fatalf("JSON sink wrote invalid JSON %q: %v", lines[0], err)
+	}
+	if obj["level"] != "progress" || obj["msg"] != "building <3>" || obj["tile_x"] != 1.0 || obj["tile_y"] != 2.0 {
+		t.Errorf("JSON sink wrote %q", lines[0])
+	}
+
+	// no entries are sent while logging is disabled
+	ctx.EnableLog(false)
+	ctx.Errorf("disabled")
+	if len(entries) != 2 {
+		t.Errorf("log sinks received entries while logging is disabled")
+	}
+
+	var progress []Progress
+	ctx.SetProgressFunc(func(p Progress) { progress = append(progress, p) })
+	ctx.ReportProgress("tiles", 1, 4)
+	if len(progress) != 1 || progress[0].Percent() != 25 {
+		t.Errorf("got progress %v, want 25%% of the tiles", progress)
+	}
+}
+
+func TestBuildTimesJSON(t *testing.T) {
+	bt := BuildTimes{
+		TimerBuildContours:      2 * time.Millisecond,
+		TimerRasterizeTriangles: 1500 * time.Microsecond,
+	}
+	buf, err := json.Marshal(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), `{"rasterize_triangles_ms":1.5,"build_contours_ms":2}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestArena(t *testing.T) {
+	var (
+		ctx   BuildContext
+		arena Arena
+	)
+	ctx.SetArena(&arena)
+	var bmin, bmax [3]float32
+	bmax = [3]float32{3, 3, 3}
+
+	build := func() *CompactHeightfield {
+		hf := ctx.Arena().NewHeightfield(2, 2, bmin[:], bmax[:], 1.5, 2)
+		hf.addSpan(0, 0, 0, 1, 42, 1)
+		hf.addSpan(1, 1, 2, 5, 44, 1)
+		var chf CompactHeightfield
+		require(t, BuildCompactHeightfield(&ctx, 1, 1, hf, &chf), "BuildCompactHeightfield")
+		return &chf
+	}
+
+	// the first build doesn't fit in the empty arena.
+	chf := build()
+	arena.Reset()
+	chf = build()
+	cells, areas := &chf.Cells[0], &chf.Areas[0]
+	require(t, chf.SpanCount == 2 && chf.Areas[0] == 42 && chf.Areas[1] == 44, "compact heightfield built from the arena")
+
+	// after a reset, the same memory is reused, and cleared.
+	chf.Cells[3].Count = 7
+	arena.Reset()
+	chf = build()
+	require(t, &chf.Cells[0] == cells && &chf.Areas[0] == areas, "arena memory should be reused")
+	require(t, chf.Cells[3].Count == 1, "reused memory should be cleared")
+
+	// appending to an arena slice doesn't overwrite the next slices.
+	s1 := arena.int32Slice(2)
+	s2 := arena.int32Slice(2)
+	s1 = append(s1, 1)
+	require(t, s2[0] == 0, "appending to an arena slice overwrote the next one")
+
+	// a nil arena allocates normally.
+	var nilArena *Arena
+	require(t, len(nilArena.uint16Slice(4)) == 4, "nil arena allocation")
+}
+
+func TestBuildSettingsValidate(t *testing.T) {
+	valid := BuildSettings{
+		CellSize:             0.3,
+		CellHeight:           0.2,
+		AgentHeight:          2,
+		AgentRadius:          0.6,
+		AgentMaxClimb:        0.9,
+		AgentMaxSlope:        45,
+		RegionMinSize:        8,
+		RegionMergeSize:      20,
+		EdgeMaxLen:           12,
+		EdgeMaxError:         1.3,
+		VertsPerPoly:         6,
+		DetailSampleDist:     6,
+		DetailSampleMaxError: 1,
+		Areas:                []AreaDefinition{{Name: "ground", ID: 0, Flags: 1, Cost: 1}},
+	}
+	warnings, err := valid.Validate()
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("got warnings %v and error %v, want none", warnings, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *BuildSettings)
+		field  string // field of the error or, if warn, of the warning
+		warn   bool
+	}{
+		{"zero cell size", func(s *BuildSettings) { s.CellSize = 0 }, "cellsize", false},
+		{"too many verts", func(s *BuildSettings) { s.VertsPerPoly = 7 }, "vertsperpoly", false},
+		{"negative tile size", func(s *BuildSettings) { s.TileSize = -1 }, "tilesize", false},
+		{"low agent", func(s *BuildSettings) { s.AgentHeight = 0.3 }, "agentheight", false},
+		{"vertical slope", func(s *BuildSettings) { s.AgentMaxSlope = 90 }, "agentmaxslope", false},
+		{"area id", func(s *BuildSettings) { s.Areas[0].ID = 64 }, "areas[0]", false},
+		{"material area id", func(s *BuildSettings) { s.MaterialAreas = map[string]uint8{"road": 100} }, "materialareas", false},
+		{"empty box", func(s *BuildSettings) { s.ExcludeBoxes = []BuildBox{{Min: [3]float32{1, 0, 0}}} }, "excludeboxes[0]", false},
+		{"unnamed profile", func(s *BuildSettings) { s.AgentProfiles = []AgentProfile{{Height: 2, Radius: 0.6}} }, "agentprofiles[0]", false},
+		{"profile path", func(s *BuildSettings) { s.AgentProfiles = []AgentProfile{{Name: "../x", Height: 2, Radius: 0.6}} }, "agentprofiles[0]", false},
+		{"coarse cells", func(s *BuildSettings) { s.CellSize = 1 }, "cellsize", true},
+		{"high cells", func(s *BuildSettings) { s.CellHeight = 0.5; s.AgentMaxClimb = 0.4 }, "cellheight", true},
+		{"no detail", func(s *BuildSettings) { s.DetailSampleDist = 0.5 }, "detailsampledist", true},
+	}
+	for _, tt := range tests {
+		s := valid
+		s.Areas = append([]AreaDefinition(nil), valid.Areas...)
+		tt.modify(&s)
+		warnings, err := s.Validate()
+
+		var issues []SettingsIssue
+		if tt.warn {
+			if err != nil {
+				t.Errorf("%s: got error %v, want none", tt.name, err)
+			}
+			issues = warnings
+		} else {
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Errorf("%s: got error %v, want a *ValidationError", tt.name, err)
+				continue
+			}
+			issues = verr.Issues
+		}
+		found := false
+		for _, is := range issues {
+			found = found || is.Field == tt.field
+		}
+		if !found {
+			t.Errorf("%s: got issues %v, want one for %q", tt.name, issues, tt.field)
+		}
+	}
+}
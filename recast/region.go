@@ -46,7 +46,7 @@ func BuildRegionsMonotone(ctx *BuildContext, chf *CompactHeightfield,
 	h := chf.Height
 	id := uint16(1)
 
-	srcReg := make([]uint16, chf.SpanCount)
+	srcReg := ctx.arena.uint16Slice(int(chf.SpanCount))
 	nsweeps := iMax(chf.Width, chf.Height)
 	sweeps := make([]sweepSpan, nsweeps)
 
@@ -72,6 +72,9 @@ func BuildRegionsMonotone(ctx *BuildContext, chf *CompactHeightfield,
 
 	// Sweep one line at a time.
 	for y := borderSize; y < h-borderSize; y++ {
+		if ctx.canceled() {
+			return false
+		}
 		// Collect spans from this row.
 		prev = make([]int32, id+1)
 		//memset(&prev[0],0,sizeof(int)*id);
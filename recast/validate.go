@@ -0,0 +1,200 @@
+package recast
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/arl/math32"
+)
+
+// maxVertsPerPoly is the maximum number of vertices per polygon, imposed by
+// Detour navigation meshes.
+const maxVertsPerPoly = 6
+
+// A SettingsIssue is a problem found in build settings by
+// BuildSettings.Validate.
+type SettingsIssue struct {
+	Field string // Name of the setting, as it appears in YAML.
+	Msg   string // Description of the problem.
+}
+
+func (i SettingsIssue) String() string {
+	return i.Field + ": " + i.Msg
+}
+
+// A ValidationError is returned by BuildSettings.Validate when the settings
+// can't be used to build a navigation mesh.
+type ValidationError struct {
+	Issues []SettingsIssue
+}
+
+func (e *ValidationError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid build settings")
+	for i, is := range e.Issues {
+		if i == 0 {
+			buf.WriteString(": ")
+		} else {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(is.String())
+	}
+	return buf.String()
+}
+
+// Validate checks the build settings.
+//
+//  Returns:
+//   warnings  The suspicious settings, which produce navigation meshes
+//             that are probably not the ones expected, for example a cell
+//             size greater than the agent radius.
+//   err       A *ValidationError listing the settings which are out of
+//             range, or nil.
+//
+// The ranges are those of the corresponding Config values, once the
+// settings are converted to voxel units.
+//
+// see Config
+func (s BuildSettings) Validate() (warnings []SettingsIssue, err error) {
+	var errs []SettingsIssue
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, SettingsIssue{field, fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...interface{}) {
+		warnings = append(warnings, SettingsIssue{field, fmt.Sprintf(format, args...)})
+	}
+
+	if s.CellSize <= 0 {
+		fail("cellsize", "must be > 0, got %v", s.CellSize)
+	}
+	if s.CellHeight <= 0 {
+		fail("cellheight", "must be > 0, got %v", s.CellHeight)
+	}
+	s.validateAgent(fail, warn, "agent", s.AgentHeight, s.AgentRadius, s.AgentMaxClimb, s.AgentMaxSlope)
+
+	if s.RegionMinSize < 0 {
+		fail("regionminsize", "must be >= 0, got %v", s.RegionMinSize)
+	}
+	if s.RegionMergeSize < 0 {
+		fail("regionmergesize", "must be >= 0, got %v", s.RegionMergeSize)
+	}
+	if s.EdgeMaxLen < 0 {
+		fail("edgemaxlen", "must be >= 0, got %v", s.EdgeMaxLen)
+	}
+	if s.EdgeMaxError < 0 {
+		fail("edgemaxerror", "must be >= 0, got %v", s.EdgeMaxError)
+	}
+	if s.VertsPerPoly < 3 || s.VertsPerPoly > maxVertsPerPoly {
+		fail("vertsperpoly", "must be in [3, %d], got %v", maxVertsPerPoly, s.VertsPerPoly)
+	} else if s.VertsPerPoly != math32.Floor(s.VertsPerPoly) {
+		warn("vertsperpoly", "%v is truncated to %v", s.VertsPerPoly, math32.Floor(s.VertsPerPoly))
+	}
+	if s.DetailSampleDist < 0 {
+		fail("detailsampledist", "must be >= 0, got %v", s.DetailSampleDist)
+	} else if s.DetailSampleDist != 0 && s.DetailSampleDist < 0.9 {
+		warn("detailsampledist", "%v is below 0.9, the detail mesh won't be sampled", s.DetailSampleDist)
+	}
+	if s.DetailSampleMaxError < 0 {
+		fail("detailsamplemaxerror", "must be >= 0, got %v", s.DetailSampleMaxError)
+	}
+	if s.PartitionType < 0 {
+		fail("partitiontype", "must be >= 0, got %v", s.PartitionType)
+	}
+	if s.TileSize < 0 {
+		fail("tilesize", "must be >= 0, got %v", s.TileSize)
+	} else if s.TileSize > 0 && s.TileSize < 16 {
+		warn("tilesize", "%v voxels is very small, tiles should be at least 16 voxels wide", s.TileSize)
+	}
+
+	// agent profiles
+	names := make(map[string]bool)
+	for i, p := range s.AgentProfiles {
+		field := fmt.Sprintf("agentprofiles[%d]", i)
+		if len(p.Name) == 0 {
+			fail(field, "has no name")
+		} else if strings.ContainsAny(p.Name, `/\`) {
+			// the name is part of the navmesh file name
+			fail(field, "name '%v' contains a path separator", p.Name)
+		} else if names[p.Name] {
+			fail(field, "duplicate name '%v'", p.Name)
+		}
+		names[p.Name] = true
+		s.validateAgent(fail, warn, field+".", p.Height, p.Radius, p.MaxClimb, p.MaxSlope)
+	}
+
+	// area ids
+	for name, id := range s.MaterialAreas {
+		if id > WalkableArea {
+			fail("materialareas", "area id %d of material '%v' must be <= %d", id, name, WalkableArea)
+		}
+	}
+	ids := make(map[uint8]bool)
+	for i, a := range s.Areas {
+		field := fmt.Sprintf("areas[%d]", i)
+		if a.ID > WalkableArea {
+			fail(field, "area id must be <= %d, got %d", WalkableArea, a.ID)
+		} else if ids[a.ID] {
+			fail(field, "duplicate area id %d", a.ID)
+		}
+		ids[a.ID] = true
+		if a.Cost < 0 {
+			fail(field, "cost must be >= 0, got %v", a.Cost)
+		}
+	}
+
+	// build area
+	validateBox := func(field string, b *BuildBox) {
+		for i := 0; i < 3; i++ {
+			if b.Min[i] > b.Max[i] {
+				fail(field, "min %v is greater than max %v", b.Min, b.Max)
+				return
+			}
+		}
+	}
+	if s.BuildBounds != nil {
+		validateBox("buildbounds", s.BuildBounds)
+	}
+	for i := range s.IncludeBoxes {
+		validateBox(fmt.Sprintf("includeboxes[%d]", i), &s.IncludeBoxes[i])
+	}
+	for i := range s.ExcludeBoxes {
+		validateBox(fmt.Sprintf("excludeboxes[%d]", i), &s.ExcludeBoxes[i])
+	}
+
+	if len(errs) != 0 {
+		return warnings, &ValidationError{Issues: errs}
+	}
+	return warnings, nil
+}
+
+// validateAgent checks the agent properties of the build settings or of an
+// agent profile, which field names start with prefix.
+func (s BuildSettings) validateAgent(fail, warn func(field, format string, args ...interface{}),
+	prefix string, height, radius, maxClimb, maxSlope float32) {
+
+	if height <= 0 {
+		fail(prefix+"height", "must be > 0, got %v", height)
+	} else if s.CellHeight > 0 && math32.Ceil(height/s.CellHeight) < 3 {
+		fail(prefix+"height", "must be at least 3 cell heights (%v), got %v", 3*s.CellHeight, height)
+	}
+	if radius < 0 {
+		fail(prefix+"radius", "must be >= 0, got %v", radius)
+	} else if radius > 0 && s.CellSize > radius {
+		warn("cellsize", "%v is greater than the %sradius %v, the walkable area will be eroded coarsely; %v to %v is recommended",
+			s.CellSize, prefix, radius, radius/3, radius/2)
+	}
+	if maxClimb < 0 {
+		fail(prefix+"maxclimb", "must be >= 0, got %v", maxClimb)
+	} else {
+		if maxClimb > 0 && s.CellHeight > maxClimb {
+			warn("cellheight", "%v is greater than the %smaxclimb %v, steps won't be climbable", s.CellHeight, prefix, maxClimb)
+		}
+		if height > 0 && maxClimb > height {
+			warn(prefix+"maxclimb", "%v is greater than the %sheight %v", maxClimb, prefix, height)
+		}
+	}
+	if maxSlope < 0 || maxSlope >= 90 {
+		fail(prefix+"maxslope", "must be in [0, 90), got %v", maxSlope)
+	}
+}
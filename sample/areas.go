@@ -0,0 +1,59 @@
+package sample
+
+import (
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+)
+
+// DefaultAreas returns the definitions of the sample areas.
+func DefaultAreas() []recast.AreaDefinition {
+	return []recast.AreaDefinition{
+		{Name: "ground", ID: PolyAreaGround, Flags: PolyFlagsWalk, Cost: 1},
+		{Name: "water", ID: PolyAreaWater, Flags: PolyFlagsSwim, Cost: 10},
+		{Name: "road", ID: PolyAreaRoad, Flags: PolyFlagsWalk, Cost: 1},
+		{Name: "door", ID: PolyAreaDoor, Flags: PolyFlagsWalk | PolyFlagsDoor, Cost: 1},
+		{Name: "grass", ID: PolyAreaGrass, Flags: PolyFlagsWalk, Cost: 2},
+		{Name: "jump", ID: PolyAreaJump, Flags: PolyFlagsJump, Cost: 1.5},
+	}
+}
+
+// ApplyAreas sets the flags of the polygons of pmesh according to their area
+// and the area definitions defs, or DefaultAreas if defs is empty.
+//
+// The polygons having the recast.WalkableArea area id are first given the id
+// of the first area definition, unless recast.WalkableArea is itself defined.
+// The polygons which area has no definition are given no flags.
+func ApplyAreas(defs []recast.AreaDefinition, pmesh *recast.PolyMesh) {
+	if len(defs) == 0 {
+		defs = DefaultAreas()
+	}
+	var (
+		flags   [256]uint16
+		defined [256]bool
+	)
+	for _, def := range defs {
+		flags[def.ID] = def.Flags
+		defined[def.ID] = true
+	}
+	for i := int32(0); i < pmesh.NPolys; i++ {
+		if pmesh.Areas[i] == recast.WalkableArea && !defined[recast.WalkableArea] {
+			pmesh.Areas[i] = defs[0].ID
+		}
+		pmesh.Flags[i] = flags[pmesh.Areas[i]]
+	}
+}
+
+// NewQueryFilter returns a query filter which area costs are the default
+// costs of the area definitions defs, or DefaultAreas if defs is empty.
+func NewQueryFilter(defs []recast.AreaDefinition) *detour.StandardQueryFilter {
+	if len(defs) == 0 {
+		defs = DefaultAreas()
+	}
+	filter := detour.NewStandardQueryFilter()
+	for _, def := range defs {
+		if def.ID <= recast.WalkableArea {
+			filter.SetAreaCost(int32(def.ID), def.Cost)
+		}
+	}
+	return filter
+}
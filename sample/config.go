@@ -0,0 +1,139 @@
+package sample
+
+import (
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/math32"
+)
+
+// DerivedConfig holds the values derived from build settings, in voxel units,
+// by DeriveConfig.
+type DerivedConfig struct {
+	// Config is the recast build config. For a tiled navigation mesh, it is
+	// the config of a tile, border included, which bounds still have to be
+	// set to those of the tile.
+	Config recast.Config
+
+	// GridWidth and GridHeight are the size of the whole navigation mesh, in
+	// cells, along the x and z axes.
+	GridWidth, GridHeight int32
+
+	// TilesX and TilesZ are the number of tiles along the x and z axes, 0
+	// for a non-tiled navigation mesh.
+	TilesX, TilesZ int32
+
+	// TileBits and PolyBits are the number of bits of the polygon references
+	// identifying a tile and a polygon in a tile.
+	TileBits, PolyBits int32
+
+	// NavMeshParams are the parameters of the tiled navigation mesh, zero for
+	// a non-tiled navigation mesh.
+	NavMeshParams detour.NavMeshParams
+}
+
+// DeriveConfig converts the build settings s, in world units, into a recast
+// build config in voxel units, for the navigation mesh which bounds are bmin
+// and bmax.
+//
+// If s.TileSize is not 0, the navigation mesh is tiled: the number of tiles
+// and the parameters of the navigation mesh are derived too.
+func DeriveConfig(s recast.BuildSettings, bmin, bmax []float32) DerivedConfig {
+	var (
+		d   DerivedConfig
+		cfg = &d.Config
+	)
+	cfg.Cs = s.CellSize
+	cfg.Ch = s.CellHeight
+	cfg.WalkableSlopeAngle = s.AgentMaxSlope
+	cfg.WalkableHeight = int32(math32.Ceil(s.AgentHeight / cfg.Ch))
+	cfg.WalkableClimb = int32(math32.Floor(s.AgentMaxClimb / cfg.Ch))
+	cfg.WalkableRadius = int32(math32.Ceil(s.AgentRadius / cfg.Cs))
+	cfg.MaxEdgeLen = int32(s.EdgeMaxLen / s.CellSize)
+	cfg.MaxSimplificationError = s.EdgeMaxError
+	cfg.MinRegionArea = int32(s.RegionMinSize * s.RegionMinSize)       // Note: area = size*size
+	cfg.MergeRegionArea = int32(s.RegionMergeSize * s.RegionMergeSize) // Note: area = size*size
+	cfg.MaxVertsPerPoly = int32(s.VertsPerPoly)
+
+	if s.DetailSampleDist < 0.9 {
+		cfg.DetailSampleDist = 0
+	} else {
+		cfg.DetailSampleDist = s.CellSize * s.DetailSampleDist
+	}
+	cfg.DetailSampleMaxError = s.CellHeight * s.DetailSampleMaxError
+
+	copy(cfg.BMin[:], bmin[:3])
+	copy(cfg.BMax[:], bmax[:3])
+	d.GridWidth, d.GridHeight = recast.CalcGridSize(cfg.BMin[:], cfg.BMax[:], cfg.Cs)
+
+	ts := int32(s.TileSize)
+	if ts <= 0 {
+		cfg.Width, cfg.Height = d.GridWidth, d.GridHeight
+		return d
+	}
+
+	cfg.TileSize = ts
+	cfg.BorderSize = cfg.WalkableRadius + 3 // Reserve enough padding
+	cfg.Width = cfg.TileSize + cfg.BorderSize*2
+	cfg.Height = cfg.TileSize + cfg.BorderSize*2
+
+	d.TilesX = (d.GridWidth + ts - 1) / ts
+	d.TilesZ = (d.GridHeight + ts - 1) / ts
+	d.TileBits, d.PolyBits = tileBits(d.TilesX * d.TilesZ)
+
+	// Max tiles and max polys affect how the tile IDs are caculated.
+	copy(d.NavMeshParams.Orig[:], bmin[:3])
+	d.NavMeshParams.TileWidth = s.TileSize * s.CellSize
+	d.NavMeshParams.TileHeight = s.TileSize * s.CellSize
+	d.NavMeshParams.MaxTiles = 1 << uint(d.TileBits)
+	d.NavMeshParams.MaxPolys = 1 << uint(d.PolyBits)
+	return d
+}
+
+// tileBits returns the number of bits of the polygon references identifying
+// a tile, among ntiles, and a polygon in a tile.
+//
+// There are detour.MaxTilePolyBits bits available for identifying a tile and
+// a polygon (22 bits, or 48 bits with 64-bit references).
+func tileBits(ntiles int32) (tileBits, polyBits int32) {
+	tileBits = math32.MinInt32(int32(math32.Ilog2(math32.NextPow2(uint32(ntiles)))), detour.MaxTileBits)
+	polyBits = math32.MinInt32(detour.MaxTilePolyBits-tileBits, detour.MaxPolyBits)
+	return tileBits, polyBits
+}
+
+// minTilePolyBits is the minimum number of bits identifying a polygon in a
+// tile that SuggestSettings tries to keep, that is 1024 polygons per tile.
+const minTilePolyBits = 10
+
+// Suggestion holds build settings suggested by SuggestSettings.
+type Suggestion struct {
+	CellSize   float32 // Suggested cell size, in world units.
+	CellHeight float32 // Suggested cell height, in world units.
+	TileSize   float32 // Suggested tile size, in voxels.
+}
+
+// SuggestSettings suggests the cell size, cell height and tile size to build
+// a navigation mesh for agents of radius agentRadius, over the world which
+// bounds are bmin and bmax.
+//
+// The cell size is half the agent radius, a good trade-off between precision
+// and build time, and the cell height is half the cell size. The tile size is
+// the smallest power of 2, from 32 to 512 voxels, leaving enough bits in the
+// polygon references to identify 1024 polygons per tile.
+func SuggestSettings(agentRadius float32, bmin, bmax []float32) Suggestion {
+	var sug Suggestion
+	sug.CellSize = agentRadius / 2
+	if sug.CellSize <= 0 {
+		sug.CellSize = 0.3
+	}
+	sug.CellHeight = sug.CellSize / 2
+
+	gw, gh := recast.CalcGridSize(bmin, bmax, sug.CellSize)
+	for ts := int32(32); ts <= 512; ts *= 2 {
+		sug.TileSize = float32(ts)
+		ntiles := ((gw + ts - 1) / ts) * ((gh + ts - 1) / ts)
+		if _, polyBits := tileBits(ntiles); polyBits >= minTilePolyBits {
+			break
+		}
+	}
+	return sug
+}
@@ -0,0 +1,378 @@
+package sample
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+)
+
+// Stage identifies a stage of the navigation mesh build pipeline.
+type Stage int
+
+// The stages of the build pipeline, in the order they run.
+const (
+	StageRasterize   Stage = iota // Rasterize the input triangles into a heightfield.
+	StageFilter                   // Filter the walkable spans of the heightfield.
+	StageCompact                  // Build the compact heightfield.
+	StageErode                    // Erode the walkable area by the agent radius.
+	StageMarkAreas                // Mark the areas of the convex volumes.
+	StagePartition                // Partition the walkable area into regions.
+	StageContours                 // Trace and simplify the region contours.
+	StagePolyMesh                 // Build the polygon mesh from the contours.
+	StageDetail                   // Build the detail mesh.
+	StageNavMeshData              // Create the Detour navigation mesh data.
+	numStages
+)
+
+var stageNames = [numStages]string{
+	"rasterize",
+	"filter",
+	"compact",
+	"erode",
+	"mark areas",
+	"partition",
+	"contours",
+	"polymesh",
+	"detail",
+	"navmesh data",
+}
+
+func (s Stage) String() string {
+	if s < 0 || s >= numStages {
+		return fmt.Sprintf("Stage(%d)", int(s))
+	}
+	return stageNames[s]
+}
+
+var (
+	// ErrNoGeometry is returned when the pipeline has no input geometry.
+	ErrNoGeometry = errors.New("no input geometry")
+
+	// ErrUnsupportedPartition is returned when the partition type of the
+	// pipeline is not supported.
+	ErrUnsupportedPartition = errors.New("unsupported partition type")
+)
+
+// StageError is the error returned when a stage of a Pipeline fails.
+type StageError struct {
+	Stage Stage // Stage that failed.
+	Err   error // Reason of the failure.
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%v stage: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the reason of the failure.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// A Pipeline builds the navigation mesh data of a single tile, or of a solo
+// mesh, from an input geometry, running the Recast stages one after the
+// other, then creating the Detour navigation mesh data.
+//
+// The products of each stage are kept in the pipeline, so that they can be
+// inspected, or modified, from the Hook called after each stage, or once the
+// build is over. If the build context has an arena, see recast.Arena, the
+// products are only valid until the arena is reset.
+//
+// The stages can also be run separately, see RunStages, for example to
+// rasterize the input geometry once and then filter copies of the resulting
+// heightfield for different agents.
+type Pipeline struct {
+	Ctx      *recast.BuildContext // Build context, for logs and timers.
+	Geom     *recast.InputGeom    // Input geometry.
+	Config   recast.Config        // Recast build config.
+	Settings recast.BuildSettings // Build settings, for the agent and the areas.
+
+	// Partition is the partition method, PartitionMonotone by default.
+	Partition PartitionType
+
+	// TileX and TileY are the coordinates of the tile to build, in a tiled
+	// navigation mesh. When Config.TileSize is not 0, only the triangles of
+	// the input geometry overlapping the config bounds are rasterized.
+	TileX, TileY int32
+
+	// Hook, if not nil, is called after each stage has successfully run.
+	// Returning an error aborts the build, the error is then returned in a
+	// StageError.
+	Hook func(stage Stage, p *Pipeline) error
+
+	// Products of the stages, set as the stages run.
+	TriAreas []uint8                    // Area ids of the rasterized triangles.
+	TriCount int32                      // Number of rasterized triangles.
+	Solid    *recast.Heightfield        // Heightfield, from StageRasterize.
+	CHF      *recast.CompactHeightfield // Compact heightfield, from StageCompact.
+	CSet     *recast.ContourSet         // Contours, from StageContours.
+	PMesh    *recast.PolyMesh           // Polygon mesh, from StagePolyMesh.
+	DMesh    *recast.PolyMeshDetail     // Detail mesh, from StageDetail.
+	NavData  []byte                     // Navigation mesh data, from StageNavMeshData.
+
+	empty bool // set when a stage has nothing to pass to the next one.
+}
+
+// NewPipeline creates a pipeline building the input geometry geom with the
+// recast config cfg and the build settings settings.
+func NewPipeline(ctx *recast.BuildContext, geom *recast.InputGeom, cfg recast.Config, settings recast.BuildSettings) *Pipeline {
+	return &Pipeline{
+		Ctx:       ctx,
+		Geom:      geom,
+		Config:    cfg,
+		Settings:  settings,
+		Partition: PartitionMonotone,
+	}
+}
+
+// Run runs all the stages of the pipeline.
+//
+// NavData is nil, and the returned error too, if the build produced no
+// polygons.
+func (p *Pipeline) Run() error {
+	return p.RunStages(StageRasterize, StageNavMeshData)
+}
+
+// RunStages runs the stages of the pipeline from the stage from to the stage
+// to, both included. The products of the stages before from must have been
+// set beforehand.
+//
+// The returned error, if not nil, is a *StageError.
+//
+// While a stage runs, the "stage" field of the build context is set to its
+// name, in order to give context to the log entries.
+//
+// If the context of the build context is done, the pipeline stops and the
+// StageError wraps a *recast.CanceledError.
+func (p *Pipeline) RunStages(from, to Stage) error {
+	if p.Geom == nil || p.Geom.Mesh() == nil {
+		return &StageError{Stage: from, Err: ErrNoGeometry}
+	}
+	p.empty = false
+	defer p.Ctx.RemoveField("stage")
+	for s := from; s <= to && s < numStages; s++ {
+		p.Ctx.SetField("stage", s.String())
+		if err := p.Ctx.Err(); err != nil {
+			return &StageError{Stage: s, Err: err}
+		}
+		if err := p.runStage(s); err != nil {
+			if cerr := p.Ctx.Err(); cerr != nil {
+				// the stage failed because it has been interrupted
+				err = cerr
+			}
+			return &StageError{Stage: s, Err: err}
+		}
+		if p.empty {
+			// nothing left to build
+			p.NavData = nil
+			return nil
+		}
+		if p.Hook != nil {
+			if err := p.Hook(s, p); err != nil {
+				return &StageError{Stage: s, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) runStage(s Stage) error {
+	switch s {
+	case StageRasterize:
+		if err := p.rasterize(); err != nil {
+			return err
+		}
+		// Ignore the geometry outside of the build area.
+		recast.ClipHeightfield(p.Ctx, p.Geom.IncludeBoxes(), p.Geom.ExcludeBoxes(), p.Solid)
+	case StageFilter:
+		p.filter()
+	case StageCompact:
+		p.CHF = &recast.CompactHeightfield{}
+		if !recast.BuildCompactHeightfield(p.Ctx, p.Config.WalkableHeight, p.Config.WalkableClimb, p.Solid, p.CHF) {
+			return errors.New("could not build compact data")
+		}
+	case StageErode:
+		if !recast.ErodeWalkableArea(p.Ctx, p.Config.WalkableRadius, p.CHF) {
+			return errors.New("could not erode")
+		}
+	case StageMarkAreas:
+		vols := p.Geom.ConvexVolumes()
+		for i := int32(0); i < p.Geom.ConvexVolumesCount(); i++ {
+			recast.MarkConvexPolyArea(p.Ctx, vols[i].Verts[:], vols[i].NVerts, vols[i].HMin, vols[i].HMax, uint8(vols[i].Area), p.CHF)
+		}
+	case StagePartition:
+		return p.partition()
+	case StageContours:
+		p.CSet = &recast.ContourSet{}
+		if !recast.BuildContours(p.Ctx, p.CHF, p.Config.MaxSimplificationError, p.Config.MaxEdgeLen, p.CSet, recast.ContourTessWallEdges) {
+			return errors.New("could not create contours")
+		}
+		p.empty = p.Config.TileSize != 0 && p.CSet.NConts == 0
+	case StagePolyMesh:
+		var ok bool
+		if p.PMesh, ok = recast.BuildPolyMesh(p.Ctx, p.CSet, p.Config.MaxVertsPerPoly); !ok {
+			return errors.New("could not triangulate contours")
+		}
+	case StageDetail:
+		var ok bool
+		if p.DMesh, ok = recast.BuildPolyMeshDetail(p.Ctx, p.PMesh, p.CHF, p.Config.DetailSampleDist, p.Config.DetailSampleMaxError); !ok {
+			return errors.New("could not build detail mesh")
+		}
+	case StageNavMeshData:
+		return p.createNavMeshData()
+	}
+	return nil
+}
+
+// rasterize rasterizes the input triangles into a new heightfield.
+func (p *Pipeline) rasterize() error {
+	mesh := p.Geom.Mesh()
+	verts := mesh.Verts()
+	nverts := mesh.VertCount()
+
+	// Allocate voxel heightfield where we rasterize our input data to, from
+	// the arena of the build context, if any.
+	p.Solid = p.Ctx.Arena().NewHeightfield(p.Config.Width, p.Config.Height, p.Config.BMin[:], p.Config.BMax[:], p.Config.Cs, p.Config.Ch)
+
+	// markAreas computes the area ids of the triangles, the area ids defined
+	// by the input mesh being kept for the walkable triangles.
+	markAreas := func(tris []int32, ntris int32, areas []uint8) {
+		if areas != nil {
+			copy(p.TriAreas, areas)
+			recast.ClearUnwalkableTriangles(p.Ctx, p.Config.WalkableSlopeAngle, verts, nverts, tris, ntris, p.TriAreas)
+		} else {
+			for i := range p.TriAreas {
+				p.TriAreas[i] = 0
+			}
+			recast.MarkWalkableTriangles(p.Ctx, p.Config.WalkableSlopeAngle, verts, nverts, tris, ntris, p.TriAreas)
+		}
+	}
+
+	if p.Config.TileSize == 0 {
+		tris := mesh.Tris()
+		ntris := mesh.TriCount()
+		p.TriAreas = make([]uint8, ntris)
+		p.TriCount = ntris
+		markAreas(tris, ntris, recast.MeshAreas(mesh))
+		if !recast.RasterizeTriangles(p.Ctx, verts, nverts, tris, p.TriAreas, ntris, p.Solid, p.Config.WalkableClimb) {
+			return errors.New("could not rasterize triangles")
+		}
+		return nil
+	}
+
+	// Only rasterize the chunks of triangles overlapping the tile.
+	chunkyMesh := p.Geom.ChunkyMesh()
+	if chunkyMesh == nil {
+		return ErrNoGeometry
+	}
+	p.TriAreas = make([]uint8, chunkyMesh.MaxTrisPerChunk)
+	p.TriCount = 0
+
+	var tbmin, tbmax [2]float32
+	tbmin[0] = p.Config.BMin[0]
+	tbmin[1] = p.Config.BMin[2]
+	tbmax[0] = p.Config.BMax[0]
+	tbmax[1] = p.Config.BMax[2]
+	var cid [512]int32 // TODO: Make grow when returning too many items.
+	ncid := chunkyMesh.ChunksOverlappingRect(tbmin, tbmax, cid[:])
+	if ncid == 0 {
+		p.empty = true
+		return nil
+	}
+
+	for i := 0; i < ncid; i++ {
+		node := chunkyMesh.Nodes[cid[i]]
+		ctris := chunkyMesh.Tris[node.I*3:]
+		nctris := node.N
+
+		p.TriCount += nctris
+
+		var areas []uint8
+		if chunkyMesh.Areas != nil {
+			areas = chunkyMesh.Areas[node.I : node.I+nctris]
+		}
+		markAreas(ctris, nctris, areas)
+		if !recast.RasterizeTriangles(p.Ctx, verts, nverts, ctris, p.TriAreas, nctris, p.Solid, p.Config.WalkableClimb) {
+			return errors.New("could not rasterize triangles")
+		}
+	}
+	return nil
+}
+
+// filter removes the unwanted overhangs caused by the conservative
+// rasterization, as well as the spans where the agent can't stand.
+func (p *Pipeline) filter() {
+	recast.FilterLowHangingWalkableObstacles(p.Ctx, p.Config.WalkableClimb, p.Solid)
+	recast.FilterLedgeSpans(p.Ctx, p.Config.WalkableHeight, p.Config.WalkableClimb, p.Solid)
+	recast.FilterWalkableLowHeightSpans(p.Ctx, p.Config.WalkableHeight, p.Solid)
+}
+
+// partition partitions the compact heightfield into regions.
+//
+// Only the monotone partitioning is supported for now, which is the fastest,
+// partitions the heightfield into regions without holes and overlaps, but
+// creates long thin polygons, which sometimes causes paths with detours.
+func (p *Pipeline) partition() error {
+	if p.Partition != PartitionMonotone {
+		return ErrUnsupportedPartition
+	}
+	if !recast.BuildRegionsMonotone(p.Ctx, p.CHF, p.Config.BorderSize, p.Config.MinRegionArea, p.Config.MergeRegionArea) {
+		return errors.New("could not build monotone regions")
+	}
+	return nil
+}
+
+// createNavMeshData creates the Detour navigation mesh data from the polygon
+// and detail meshes.
+func (p *Pipeline) createNavMeshData() error {
+	// The GUI may allow more max points per polygon than Detour can handle.
+	if p.Config.MaxVertsPerPoly > int32(detour.VertsPerPolygon) {
+		return fmt.Errorf("detour doesn't handle so many vertices per polygon. should be <= %v", detour.VertsPerPolygon)
+	}
+	if p.PMesh.NVerts >= 0xffff {
+		// The vertex indices are ushorts, and cannot point to more than 0xffff vertices.
+		return fmt.Errorf("too many vertices %d (max: %d)", p.PMesh.NVerts, 0xffff)
+	}
+
+	// Update poly flags from areas.
+	ApplyAreas(p.Settings.Areas, p.PMesh)
+
+	var params detour.NavMeshCreateParams
+	params.Verts = p.PMesh.Verts
+	params.VertCount = p.PMesh.NVerts
+	params.Polys = p.PMesh.Polys
+	params.PolyAreas = p.PMesh.Areas
+	params.PolyFlags = p.PMesh.Flags
+	params.PolyCount = p.PMesh.NPolys
+	params.Nvp = p.PMesh.Nvp
+	params.DetailMeshes = p.DMesh.Meshes
+	params.DetailVerts = p.DMesh.Verts
+	params.DetailVertsCount = p.DMesh.NVerts
+	params.DetailTris = p.DMesh.Tris
+	params.DetailTriCount = p.DMesh.NTris
+	params.OffMeshConVerts = p.Geom.OffMeshConnectionVerts()
+	params.OffMeshConRad = p.Geom.OffMeshConnectionRads()
+	params.OffMeshConDir = p.Geom.OffMeshConnectionDirs()
+	params.OffMeshConAreas = p.Geom.OffMeshConnectionAreas()
+	params.OffMeshConFlags = p.Geom.OffMeshConnectionFlags()
+	params.OffMeshConUserID = p.Geom.OffMeshConnectionId()
+	params.OffMeshConCount = p.Geom.OffMeshConnectionCount()
+	params.WalkableHeight = p.Settings.AgentHeight
+	params.WalkableRadius = p.Settings.AgentRadius
+	params.WalkableClimb = p.Settings.AgentMaxClimb
+	params.TileX = p.TileX
+	params.TileY = p.TileY
+	params.TileLayer = 0
+	copy(params.BMin[:], p.PMesh.BMin[:])
+	copy(params.BMax[:], p.PMesh.BMax[:])
+	params.Cs = p.Config.Cs
+	params.Ch = p.Config.Ch
+	params.BuildBvTree = true
+
+	var err error
+	if p.NavData, err = detour.CreateNavMeshData(&params); err != nil {
+		return fmt.Errorf("could not build Detour navmesh: %v", err)
+	}
+	return nil
+}
@@ -2,12 +2,17 @@ package solomesh
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/arl/go-detour/detour"
 	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
 	"github.com/arl/gogeo/f32/d3"
 	"github.com/arl/math32"
 )
@@ -36,7 +41,7 @@ func compareFiles(fn1, fn2 string) (bool, error) {
 		return false, err
 	}
 
-	f2, err = ioutil.ReadFile(fn2)
+	f2, err = readGolden(fn2, f1)
 	if err != nil {
 		return false, err
 	}
@@ -44,6 +49,47 @@ func compareFiles(fn1, fn2 string) (bool, error) {
 	return bytes.Equal(f1, f2), nil
 }
 
+// readGolden reads the golden navmesh file fn, to compare it to the navmesh
+// file data built.
+//
+// The golden files have 32-bit references so, when built with 64-bit
+// references (polyref64 build tag), the navmesh is decoded, which converts
+// them, then encoded again. Its maximum number of tiles and polygons per
+// tile, which depend on the reference size, are taken from built.
+func readGolden(fn string, built []byte) ([]byte, error) {
+	if detour.MaxTilePolyBits <= 22 {
+		// 32-bit references
+		return ioutil.ReadFile(fn)
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mesh, err := detour.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	builtMesh, err := detour.Decode(bytes.NewReader(built))
+	if err != nil {
+		return nil, err
+	}
+	mesh.Params.MaxTiles = builtMesh.Params.MaxTiles
+	mesh.Params.MaxPolys = builtMesh.Params.MaxPolys
+
+	tmp, err := ioutil.TempFile("", "golden")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err = mesh.SaveToFile(tmp.Name()); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(tmp.Name())
+}
+
 const testDataDir = "../../testdata/sample/solomesh/"
 const OBJDir = "../../testdata/obj/"
 
@@ -91,6 +137,85 @@ func testCreateSoloMesh(t *testing.T, objName string) {
 	}
 }
 
+func TestBuildProfiles(t *testing.T) {
+	path := OBJDir + "develer.obj"
+	meshBinPath := testDataDir + "develer.bin"
+
+	// timers are enabled, to know whether a profile has rasterized the input
+	// geometry: they are reset at the start of each profile, and the polygon
+	// mesh is logged at its end.
+	ctx := recast.NewBuildContext(true)
+	var rasterized []bool
+	ctx.AddLogSink(recast.LogSinkFunc(func(e recast.LogEntry) {
+		if e.Msg == ">> Polymesh:" {
+			rasterized = append(rasterized, ctx.AccumulatedTime(recast.TimerRasterizeTriangles) != 0)
+		}
+	}))
+	soloMesh := New(ctx)
+	r, err := os.Open(path)
+	check(t, err)
+	defer r.Close()
+	check(t, soloMesh.LoadGeometry(r))
+
+	// the first and last profiles share the rasterized heightfield, and are
+	// identical to the default settings.
+	def := DefaultSettings()
+	human := recast.AgentProfile{
+		Name:     "human",
+		Height:   def.AgentHeight,
+		Radius:   def.AgentRadius,
+		MaxClimb: def.AgentMaxClimb,
+		MaxSlope: def.AgentMaxSlope,
+	}
+	small := recast.AgentProfile{Name: "small", Height: 0.5, Radius: 0.2, MaxClimb: 0.3, MaxSlope: 30}
+	navMeshes, ok := soloMesh.BuildProfiles([]recast.AgentProfile{human, small, human})
+	if !ok {
+		t.Fatalf("couldn't build navmeshes for %v", path)
+	}
+	if len(navMeshes) != 3 {
+		t.Fatalf("got %d navmeshes, want 3", len(navMeshes))
+	}
+
+	// the small profile has its own heightfield, the last one reuses the
+	// heightfield of the first one.
+	if want := []bool{true, true, false}; !reflect.DeepEqual(rasterized, want) {
+		t.Fatalf("got rasterized input geometry for profiles %v, want %v", rasterized, want)
+	}
+
+	for _, i := range []int{0, 2} {
+		outBin := "out.bin"
+		check(t, navMeshes[i].SaveToFile(outBin))
+		ok, err = compareFiles(outBin, meshBinPath)
+		os.Remove(outBin)
+		check(t, err)
+		if !ok {
+			t.Fatalf("navmesh %d and %v are different", i, meshBinPath)
+		}
+	}
+
+	// the small profile navmesh is the same as if it were built alone, and
+	// differs from the human one.
+	encode := func(m *detour.NavMesh) []byte {
+		var buf bytes.Buffer
+		check(t, m.Encode(&buf, detour.SaveOptions{}))
+		return buf.Bytes()
+	}
+	if navMeshes[1] == nil || navMeshes[1].Tiles[0].Header == nil || navMeshes[1].Tiles[0].Header.PolyCount == 0 {
+		t.Fatalf("small profile: got an empty navmesh")
+	}
+	if bytes.Equal(encode(navMeshes[1]), encode(navMeshes[0])) {
+		t.Fatalf("small profile: navmesh is the same as the human one")
+	}
+	soloMesh.SetSettings(def.WithProfile(small))
+	alone, ok := soloMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh for the small profile")
+	}
+	if !bytes.Equal(encode(navMeshes[1]), encode(alone)) {
+		t.Fatalf("small profile: navmesh differs from the one built alone")
+	}
+}
+
 func TestCreateDevelerSoloNavMesh(t *testing.T) {
 	testCreateSoloMesh(t, "develer")
 }
@@ -343,3 +468,180 @@ func TestRaycastSoloMesh(t *testing.T) {
 		}
 	}
 }
+
+// 2 adjacent quads, the first one being a road
+const roadAndGrassOBJ = `v 0 0 0
+v 0 0 10
+v 10 0 0
+v 10 0 10
+v 20 0 0
+v 20 0 10
+usemtl road
+f 1 2 4 3
+usemtl grass
+f 3 4 6 5
+`
+
+func TestMaterialAreas(t *testing.T) {
+	soloMesh := New(recast.NewBuildContext(false))
+	settings := DefaultSettings()
+	settings.MaterialAreas = map[string]uint8{"road": sample.PolyAreaRoad}
+	soloMesh.SetSettings(settings)
+	check(t, soloMesh.LoadGeometry(strings.NewReader(roadAndGrassOBJ)))
+
+	navMesh, ok := soloMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh")
+	}
+
+	counts := make(map[uint8]int)
+	tile := &navMesh.Tiles[0]
+	for i := int32(0); i < tile.Header.PolyCount; i++ {
+		counts[tile.Polys[i].Area()]++
+		if tile.Polys[i].Flags != sample.PolyFlagsWalk {
+			t.Errorf("polygon %d has flags 0x%x, want 0x%x", i, tile.Polys[i].Flags, sample.PolyFlagsWalk)
+		}
+	}
+	if counts[sample.PolyAreaRoad] == 0 || counts[sample.PolyAreaGround] == 0 || len(counts) != 2 {
+		t.Fatalf("got polygon areas %v, want road and ground areas", counts)
+	}
+}
+
+func TestBuildArea(t *testing.T) {
+	roadOnly := []recast.BuildSettings{DefaultSettings(), DefaultSettings()}
+	roadOnly[0].BuildBounds = &recast.BuildBox{Min: [3]float32{-1, -1, -1}, Max: [3]float32{10, 1, 11}}
+	roadOnly[1].ExcludeBoxes = []recast.BuildBox{{Min: [3]float32{10, -1, -1}, Max: [3]float32{21, 1, 11}}}
+
+	for i, settings := range roadOnly {
+		settings.MaterialAreas = map[string]uint8{"road": sample.PolyAreaRoad}
+		soloMesh := New(recast.NewBuildContext(false))
+		soloMesh.SetSettings(settings)
+		check(t, soloMesh.LoadGeometry(strings.NewReader(roadAndGrassOBJ)))
+
+		navMesh, ok := soloMesh.Build()
+		if !ok {
+			t.Fatalf("settings %d: couldn't build navmesh", i)
+		}
+		tile := &navMesh.Tiles[0]
+		if tile.Header.PolyCount == 0 {
+			t.Fatalf("settings %d: navmesh has no polygons", i)
+		}
+		for j := int32(0); j < tile.Header.PolyCount; j++ {
+			if area := tile.Polys[j].Area(); area != sample.PolyAreaRoad {
+				t.Errorf("settings %d: polygon %d has area %d, want only road polygons", i, j, area)
+			}
+		}
+	}
+}
+
+func TestAreaDefinitions(t *testing.T) {
+	const (
+		areaGrass = 10
+		areaRoad  = 20
+		flagsRoad = 0x20
+	)
+	settings := DefaultSettings()
+	settings.MaterialAreas = map[string]uint8{"road": areaRoad}
+	settings.Areas = []recast.AreaDefinition{
+		{Name: "grass", ID: areaGrass, Flags: sample.PolyFlagsWalk, Cost: 3},
+		{Name: "road", ID: areaRoad, Flags: flagsRoad, Cost: 0.5},
+	}
+
+	soloMesh := New(recast.NewBuildContext(false))
+	soloMesh.SetSettings(settings)
+	check(t, soloMesh.LoadGeometry(strings.NewReader(roadAndGrassOBJ)))
+	navMesh, ok := soloMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh")
+	}
+
+	// the walkable polygons without material area are given the first area
+	tile := &navMesh.Tiles[0]
+	want := map[uint8]uint16{areaGrass: sample.PolyFlagsWalk, areaRoad: flagsRoad}
+	for i := int32(0); i < tile.Header.PolyCount; i++ {
+		p := &tile.Polys[i]
+		flags, ok := want[p.Area()]
+		if !ok || p.Flags != flags {
+			t.Fatalf("polygon %d has area %d and flags 0x%x", i, p.Area(), p.Flags)
+		}
+	}
+
+	filter := sample.NewQueryFilter(settings.Areas)
+	if filter.AreaCost(areaGrass) != 3 || filter.AreaCost(areaRoad) != 0.5 || filter.AreaCost(0) != 1 {
+		t.Fatalf("unexpected area costs")
+	}
+}
+
+func TestPipelineStages(t *testing.T) {
+	ctx := recast.NewBuildContext(false)
+	sm := New(ctx)
+	r, err := os.Open(OBJDir + "cube.obj")
+	check(t, err)
+	defer r.Close()
+	check(t, sm.LoadGeometry(r))
+	sm.initConfig()
+
+	// the hook is called after each stage, with its product.
+	var stages []sample.Stage
+	p := sm.newPipeline()
+	p.Hook = func(stage sample.Stage, p *sample.Pipeline) error {
+		stages = append(stages, stage)
+		if stage == sample.StagePolyMesh && p.PMesh == nil {
+			t.Errorf("polygon mesh should be set after %v stage", stage)
+		}
+		return nil
+	}
+	check(t, p.Run())
+	if len(stages) != int(sample.StageNavMeshData)+1 {
+		t.Fatalf("hook called for stages %v, want all the stages", stages)
+	}
+	if len(p.NavData) == 0 {
+		t.Fatalf("pipeline produced no navmesh data")
+	}
+
+	// running the stages separately gives the same data.
+	p2 := sm.newPipeline()
+	check(t, p2.RunStages(sample.StageRasterize, sample.StageCompact))
+	check(t, p2.RunStages(sample.StageErode, sample.StageNavMeshData))
+	if !bytes.Equal(p.NavData, p2.NavData) {
+		t.Errorf("navmesh data differs when the stages are run separately")
+	}
+
+	// a hook error aborts the build.
+	p3 := sm.newPipeline()
+	abort := errors.New("abort")
+	p3.Hook = func(stage sample.Stage, p *sample.Pipeline) error {
+		if stage == sample.StageContours {
+			return abort
+		}
+		return nil
+	}
+	err = p3.Run()
+	serr, ok := err.(*sample.StageError)
+	if !ok || serr.Stage != sample.StageContours || serr.Err != abort {
+		t.Errorf("got error %v, want the hook error in the contours stage", err)
+	}
+	if p3.PMesh != nil {
+		t.Errorf("stages after the hook error should not run")
+	}
+
+	// a done context interrupts the build.
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	sm.ctx.SetContext(c)
+	err = sm.newPipeline().Run()
+	sm.ctx.SetContext(nil)
+	if serr, ok := err.(*sample.StageError); !ok || serr.Stage != sample.StageRasterize {
+		t.Errorf("got error %v, want a rasterize stage error", err)
+	} else if _, ok := serr.Err.(*recast.CanceledError); !ok {
+		t.Errorf("got error %v, want a *recast.CanceledError", serr.Err)
+	}
+
+	// unsupported partition type.
+	p4 := sm.newPipeline()
+	p4.Partition = sample.PartitionWatershed
+	err = p4.Run()
+	if serr, ok := err.(*sample.StageError); !ok || serr.Err != sample.ErrUnsupportedPartition {
+		t.Errorf("got error %v, want ErrUnsupportedPartition", err)
+	}
+}
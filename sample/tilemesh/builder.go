@@ -1,6 +1,7 @@
 package tilemesh
 
 import (
+	"errors"
 	"io"
 	"time"
 
@@ -8,7 +9,6 @@ import (
 	"github.com/arl/go-detour/recast"
 	"github.com/arl/go-detour/sample"
 	"github.com/arl/gogeo/f32/d3"
-	"github.com/arl/math32"
 )
 
 // TileMesh allows building multi-tile navigation meshes.
@@ -29,16 +29,18 @@ type TileMesh struct {
 	tileBuildTime     time.Duration
 	tileMemUsage      float32
 
+	// per-stage build times, accumulated over all the tiles.
+	buildTimes map[recast.TimerLabel]time.Duration
+
+	// cache of the built tiles, may be nil.
+	cache TileCache
+
 	maxTiles        uint32
 	maxPolysPerTile uint32
 	tileTriCount    int32
 
-	triAreas []uint8
-	solid    *recast.Heightfield
-	chf      *recast.CompactHeightfield
-	cset     *recast.ContourSet
-	pmesh    *recast.PolyMesh
-	dmesh    *recast.PolyMeshDetail
+	// memory of the intermediate structures, reused from tile to tile.
+	arena recast.Arena
 }
 
 // New creates a new tile mesh with default build settings.
@@ -53,15 +55,20 @@ func New(ctx *recast.BuildContext) *TileMesh {
 	return sm
 }
 
-// SetSettings sets the build settings for this tile mesh.
+// SetSettings sets the build settings for this tile mesh, including the
+// build area of the input geometry.
 func (tm *TileMesh) SetSettings(s recast.BuildSettings) {
 	tm.settings = s
+	tm.geom.SetBuildArea(s.BuildBounds, s.IncludeBoxes, s.ExcludeBoxes)
 }
 
 // LoadGeometry loads geometry from r that reads from a geometry definition
-// file.
+// file in OBJ format. The material areas of the build settings are applied to
+// the triangles.
 func (tm *TileMesh) LoadGeometry(r io.Reader) error {
-	return tm.geom.LoadOBJMesh(r)
+	ml := recast.NewMeshLoaderOBJ()
+	ml.MaterialAreas = tm.settings.MaterialAreas
+	return tm.geom.LoadMesh(ml, r)
 }
 
 // InputGeom returns the nav mesh input geometry.
@@ -69,36 +76,31 @@ func (tm *TileMesh) InputGeom() *recast.InputGeom {
 	return &tm.geom
 }
 
+// NavMesh returns the navigation mesh, as created by the last call to Build.
+func (tm *TileMesh) NavMesh() *detour.NavMesh {
+	return &tm.navMesh
+}
+
 // Build builds the navigation mesh for the input geometry provided
+//
+// The build is interrupted between tiles, or while building one, if the
+// context of the build context is done. Build then returns false and the Err
+// method of the build context returns a *recast.CanceledError. The tiles
+// already built are kept in the navigation mesh returned by NavMesh.
 func (tm *TileMesh) Build() (*detour.NavMesh, bool) {
 	if tm.geom.Mesh() == nil {
 		// TODO: error "no vertices and triangles"
 		return nil, false
 	}
 
-	bmin := tm.geom.NavMeshBoundsMin()
-	bmax := tm.geom.NavMeshBoundsMax()
-	gw, gh := recast.CalcGridSize(bmin[:], bmax[:], tm.settings.CellSize)
-	ts := int32(tm.settings.TileSize)
-	tw := (gw + ts - 1) / ts
-	th := (gh + ts - 1) / ts
-
-	// Max tiles and max polys affect how the tile IDs are caculated.
-	// There are 22 bits available for identifying a tile and a polygon.
-	tileBits := math32.MinInt32(int32(math32.Ilog2(math32.NextPow2(uint32(tw*th)))), 14)
-	polyBits := 22 - tileBits
-	tm.maxTiles = 1 << uint(tileBits)
-	tm.maxPolysPerTile = 1 << uint(polyBits)
+	d := sample.DeriveConfig(tm.settings, tm.geom.NavMeshBoundsMin(), tm.geom.NavMeshBoundsMax())
+	tm.maxTiles = d.NavMeshParams.MaxTiles
+	tm.maxPolysPerTile = d.NavMeshParams.MaxPolys
 
 	var (
-		params detour.NavMeshParams
+		params = d.NavMeshParams
 		status detour.Status
 	)
-	copy(params.Orig[:], tm.geom.NavMeshBoundsMin()[:3])
-	params.TileWidth = tm.settings.TileSize * tm.settings.CellSize
-	params.TileHeight = tm.settings.TileSize * tm.settings.CellSize
-	params.MaxTiles = tm.maxTiles
-	params.MaxPolys = tm.maxPolysPerTile
 	status = tm.navMesh.Init(&params)
 	if detour.StatusFailed(status) {
 		tm.ctx.Errorf("TileMesh.Build: Could not init navmesh")
@@ -110,24 +112,29 @@ func (tm *TileMesh) Build() (*detour.NavMesh, bool) {
 		return nil, false
 	}
 
-	tm.buildAllTiles()
-
-	return &tm.navMesh, true
+	return tm.buildAllTiles()
 }
 
 func (tm *TileMesh) buildAllTiles() (*detour.NavMesh, bool) {
 	bmin := tm.geom.NavMeshBoundsMin()
 	bmax := tm.geom.NavMeshBoundsMax()
-	gw, gh := recast.CalcGridSize(bmin[:], bmax[:], tm.settings.CellSize)
-	ts := int32(tm.settings.TileSize)
-	tw := (gw + ts - 1) / ts
-	th := (gh + ts - 1) / ts
+	d := sample.DeriveConfig(tm.settings, bmin, bmax)
+	tw, th := d.TilesX, d.TilesZ
 	tcs := tm.settings.TileSize * tm.settings.CellSize
 
+	tm.buildTimes = make(map[recast.TimerLabel]time.Duration)
+
 	// Start the build process.
 	tm.ctx.StartTimer(recast.TimerTemp)
+	ntiles := int(tw * th)
+	tm.ctx.ReportProgress("tiles", 0, ntiles)
 	for y := int32(0); y < th; y++ {
 		for x := int32(0); x < tw; x++ {
+			if tm.ctx.Err() != nil {
+				// interrupted, Err tells the caller why.
+				tm.ctx.StopTimer(recast.TimerTemp)
+				return nil, false
+			}
 
 			tm.lastBuiltTileBMin[0] = bmin[0] + float32(x)*tcs
 			tm.lastBuiltTileBMin[1] = bmin[1]
@@ -137,13 +144,15 @@ func (tm *TileMesh) buildAllTiles() (*detour.NavMesh, bool) {
 			tm.lastBuiltTileBMax[1] = bmax[1]
 			tm.lastBuiltTileBMax[2] = bmin[2] + float32(y+1)*tcs
 
-			data := tm.buildTileMesh(x, y, tm.lastBuiltTileBMin[:], tm.lastBuiltTileBMax[:])
+			data, _ := tm.buildTileMesh(x, y, tm.lastBuiltTileBMin[:], tm.lastBuiltTileBMax[:])
+			tm.accumulateBuildTimes()
 			if data != nil {
 				// Remove any previous data (navmesh owns and deletes the data).
 				tm.navMesh.RemoveTile(tm.navMesh.TileRefAt(x, y, 0))
 				// Let the navmesh own the data.
 				tm.navMesh.AddTile(data, detour.TileRef(0))
 			}
+			tm.ctx.ReportProgress("tiles", int(x+1+y*tw), ntiles)
 		}
 	}
 
@@ -152,73 +161,86 @@ func (tm *TileMesh) buildAllTiles() (*detour.NavMesh, bool) {
 
 	tm.totalBuildTime = tm.ctx.AccumulatedTime(recast.TimerTemp)
 
-	// TODO: probably useless
 	return &tm.navMesh, true
 }
 
-func (tm *TileMesh) buildTileMesh(tx, ty int32, bmin, bmax []float32) []byte {
+// accumulateBuildTimes adds the per-stage times of the last built tile to the
+// build times accumulated since the beginning of the build.
+func (tm *TileMesh) accumulateBuildTimes() {
+	for _, label := range recast.TimerLabels() {
+		if label == recast.TimerTemp {
+			// TimerTemp measures the whole build, not a single tile
+			continue
+		}
+		tm.buildTimes[label] += tm.ctx.AccumulatedTime(label)
+	}
+}
+
+// BuildTimes returns the time spent in each build stage during the last call
+// to Build, accumulated over all the tiles.
+//
+// The build times are only gathered if the timers of the build context are
+// enabled.
+func (tm *TileMesh) BuildTimes() map[recast.TimerLabel]time.Duration {
+	return tm.buildTimes
+}
+
+// buildTileMesh returns the data of the tile at (tx, ty), nil if the tile has
+// no walkable area. An error is returned if the tile couldn't be built.
+func (tm *TileMesh) buildTileMesh(tx, ty int32, bmin, bmax []float32) ([]byte, error) {
 	if tm.geom.Mesh() == nil || tm.geom.ChunkyMesh() == nil {
 		tm.ctx.Errorf("buildNavigation: Input mesh is not specified.")
-		return nil
+		return nil, errors.New("input mesh is not specified")
 	}
 
 	tm.tileMemUsage = 0
 	tm.tileBuildTime = 0
 
-	verts := tm.geom.Mesh().Verts()
-	nverts := tm.geom.Mesh().VertCount()
-	//tris := sm.geom.Mesh().Tris()
-	ntris := tm.geom.Mesh().TriCount()
-	chunkyMesh := tm.geom.ChunkyMesh()
+	// Give the tile coordinates to the log entries.
+	tm.ctx.SetField("tile_x", tx)
+	tm.ctx.SetField("tile_y", ty)
+	defer tm.ctx.RemoveField("tile_x")
+	defer tm.ctx.RemoveField("tile_y")
 
 	//
 	// Step 1. Initialize build config.
 	//
 
-	// Rasterization settings
-	cellSize := tm.settings.CellSize
-	cellHeight := tm.settings.CellHeight
-
-	// Agent properties
-	agentHeight := tm.settings.AgentHeight
-	agentMaxClimb := tm.settings.AgentMaxClimb
-	agentRadius := tm.settings.AgentRadius
-
-	// Region
-	regionMinSize := tm.settings.RegionMinSize
-	regionMergeSize := tm.settings.RegionMergeSize
-
-	// Polygonization
-	edgeMaxLen := tm.settings.EdgeMaxLen
-	edgeMaxError := tm.settings.EdgeMaxError
-	vertsPerPoly := tm.settings.VertsPerPoly
-
-	// Detail Mesh
-	detailSampleDist := tm.settings.DetailSampleDist
-	detailSampleMaxError := tm.settings.DetailSampleMaxError
-
-	tm.cfg.Cs = cellSize
-	tm.cfg.Ch = cellHeight
-	tm.cfg.WalkableSlopeAngle = tm.settings.AgentMaxSlope
-	tm.cfg.WalkableHeight = int32(math32.Ceil(agentHeight / tm.cfg.Ch))
-	tm.cfg.WalkableClimb = int32(math32.Floor(agentMaxClimb / tm.cfg.Ch))
-	tm.cfg.WalkableRadius = int32(math32.Ceil(agentRadius / tm.cfg.Cs))
-	tm.cfg.MaxEdgeLen = int32(float32(edgeMaxLen) / cellSize)
-	tm.cfg.MaxSimplificationError = edgeMaxError
-	tm.cfg.MinRegionArea = int32(regionMinSize * regionMinSize)       // Note: area = size*size
-	tm.cfg.MergeRegionArea = int32(regionMergeSize * regionMergeSize) // Note: area = size*size
-	tm.cfg.MaxVertsPerPoly = int32(vertsPerPoly)
-	tm.cfg.TileSize = int32(tm.settings.TileSize)
-	tm.cfg.BorderSize = tm.cfg.WalkableRadius + 3 // Reserve enough padding
-	tm.cfg.Width = tm.cfg.TileSize + tm.cfg.BorderSize*2
-	tm.cfg.Height = tm.cfg.TileSize + tm.cfg.BorderSize*2
-
-	if detailSampleDist < 0.9 {
-		tm.cfg.DetailSampleDist = 0
-	} else {
-		tm.cfg.DetailSampleDist = cellSize * detailSampleDist
+	tm.initTileConfig(bmin, bmax)
+
+	if tm.cache == nil {
+		return tm.buildTileData(tx, ty)
 	}
-	tm.cfg.DetailSampleMaxError = cellHeight * detailSampleMaxError
+
+	// Reuse the tile data from the cache if the tile input didn't change.
+	key := tm.tileCacheKey(tx, ty)
+	if data, ok := tm.cache.Get(key); ok {
+		tm.ctx.ResetTimers()
+		tm.ctx.Progressf("Tile (%d,%d) loaded from cache", tx, ty)
+		if len(data) == 0 {
+			// the tile was known to be empty
+			return nil, nil
+		}
+		tm.tileMemUsage = float32(len(data)) / 1024.0
+		return data, nil
+	}
+
+	data, err := tm.buildTileData(tx, ty)
+	if err != nil {
+		// the build of the tile failed, or has been interrupted: only the
+		// tiles successfully built, empty or not, are cached.
+		return nil, err
+	}
+	if err := tm.cache.Put(key, data); err != nil {
+		tm.ctx.Warningf("Could not store tile (%d,%d) in cache: %v", tx, ty, err)
+	}
+	return data, nil
+}
+
+// initTileConfig initializes the recast build config of the tile which
+// bounds are bmin and bmax, borders excluded.
+func (tm *TileMesh) initTileConfig(bmin, bmax []float32) {
+	tm.cfg = sample.DeriveConfig(tm.settings, bmin, bmax).Config
 
 	// Expand the heighfield bounding box by border size to find the extents of
 	// geometry we need to build this tile.
@@ -247,14 +269,24 @@ func (tm *TileMesh) buildTileMesh(tx, ty int32, bmin, bmax []float32) []byte {
 	// each of the 8 neighbours.
 
 	// Set the area where the navigation will be build.
-	// Here the bounds of the input mesh are used, but the area could be
-	// specified by an user defined box, etc.
+	// Here the bounds of the tile are used, the tiles covering the bounds
+	// of the input mesh clipped by the build area of the input geometry.
 	copy(tm.cfg.BMin[:], bmin[:3])
 	copy(tm.cfg.BMax[:], bmax[:3])
 	tm.cfg.BMin[0] -= float32(tm.cfg.BorderSize) * tm.cfg.Cs
 	tm.cfg.BMin[2] -= float32(tm.cfg.BorderSize) * tm.cfg.Cs
 	tm.cfg.BMax[0] += float32(tm.cfg.BorderSize) * tm.cfg.Cs
 	tm.cfg.BMax[2] += float32(tm.cfg.BorderSize) * tm.cfg.Cs
+}
+
+// buildTileData builds the navmesh data of the tile at (tx, ty), from the
+// config previously initialized with initTileConfig.
+//
+// The returned data is nil if the tile is empty, or if its build failed, in
+// which case the returned error is not nil.
+func (tm *TileMesh) buildTileData(tx, ty int32) ([]byte, error) {
+	nverts := tm.geom.Mesh().VertCount()
+	ntris := tm.geom.Mesh().TriCount()
 
 	// Reset build times gathering.
 	tm.ctx.ResetTimers()
@@ -266,301 +298,44 @@ func (tm *TileMesh) buildTileMesh(tx, ty int32, bmin, bmax []float32) []byte {
 	tm.ctx.Progressf(" - %d x %d cells", tm.cfg.Width, tm.cfg.Height)
 	tm.ctx.Progressf(" - %.1fK verts, %.1fK tris", float64(nverts)/1000.0, float64(ntris)/1000.0)
 
-	//
-	// Step 2. Rasterize input polygon soup.
-	//
-
-	// Allocate voxel heightfield where we rasterize our input data to.
-	tm.solid = recast.NewHeightfield(tm.cfg.Width, tm.cfg.Height, tm.cfg.BMin[:], tm.cfg.BMax[:], tm.cfg.Cs, tm.cfg.Ch)
-
-	// Allocate array that can hold triangle flags.
-	// If you have multiple meshes you need to process, allocate
-	// and array which can hold the max number of triangles you need to process.
-	tm.triAreas = make([]uint8, chunkyMesh.MaxTrisPerChunk)
-
-	var tbmin, tbmax [2]float32
-	tbmin[0] = tm.cfg.BMin[0]
-	tbmin[1] = tm.cfg.BMin[2]
-	tbmax[0] = tm.cfg.BMax[0]
-	tbmax[1] = tm.cfg.BMax[2]
-	var cid [512]int32 // TODO: Make grow when returning too many items.
-	ncid := chunkyMesh.ChunksOverlappingRect(tbmin, tbmax, cid[:])
-	if ncid == 0 {
-		return nil
-	}
-
-	tm.tileTriCount = 0
-
-	for i := 0; i < ncid; i++ {
-		node := chunkyMesh.Nodes[cid[i]]
-		ctris := chunkyMesh.Tris[node.I*3:]
-		nctris := node.N
-
-		tm.tileTriCount += nctris
-
-		for ai := 0; ai < len(tm.triAreas); ai++ {
-			tm.triAreas[ai] = 0
-		}
-		recast.MarkWalkableTriangles(tm.ctx, tm.cfg.WalkableSlopeAngle,
-			verts, nverts, ctris, nctris, tm.triAreas)
-
-		if !recast.RasterizeTriangles(tm.ctx, verts, nverts, ctris, tm.triAreas, nctris, tm.solid, tm.cfg.WalkableClimb) {
-			return nil
+	// Reuse the memory of the previously built tiles.
+	tm.arena.Reset()
+	prevArena := tm.ctx.Arena()
+	tm.ctx.SetArena(&tm.arena)
+	defer tm.ctx.SetArena(prevArena)
+
+	p := sample.NewPipeline(tm.ctx, &tm.geom, tm.cfg, tm.settings)
+	p.Partition = tm.partitionType
+	p.TileX, p.TileY = tx, ty
+	err := p.Run()
+	tm.tileTriCount = p.TriCount
+	if err != nil {
+		if tm.ctx.Err() == nil {
+			tm.ctx.Errorf("buildNavigation: %v", err)
 		}
+		return nil, err
 	}
-
-	//
-	// Step 3. Filter walkables surfaces.
-	//
-
-	// Once all geoemtry is rasterized, we do initial pass of filtering to
-	// remove unwanted overhangs caused by the conservative rasterization
-	// as well as filter spans where the character cannot possibly stand.
-	recast.FilterLowHangingWalkableObstacles(tm.ctx, tm.cfg.WalkableClimb, tm.solid)
-	recast.FilterLedgeSpans(tm.ctx, tm.cfg.WalkableHeight, tm.cfg.WalkableClimb, tm.solid)
-	recast.FilterWalkableLowHeightSpans(tm.ctx, tm.cfg.WalkableHeight, tm.solid)
-
-	// Compact the heightfield so that it is faster to handle from now on.
-	// This will result more cache coherent data as well as the neighbours
-	// between walkable cells will be calculated.
-	tm.chf = &recast.CompactHeightfield{}
-	if !recast.BuildCompactHeightfield(tm.ctx, tm.cfg.WalkableHeight, tm.cfg.WalkableClimb, tm.solid, tm.chf) {
-		tm.ctx.Errorf("buildNavigation: Could not build compact data.")
-		return nil
-	}
-
-	// Erode the walkable area by agent radius.
-	if !recast.ErodeWalkableArea(tm.ctx, tm.cfg.WalkableRadius, tm.chf) {
-		tm.ctx.Errorf("buildNavigation: Could not erode.")
-		return nil
-	}
-
-	// (Optional) Mark areas.
-	vols := tm.geom.ConvexVolumes()
-
-	// TODO: : control that ConvexVolumeCount() is also 0 on original library
-	for i := int32(0); i < tm.geom.ConvexVolumesCount(); i++ {
-		recast.MarkConvexPolyArea(tm.ctx, vols[i].Verts[:], vols[i].NVerts, vols[i].HMin, vols[i].HMax, uint8(vols[i].Area), tm.chf)
-	}
-
-	// Partition the heightfield so that we can use simple algorithm later to
-	// triangulate the walkable areas. There are 3 partitioning methods, each
-	// with some pros and cons:
-	// 1) Watershed partitioning
-	//   - the classic Recast partitioning
-	//   - creates the nicest tessellation
-	//   - usually slowest
-	//   - partitions the heightfield into nice regions without holes or
-	//     overlaps
-	//   - the are some corner cases where this method creates produces holes
-	//     and overlaps
-	//      - holes may appear when a small obstacles is close to large open
-	//        area (triangulation can handle this)
-	//      - overlaps may occur if you have narrow spiral corridors (i.e
-	//        stairs), this make triangulation to fail
-	//   * generally the best choice if you precompute the nacmesh, use this if
-	//     you have large open areas
-	// 2) Monotone partitioning
-	//   - fastest
-	//   - partitions the heightfield into regions without holes and overlaps
-	//     (guaranteed)
-	//   - creates long thin polygons, which sometimes causes paths with detours
-	//   * use this if you want fast navmesh generation
-	// 3) Layer partitioning
-	//   - quite fast
-	//   - partitions the heighfield into non-overlapping regions
-	//   - relies on the triangulation code to cope with holes (thus slower than
-	//     monotone partitioning)
-	//   - produces better triangles than monotone partitioning
-	//   - does not have the corner cases of watershed partitioning
-	//   - can be slow and create a bit ugly tessellation (still better than
-	//     monotone) if you have large open areas with small obstacles (not a
-	//     problem if you use tiles)
-	//   * good choice to use for tiled navmesh with medium and small sized
-	//     tiles
-
-	if tm.partitionType == sample.PartitionWatershed {
-		// Prepare for region partitioning, by calculating distance field along the walkable surface.
-		//if (!rcBuildDistanceField(m_ctx, *m_chf))
-		//{
-		//m_ctx.log(RC_LOG_ERROR, "buildNavigation: Could not build distance field.");
-		//return navData, false
-		//}
-
-		//// Partition the walkable surface into simple regions without holes.
-		//if (!rcBuildRegions(m_ctx, *m_chf, 0, m_cfg.minRegionArea, m_cfg.mergeRegionArea))
-		//{
-		//m_ctx.log(RC_LOG_ERROR, "buildNavigation: Could not build watershed regions.");
-		//return navData, false
-		//}
-	} else if tm.partitionType == sample.PartitionMonotone {
-		// Partition the walkable surface into simple regions without holes.
-		// Monotone partitioning does not need distancefield.
-		if !recast.BuildRegionsMonotone(tm.ctx, tm.chf, tm.cfg.BorderSize, tm.cfg.MinRegionArea, tm.cfg.MergeRegionArea) {
-			tm.ctx.Errorf("buildNavigation: Could not build monotone regions.")
-			return nil
-		}
-	} else {
-		// SAMPLE_PARTITION_LAYERS
-		// Partition the walkable surface into simple regions without holes.
-		//if !rcBuildLayerRegions(m_ctx, *m_chf, 0, m_cfg.minRegionArea) {
-		//m_ctx.log(RC_LOG_ERROR, "buildNavigation: Could not build layer regions.")
-		//return navData, false
-		//}
-	}
-
-	//
-	// Step 5. Trace and simplify region contours.
-	//
-
-	// Create contours.
-	tm.cset = &recast.ContourSet{}
-	if !recast.BuildContours(tm.ctx, tm.chf, tm.cfg.MaxSimplificationError, tm.cfg.MaxEdgeLen, tm.cset, recast.ContourTessWallEdges) {
-		tm.ctx.Errorf("buildNavigation: Could not create contours.")
-		return nil
-	}
-
-	if tm.cset.NConts == 0 {
-		return nil
-	}
-
-	//
-	// Step 6. Build polygons mesh from contours.
-	//
-
-	// Build polygon navmesh from the contours.
-	var ret bool
-	tm.pmesh, ret = recast.BuildPolyMesh(tm.ctx, tm.cset, tm.cfg.MaxVertsPerPoly)
-	if !ret {
-		tm.ctx.Errorf("buildNavigation: Could not triangulate contours.")
-		return nil
-	}
-
-	//
-	// Step 7. Create detail mesh which allows to access approximate height on each polygon.
-	//
-
-	tm.dmesh, ret = recast.BuildPolyMeshDetail(tm.ctx, tm.pmesh, tm.chf, tm.cfg.DetailSampleDist, tm.cfg.DetailSampleMaxError)
-	if !ret {
-		tm.ctx.Errorf("buildNavigation: Could not build detail mesh.")
-		return nil
-	}
-
-	//
-	// (Optional) Step 8. Create Detour data from Recast poly mesh.
-	//
-
-	// The GUI may allow more max points per polygon than Detour can handle.
-	// Only build the detour navmesh if we do not exceed the limit.
-	var (
-		navData []uint8
-		err     error
-	)
-	if tm.cfg.MaxVertsPerPoly <= int32(detour.VertsPerPolygon) {
-		if tm.pmesh.NVerts >= 0xffff {
-			// The vertex indices are ushorts, and cannot point to more than 0xffff vertices.
-			tm.ctx.Errorf("Too many vertices per tile %d (max: %d).", tm.pmesh.NVerts, 0xffff)
-			return nil
-		}
-
-		// Update poly flags from areas.
-		for i := int32(0); i < tm.pmesh.NPolys; i++ {
-			if tm.pmesh.Areas[i] == recast.WalkableArea {
-				tm.pmesh.Areas[i] = sample.PolyAreaGround
-			}
-
-			if tm.pmesh.Areas[i] == sample.PolyAreaGround ||
-				tm.pmesh.Areas[i] == sample.PolyAreaGrass ||
-				tm.pmesh.Areas[i] == sample.PolyAreaRoad {
-				tm.pmesh.Flags[i] = sample.PolyFlagsWalk
-			} else if tm.pmesh.Areas[i] == sample.PolyAreaWater {
-				tm.pmesh.Flags[i] = sample.PolyFlagsSwim
-			} else if tm.pmesh.Areas[i] == sample.PolyAreaDoor {
-				tm.pmesh.Flags[i] = sample.PolyFlagsWalk | sample.PolyFlagsDoor
-			}
-		}
-
-		var params detour.NavMeshCreateParams
-		params.Verts = tm.pmesh.Verts
-		params.VertCount = tm.pmesh.NVerts
-		params.Polys = tm.pmesh.Polys
-		params.PolyAreas = tm.pmesh.Areas
-		params.PolyFlags = tm.pmesh.Flags
-		params.PolyCount = tm.pmesh.NPolys
-		params.Nvp = tm.pmesh.Nvp
-		params.DetailMeshes = tm.dmesh.Meshes
-		params.DetailVerts = tm.dmesh.Verts
-		params.DetailVertsCount = tm.dmesh.NVerts
-		params.DetailTris = tm.dmesh.Tris
-		params.DetailTriCount = tm.dmesh.NTris
-		params.OffMeshConVerts = tm.geom.OffMeshConnectionVerts()
-		params.OffMeshConRad = tm.geom.OffMeshConnectionRads()
-		params.OffMeshConDir = tm.geom.OffMeshConnectionDirs()
-		params.OffMeshConAreas = tm.geom.OffMeshConnectionAreas()
-		params.OffMeshConFlags = tm.geom.OffMeshConnectionFlags()
-		params.OffMeshConUserID = tm.geom.OffMeshConnectionId()
-		params.OffMeshConCount = tm.geom.OffMeshConnectionCount()
-		params.WalkableHeight = agentHeight
-		params.WalkableRadius = agentRadius
-		params.WalkableClimb = agentMaxClimb
-		params.TileX = tx
-		params.TileY = ty
-		params.TileLayer = 0
-		copy(params.BMin[:], tm.pmesh.BMin[:])
-		copy(params.BMax[:], tm.pmesh.BMax[:])
-		params.Cs = tm.cfg.Cs
-		params.Ch = tm.cfg.Ch
-		params.BuildBvTree = true
-
-		if navData, err = detour.CreateNavMeshData(&params); err != nil {
-			tm.ctx.Errorf("Could not build Detour navmesh: %v", err)
-			return nil
-		}
+	if p.NavData == nil {
+		return nil, nil
 	}
+	navData := p.NavData
 
 	tm.tileMemUsage = float32(len(navData)) / 1024.0
 
 	tm.ctx.StopTimer(recast.TimerTotal)
 	// Log performance stats.
 	recast.LogBuildTimes(tm.ctx, tm.ctx.AccumulatedTime(recast.TimerTotal))
-	tm.ctx.Progressf(">> Polymesh: %d vertices  %d polygons", tm.pmesh.NVerts, tm.pmesh.NPolys)
+	tm.ctx.Log(recast.LogProgress, ">> Polymesh:", recast.KV("verts", p.PMesh.NVerts), recast.KV("polys", p.PMesh.NPolys), recast.KV("bytes", len(navData)))
 	tm.tileBuildTime = tm.ctx.AccumulatedTime(recast.TimerTotal)
 
-	return navData
+	return navData, nil
 }
 
 func (tm *TileMesh) BuildTile(pos d3.Vec3) {
-	bmin := tm.geom.NavMeshBoundsMin()
-	bmax := tm.geom.NavMeshBoundsMax()
-
-	ts := tm.settings.TileSize * tm.settings.CellSize
-	tx := int32((pos[0] - bmin[0]) / ts)
-	ty := int32((pos[2] - bmin[2]) / ts)
-
-	tm.lastBuiltTileBMin[0] = bmin[0] + float32(tx)*ts
-	tm.lastBuiltTileBMin[1] = bmin[1]
-	tm.lastBuiltTileBMin[2] = bmin[2] + float32(ty)*ts
-
-	tm.lastBuiltTileBMax[0] = bmin[0] + float32(tx+1)*ts
-	tm.lastBuiltTileBMax[1] = bmax[1]
-	tm.lastBuiltTileBMax[2] = bmin[2] + float32(ty+1)*ts
+	tx, ty := tm.TilePos(pos)
 
 	tm.ctx.ResetLog()
-
-	data := tm.buildTileMesh(tx, ty, tm.lastBuiltTileBMin, tm.lastBuiltTileBMax)
-
-	// Remove any previous data (navmesh owns and deletes the data).
-	tm.navMesh.RemoveTile(tm.navMesh.TileRefAt(tx, ty, 0))
-
-	// Add tile, or leave the location empty.
-	if data != nil {
-		// Let the navmesh own the data.
-		status, _ := tm.navMesh.AddTile(data, detour.TileRef(0))
-		if detour.StatusFailed(status) {
-			data = nil
-		}
-	}
-
+	tm.rebuildTile(tx, ty)
 	tm.ctx.DumpLog("Build Tile (%d,%d):", tx, ty)
 }
 
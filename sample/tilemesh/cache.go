@@ -0,0 +1,156 @@
+package tilemesh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
+)
+
+// tileCacheVersion is part of the tile cache keys, it must be incremented
+// each time a modification of the build process changes the produced tiles.
+const tileCacheVersion = 1
+
+// TileCache is the interface implemented by the stores of built tiles.
+//
+// A tile cache allows to reuse the navmesh data of the tiles which input has
+// not changed since a previous build. The keys are computed from the input
+// geometry overlapping the tile and the build config, a key is thus always
+// associated with the same tile data.
+type TileCache interface {
+	// Get returns the tile data associated with key. The returned data is
+	// empty if the tile built for that key was empty. ok is false if key is
+	// not in the cache.
+	Get(key uint64) (data []byte, ok bool)
+
+	// Put stores the tile data associated with key. data may be empty.
+	Put(key uint64, data []byte) error
+}
+
+// SetCache sets the cache used to store and reuse built tiles during Build,
+// BuildTile and RebuildTiles. A nil cache disables caching.
+func (tm *TileMesh) SetCache(c TileCache) {
+	tm.cache = c
+}
+
+// DirCache is a TileCache storing each tile in a file of a directory, so that
+// the tiles can be reused between runs.
+type DirCache struct {
+	dir string
+}
+
+// NewDirCache returns a tile cache that stores the tiles in dir. dir is
+// created if it doesn't exist.
+func NewDirCache(dir string) (*DirCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirCache{dir: dir}, nil
+}
+
+func (dc *DirCache) path(key uint64) string {
+	return filepath.Join(dc.dir, fmt.Sprintf("%016x.tile", key))
+}
+
+// Get implements the TileCache interface.
+func (dc *DirCache) Get(key uint64) ([]byte, bool) {
+	data, err := ioutil.ReadFile(dc.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put implements the TileCache interface.
+//
+// The tile is first written to a temporary file then renamed, so that an
+// interrupted build never leaves a truncated tile in the cache.
+func (dc *DirCache) Put(key uint64, data []byte) error {
+	f, err := ioutil.TempFile(dc.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), dc.path(key))
+}
+
+// tileCacheKey computes the cache key of the tile at (tx, ty), from its input
+// geometry, the off-mesh connections starting or ending in the tile and the
+// build config, which must have been initialized with initTileConfig.
+func (tm *TileMesh) tileCacheKey(tx, ty int32) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, uint32(tileCacheVersion))
+	binary.Write(h, binary.LittleEndian, [2]int32{tx, ty})
+	binary.Write(h, binary.LittleEndian, tm.cfg)
+	binary.Write(h, binary.LittleEndian, int32(tm.partitionType))
+
+	// the agent dimensions are also stored in the tile header
+	hashFloats(h, tm.settings.AgentHeight, tm.settings.AgentRadius, tm.settings.AgentMaxClimb)
+
+	// the area definitions give the polygon flags
+	areas := tm.settings.Areas
+	if len(areas) == 0 {
+		areas = sample.DefaultAreas()
+	}
+	for _, a := range areas {
+		binary.Write(h, binary.LittleEndian, a.ID)
+		binary.Write(h, binary.LittleEndian, a.Flags)
+	}
+
+	// the build area boxes remove spans from the tile heightfield
+	for _, boxes := range [][]recast.BuildBox{tm.geom.IncludeBoxes(), tm.geom.ExcludeBoxes()} {
+		binary.Write(h, binary.LittleEndian, int32(len(boxes)))
+		binary.Write(h, binary.LittleEndian, boxes)
+	}
+
+	binary.Write(h, binary.LittleEndian, tm.tileInputHash(tx, ty))
+	binary.Write(h, binary.LittleEndian, tm.tileOffMeshConnectionsHash())
+	return h.Sum64()
+}
+
+// tileOffMeshConnectionsHash computes the hash of the off-mesh connections
+// which have at least one end point in the tile which config has been
+// initialized with initTileConfig, border included.
+func (tm *TileMesh) tileOffMeshConnectionsHash() uint64 {
+	var (
+		sum   uint64
+		verts = tm.geom.OffMeshConnectionVerts()
+		rads  = tm.geom.OffMeshConnectionRads()
+		dirs  = tm.geom.OffMeshConnectionDirs()
+		areas = tm.geom.OffMeshConnectionAreas()
+		flags = tm.geom.OffMeshConnectionFlags()
+		ids   = tm.geom.OffMeshConnectionId()
+	)
+	rmin := [2]float32{tm.cfg.BMin[0], tm.cfg.BMin[2]}
+	rmax := [2]float32{tm.cfg.BMax[0], tm.cfg.BMax[2]}
+	for i := int32(0); i < tm.geom.OffMeshConnectionCount(); i++ {
+		v := verts[i*6 : i*6+6]
+		p := [2]float32{v[0], v[2]}
+		q := [2]float32{v[3], v[5]}
+		if !rectsOverlap(p, p, rmin, rmax) && !rectsOverlap(q, q, rmin, rmax) {
+			continue
+		}
+		h := fnv.New64a()
+		hashFloats(h, v...)
+		hashFloats(h, rads[i])
+		binary.Write(h, binary.LittleEndian, dirs[i])
+		binary.Write(h, binary.LittleEndian, areas[i])
+		binary.Write(h, binary.LittleEndian, flags[i])
+		binary.Write(h, binary.LittleEndian, ids[i])
+		sum += h.Sum64()
+	}
+	return sum
+}
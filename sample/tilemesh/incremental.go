@@ -0,0 +1,253 @@
+package tilemesh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/math32"
+)
+
+// TileCoord represents the location of a tile in the navmesh tile grid.
+type TileCoord struct {
+	X, Y int32
+}
+
+// TileHashes returns, for each tile of the tile grid, a hash of the input
+// geometry that contributes to that tile.
+//
+// The hash of a tile takes into account all the triangles and convex volumes
+// that overlap the tile, including its border. Two tiles with the same hash
+// are built from the same input and produce the same tile data, as long as the
+// build settings don't change. The hash doesn't depend on the order in which
+// the triangles are stored, so tiles are not considered modified when only
+// unrelated parts of the input geometry change.
+func (tm *TileMesh) TileHashes() map[TileCoord]uint64 {
+	hashes := make(map[TileCoord]uint64)
+	if tm.geom.Mesh() == nil || tm.geom.ChunkyMesh() == nil {
+		return hashes
+	}
+
+	tw, th := tm.tileGridSize()
+	for y := int32(0); y < th; y++ {
+		for x := int32(0); x < tw; x++ {
+			hashes[TileCoord{x, y}] = tm.tileInputHash(x, y)
+		}
+	}
+	return hashes
+}
+
+// ChangedTiles returns the coordinates of the tiles which hash differs between
+// prev and cur, or that are only present in one of them.
+func ChangedTiles(prev, cur map[TileCoord]uint64) []TileCoord {
+	var changed []TileCoord
+	for tc, h := range cur {
+		if ph, ok := prev[tc]; !ok || ph != h {
+			changed = append(changed, tc)
+		}
+	}
+	for tc := range prev {
+		if _, ok := cur[tc]; !ok {
+			changed = append(changed, tc)
+		}
+	}
+	return changed
+}
+
+// RebuildTiles builds the specified tiles and replaces them in the navigation
+// mesh previously created with Build.
+//
+// Locations where there is no walkable area are left empty. RebuildTiles
+// returns the number of tiles that have been added to the navigation mesh,
+// and false if one of the tiles couldn't be built, in which case its location
+// is left empty too.
+//
+// If the context of the build context is done, RebuildTiles stops, returns
+// false, and the tiles that are not rebuilt keep their previous data.
+func (tm *TileMesh) RebuildTiles(tiles []TileCoord) (int, bool) {
+	var n int
+	ok := true
+	tm.ctx.ReportProgress("tiles", 0, len(tiles))
+	for i, tc := range tiles {
+		if tm.ctx.Err() != nil {
+			return n, false
+		}
+		added, err := tm.rebuildTile(tc.X, tc.Y)
+		if err != nil {
+			ok = false
+		}
+		if added {
+			n++
+		}
+		tm.ctx.ReportProgress("tiles", i+1, len(tiles))
+	}
+	return n, ok && tm.ctx.Err() == nil
+}
+
+// rebuildTile builds the tile at (tx, ty) and replaces the previous one, if
+// any, in the navigation mesh. It returns true if a tile has been added, and
+// an error if the tile couldn't be built or added.
+func (tm *TileMesh) rebuildTile(tx, ty int32) (bool, error) {
+	tm.lastBuiltTileBMin, tm.lastBuiltTileBMax = tm.tileBounds(tx, ty)
+
+	data, err := tm.buildTileMesh(tx, ty, tm.lastBuiltTileBMin, tm.lastBuiltTileBMax)
+	if tm.ctx.Err() != nil {
+		// keep the previous tile if the build has been interrupted.
+		return false, tm.ctx.Err()
+	}
+
+	// Remove any previous data (navmesh owns and deletes the data).
+	tm.navMesh.RemoveTile(tm.navMesh.TileRefAt(tx, ty, 0))
+
+	// Add tile, or leave the location empty.
+	if data == nil {
+		return false, err
+	}
+	// Let the navmesh own the data.
+	status, _ := tm.navMesh.AddTile(data, detour.TileRef(0))
+	if detour.StatusFailed(status) {
+		return false, fmt.Errorf("couldn't add tile (%d,%d), status 0x%x", tx, ty, uint32(status))
+	}
+	return true, nil
+}
+
+// tileGridSize returns the number of tiles along the x and z axis.
+func (tm *TileMesh) tileGridSize() (tw, th int32) {
+	bmin := tm.geom.NavMeshBoundsMin()
+	bmax := tm.geom.NavMeshBoundsMax()
+	gw, gh := recast.CalcGridSize(bmin, bmax, tm.settings.CellSize)
+	ts := int32(tm.settings.TileSize)
+	return (gw + ts - 1) / ts, (gh + ts - 1) / ts
+}
+
+// tileBounds returns the bounding box of the tile at (tx, ty), borders
+// excluded.
+func (tm *TileMesh) tileBounds(tx, ty int32) (bmin, bmax []float32) {
+	nbmin := tm.geom.NavMeshBoundsMin()
+	nbmax := tm.geom.NavMeshBoundsMax()
+	tcs := tm.settings.TileSize * tm.settings.CellSize
+
+	bmin = []float32{
+		nbmin[0] + float32(tx)*tcs,
+		nbmin[1],
+		nbmin[2] + float32(ty)*tcs,
+	}
+	bmax = []float32{
+		nbmin[0] + float32(tx+1)*tcs,
+		nbmax[1],
+		nbmin[2] + float32(ty+1)*tcs,
+	}
+	return bmin, bmax
+}
+
+// tileInputHash computes the hash of the triangles and convex volumes
+// overlapping the tile at (tx, ty), border included.
+//
+// The hashes of the individual triangles are summed so that the result doesn't
+// depend on their order.
+func (tm *TileMesh) tileInputHash(tx, ty int32) uint64 {
+	bmin, bmax := tm.tileBounds(tx, ty)
+
+	// expand the tile bounds by the border size, the same way buildTileMesh
+	// does, since the geometry in the border also affects the tile.
+	borderSize := int32(math32.Ceil(tm.settings.AgentRadius/tm.settings.CellSize)) + 3
+	border := float32(borderSize) * tm.settings.CellSize
+	rmin := [2]float32{bmin[0] - border, bmin[2] - border}
+	rmax := [2]float32{bmax[0] + border, bmax[2] + border}
+
+	var sum uint64
+
+	// hash the triangles
+	verts := tm.geom.Mesh().Verts()
+	cm := tm.geom.ChunkyMesh()
+	cid := make([]int32, cm.Nnodes)
+	ncid := cm.ChunksOverlappingRect(rmin, rmax, cid)
+	for i := 0; i < ncid; i++ {
+		node := &cm.Nodes[cid[i]]
+		for j := node.I; j < node.I+node.N; j++ {
+			tri := cm.Tris[j*3 : j*3+3]
+			if !triOverlapsRect(verts, tri, rmin, rmax) {
+				continue
+			}
+			h := fnv.New64a()
+			for _, vi := range tri {
+				hashFloats(h, verts[vi*3:vi*3+3]...)
+			}
+			area := recast.WalkableArea
+			if cm.Areas != nil {
+				area = cm.Areas[j]
+			}
+			h.Write([]byte{area})
+			sum += h.Sum64()
+		}
+	}
+
+	// hash the convex volumes
+	vols := tm.geom.ConvexVolumes()
+	for i := int32(0); i < tm.geom.ConvexVolumesCount(); i++ {
+		vol := &vols[i]
+		if !volumeOverlapsRect(vol, rmin, rmax) {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte("volume"))
+		hashFloats(h, vol.Verts[:]...)
+		hashFloats(h, vol.HMin, vol.HMax)
+		binary.Write(h, binary.LittleEndian, vol.NVerts)
+		binary.Write(h, binary.LittleEndian, vol.Area)
+		sum += h.Sum64()
+	}
+	return sum
+}
+
+// hashFloats writes the binary representation of fs into h.
+func hashFloats(h io.Writer, fs ...float32) {
+	var buf [4]byte
+	for _, f := range fs {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(f))
+		h.Write(buf[:])
+	}
+}
+
+// triOverlapsRect reports wether the xz-bounds of a triangle overlap the rect
+// defined by rmin and rmax.
+func triOverlapsRect(verts []float32, tri []int32, rmin, rmax [2]float32) bool {
+	v := verts[tri[0]*3:]
+	tmin := [2]float32{v[0], v[2]}
+	tmax := tmin
+	for _, vi := range tri[1:] {
+		v = verts[vi*3:]
+		tmin[0], tmax[0] = math32.Min(tmin[0], v[0]), math32.Max(tmax[0], v[0])
+		tmin[1], tmax[1] = math32.Min(tmin[1], v[2]), math32.Max(tmax[1], v[2])
+	}
+	return rectsOverlap(tmin, tmax, rmin, rmax)
+}
+
+// volumeOverlapsRect reports wether the xz-bounds of a convex volume overlap
+// the rect defined by rmin and rmax.
+func volumeOverlapsRect(vol *recast.ConvexVolume, rmin, rmax [2]float32) bool {
+	// as in recast.MarkConvexPolyArea, NVerts is the number of coordinates
+	n := vol.NVerts
+	if n > int32(len(vol.Verts)) {
+		n = int32(len(vol.Verts))
+	}
+	if n < 3 {
+		return false
+	}
+	vmin := [2]float32{vol.Verts[0], vol.Verts[2]}
+	vmax := vmin
+	for i := int32(3); i+2 < n; i += 3 {
+		vmin[0], vmax[0] = math32.Min(vmin[0], vol.Verts[i]), math32.Max(vmax[0], vol.Verts[i])
+		vmin[1], vmax[1] = math32.Min(vmin[1], vol.Verts[i+2]), math32.Max(vmax[1], vol.Verts[i+2])
+	}
+	return rectsOverlap(vmin, vmax, rmin, rmax)
+}
+
+func rectsOverlap(amin, amax, bmin, bmax [2]float32) bool {
+	return amin[0] <= bmax[0] && amax[0] >= bmin[0] &&
+		amin[1] <= bmax[1] && amax[1] >= bmin[1]
+}
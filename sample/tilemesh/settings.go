@@ -24,5 +24,6 @@ func DefaultSettings() recast.BuildSettings {
 		DetailSampleMaxError: float32(1),
 		PartitionType:        int32(sample.PartitionMonotone),
 		TileSize:             32,
+		Areas:                sample.DefaultAreas(),
 	}
 }
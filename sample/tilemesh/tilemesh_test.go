@@ -2,13 +2,19 @@ package tilemesh
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/arl/go-detour/detour"
 	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample"
 	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 )
 
 func check(t *testing.T, err error) {
@@ -35,7 +41,7 @@ func compareFiles(fn1, fn2 string) (bool, error) {
 		return false, err
 	}
 
-	f2, err = ioutil.ReadFile(fn2)
+	f2, err = readGolden(fn2, f1)
 	if err != nil {
 		return false, err
 	}
@@ -43,6 +49,47 @@ func compareFiles(fn1, fn2 string) (bool, error) {
 	return bytes.Equal(f1, f2), nil
 }
 
+// readGolden reads the golden navmesh file fn, to compare it to the navmesh
+// file data built.
+//
+// The golden files have 32-bit references so, when built with 64-bit
+// references (polyref64 build tag), the navmesh is decoded, which converts
+// them, then encoded again. Its maximum number of tiles and polygons per
+// tile, which depend on the reference size, are taken from built.
+func readGolden(fn string, built []byte) ([]byte, error) {
+	if detour.MaxTilePolyBits <= 22 {
+		// 32-bit references
+		return ioutil.ReadFile(fn)
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mesh, err := detour.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	builtMesh, err := detour.Decode(bytes.NewReader(built))
+	if err != nil {
+		return nil, err
+	}
+	mesh.Params.MaxTiles = builtMesh.Params.MaxTiles
+	mesh.Params.MaxPolys = builtMesh.Params.MaxPolys
+
+	tmp, err := ioutil.TempFile("", "golden")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err = mesh.SaveToFile(tmp.Name()); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(tmp.Name())
+}
+
 const testDataDir = "../../testdata/sample/tilemesh/"
 const OBJDir = "../../testdata/obj/"
 
@@ -350,3 +397,390 @@ func TestRaycastTileMesh(t *testing.T) {
 	}
 }
 */
+
+// memCache is an in-memory TileCache counting the cache hits.
+type memCache struct {
+	tiles map[uint64][]byte
+	hits  int
+}
+
+func (mc *memCache) Get(key uint64) ([]byte, bool) {
+	data, ok := mc.tiles[key]
+	if ok {
+		mc.hits++
+	}
+	return data, ok
+}
+
+func (mc *memCache) Put(key uint64, data []byte) error {
+	mc.tiles[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func TestTileCache(t *testing.T) {
+	path := OBJDir + "develer.obj"
+	meshBinPath := testDataDir + "develer.bin"
+	cache := &memCache{tiles: make(map[uint64][]byte)}
+
+	for i := 0; i < 2; i++ {
+		tileMesh := New(recast.NewBuildContext(false))
+		tileMesh.SetCache(cache)
+		r, err := os.Open(path)
+		check(t, err)
+		err = tileMesh.LoadGeometry(r)
+		r.Close()
+		check(t, err)
+
+		navMesh, ok := tileMesh.Build()
+		if !ok {
+			t.Fatalf("couldn't build navmesh for %v", path)
+		}
+
+		if i == 0 && cache.hits != 0 {
+			t.Fatalf("got %d cache hits on first build, want 0", cache.hits)
+		}
+		if i == 1 && cache.hits != len(cache.tiles) {
+			t.Fatalf("got %d cache hits on second build, want %d", cache.hits, len(cache.tiles))
+		}
+
+		outBin := "cache.bin"
+		check(t, navMesh.SaveToFile(outBin))
+		ok, err = compareFiles(outBin, meshBinPath)
+		os.Remove(outBin)
+		check(t, err)
+		if !ok {
+			t.Fatalf("build %d: %v and %v are different", i, outBin, meshBinPath)
+		}
+	}
+}
+
+func TestTileCacheFailure(t *testing.T) {
+	path := OBJDir + "develer.obj"
+	cache := &memCache{tiles: make(map[uint64][]byte)}
+
+	tileMesh := New(recast.NewBuildContext(false))
+	tileMesh.SetCache(cache)
+	r, err := os.Open(path)
+	check(t, err)
+	err = tileMesh.LoadGeometry(r)
+	r.Close()
+	check(t, err)
+
+	// the build of the non-empty tiles fails, detour only handles up to 6
+	// vertices per polygon.
+	settings := DefaultSettings()
+	settings.VertsPerPoly = 8
+	tileMesh.SetSettings(settings)
+	navMesh, _ := tileMesh.Build()
+	if navMesh != nil && navMesh.TileAt(0, 0, 0) != nil {
+		t.Fatalf("tiles should have failed to build")
+	}
+
+	// only the empty tiles are cached
+	if len(cache.tiles) == 0 {
+		t.Fatalf("empty tiles should be cached")
+	}
+	for key, data := range cache.tiles {
+		if len(data) != 0 {
+			t.Fatalf("tile 0x%x: got %d bytes in cache, want an empty tile", key, len(data))
+		}
+	}
+	d := sample.DeriveConfig(settings, tileMesh.InputGeom().NavMeshBoundsMin(), tileMesh.InputGeom().NavMeshBoundsMax())
+	if ntiles := int(d.TilesX * d.TilesZ); len(cache.tiles) >= ntiles {
+		t.Fatalf("got %d cached tiles out of %d, failed tiles should not be cached", len(cache.tiles), ntiles)
+	}
+}
+
+func TestCancelBuild(t *testing.T) {
+	path := OBJDir + "develer.obj"
+	meshBinPath := testDataDir + "develer.bin"
+	cache := &memCache{tiles: make(map[uint64][]byte)}
+
+	ctx := recast.NewBuildContext(true)
+	var nerrs int
+	ctx.AddLogSink(recast.LogSinkFunc(func(e recast.LogEntry) {
+		if e.Level == recast.LogError {
+			nerrs++
+		}
+	}))
+	tileMesh := New(ctx)
+	tileMesh.SetCache(cache)
+	r, err := os.Open(path)
+	check(t, err)
+	err = tileMesh.LoadGeometry(r)
+	r.Close()
+	check(t, err)
+
+	// cancel the build once 3 tiles are built.
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx.SetContext(c)
+	ctx.SetProgressFunc(func(p recast.Progress) {
+		if p.Done == 3 {
+			cancel()
+		}
+	})
+	if _, ok := tileMesh.Build(); ok {
+		t.Fatalf("canceled build should fail")
+	}
+	cerr, ok := ctx.Err().(*recast.CanceledError)
+	if !ok || cerr.Err != context.Canceled {
+		t.Fatalf("got error %v, want a *recast.CanceledError", ctx.Err())
+	}
+	if nerrs != 0 {
+		t.Errorf("got %d errors logged, a canceled build should not log errors", nerrs)
+	}
+	if len(cache.tiles) > 3 {
+		t.Errorf("%d tiles cached, want at most 3", len(cache.tiles))
+	}
+
+	// the next build is not affected.
+	ctx.SetContext(nil)
+	ctx.SetProgressFunc(nil)
+	navMesh, ok := tileMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh for %v", path)
+	}
+	outBin := "cancel.bin"
+	check(t, navMesh.SaveToFile(outBin))
+	ok, err = compareFiles(outBin, meshBinPath)
+	os.Remove(outBin)
+	check(t, err)
+	if !ok {
+		t.Fatalf("%v and %v are different", outBin, meshBinPath)
+	}
+}
+
+func TestGeomBatches(t *testing.T) {
+	path := OBJDir + "develer.obj"
+	meshBinPath := testDataDir + "develer.bin"
+
+	r, err := os.Open(path)
+	check(t, err)
+	obj := recast.NewMeshLoaderOBJ()
+	err = obj.Load(r)
+	r.Close()
+	check(t, err)
+
+	// add the mesh in 2 batches, plus one that is removed before the build
+	tileMesh := New(recast.NewBuildContext(false))
+	geom := tileMesh.InputGeom()
+	half := (obj.TriCount() / 2) * 3
+	_, err = geom.AddBatch(obj.Verts(), obj.Tris()[:half], nil, nil)
+	check(t, err)
+	xform := recast.IdentityTransform
+	xform[13] = 2
+	h, err := geom.AddBatch(obj.Verts(), obj.Tris()[:half], &xform, nil)
+	check(t, err)
+	_, err = geom.AddBatch(obj.Verts(), obj.Tris()[half:], nil, nil)
+	check(t, err)
+	if !geom.RemoveBatch(h) {
+		t.Fatalf("couldn't remove batch %v", h)
+	}
+	if geom.RemoveBatch(h) {
+		t.Fatalf("batch %v removed twice", h)
+	}
+
+	navMesh, ok := tileMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh for %v", path)
+	}
+	outBin := "batches.bin"
+	check(t, navMesh.SaveToFile(outBin))
+	ok, err = compareFiles(outBin, meshBinPath)
+	os.Remove(outBin)
+	check(t, err)
+	if !ok {
+		t.Fatalf("%v and %v are different", outBin, meshBinPath)
+	}
+}
+
+func TestDeriveConfig(t *testing.T) {
+	ctx := recast.NewBuildContext(false)
+	tileMesh := New(ctx)
+	r, err := os.Open(OBJDir + "nav_test.obj")
+	check(t, err)
+	defer r.Close()
+	check(t, tileMesh.LoadGeometry(r))
+	navMesh, ok := tileMesh.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh")
+	}
+
+	geom := tileMesh.InputGeom()
+	d := sample.DeriveConfig(tileMesh.settings, geom.NavMeshBoundsMin(), geom.NavMeshBoundsMax())
+	if d.NavMeshParams != navMesh.Params {
+		t.Errorf("got params %+v, want %+v", d.NavMeshParams, navMesh.Params)
+	}
+	if d.TilesX != 10 || d.TilesZ != 9 {
+		t.Errorf("got %d x %d tiles, want 10 x 9", d.TilesX, d.TilesZ)
+	}
+	if d.Config.TileSize != 32 || d.Config.Width != 32+2*d.Config.BorderSize {
+		t.Errorf("got tile size %d and width %d, want 32 and 32 + 2 borders", d.Config.TileSize, d.Config.Width)
+	}
+
+	sug := sample.SuggestSettings(0.6, geom.NavMeshBoundsMin(), geom.NavMeshBoundsMax())
+	if sug.CellSize != 0.3 || sug.CellHeight != 0.15 || sug.TileSize != 32 {
+		t.Errorf("got suggestion %+v, want {0.3 0.15 32}", sug)
+	}
+
+	// a huge world needs larger tiles, to keep enough bits of the references
+	// for the polygons (at least 10), unless references have 64 bits.
+	sug = sample.SuggestSettings(0.6, []float32{0, 0, 0}, []float32{5000, 10, 5000})
+	ntiles := math32.NextPow2(uint32(math32.Sqr(math32.Ceil(5000 / 0.3 / 32))))
+	fits := int32(math32.Ilog2(ntiles)) <= math32.MinInt32(detour.MaxTileBits, detour.MaxTilePolyBits-10)
+	if fits && sug.TileSize != 32 {
+		t.Errorf("got tile size %v for a huge world, want 32", sug.TileSize)
+	} else if !fits && sug.TileSize <= 32 {
+		t.Errorf("got tile size %v for a huge world, want more than 32", sug.TileSize)
+	}
+}
+
+func TestRebuildChangedTiles(t *testing.T) {
+	r, err := os.Open(OBJDir + "develer.obj")
+	check(t, err)
+	obj := recast.NewMeshLoaderOBJ()
+	err = obj.Load(r)
+	r.Close()
+	check(t, err)
+
+	newTileMesh := func() *TileMesh {
+		tm := New(recast.NewBuildContext(false))
+		_, err := tm.InputGeom().AddBatch(obj.Verts(), obj.Tris(), nil, nil)
+		check(t, err)
+		return tm
+	}
+	tileMesh := newTileMesh()
+	geom := tileMesh.InputGeom()
+
+	// a walkable platform in the middle tile, far enough from its borders
+	// to only affect this tile.
+	tw, th := tileMesh.tileGridSize()
+	tc := TileCoord{tw / 2, th / 2}
+	tmin, tmax := tileMesh.tileBounds(tc.X, tc.Y)
+	platform := func(off float32) []float32 {
+		x, y, z := tmin[0]+off, (tmin[1]+tmax[1])/2, tmin[2]+off
+		return []float32{x, y, z, x, y, z + 3, x + 3, y, z}
+	}
+	h, err := geom.AddBatch(platform(2), []int32{0, 1, 2}, nil, nil)
+	check(t, err)
+	if _, ok := tileMesh.Build(); !ok {
+		t.Fatalf("couldn't build navmesh")
+	}
+	prev := tileMesh.TileHashes()
+
+	// move the platform within the tile
+	geom.RemoveBatch(h)
+	_, err = geom.AddBatch(platform(3), []int32{0, 1, 2}, nil, nil)
+	check(t, err)
+	cur := tileMesh.TileHashes()
+	changed := ChangedTiles(prev, cur)
+	if len(changed) != 1 || changed[0] != tc {
+		t.Fatalf("got changed tiles %v, want [%v]", changed, tc)
+	}
+	if len(ChangedTiles(cur, cur)) != 0 {
+		t.Fatalf("identical hashes should have no changed tiles")
+	}
+
+	// full build of the same geometry, for reference
+	ref := newTileMesh()
+	_, err = ref.InputGeom().AddBatch(platform(3), []int32{0, 1, 2}, nil, nil)
+	check(t, err)
+	full, ok := ref.Build()
+	if !ok {
+		t.Fatalf("couldn't build navmesh")
+	}
+	if compareTiles(tileMesh.NavMesh(), full, tc.X, tc.Y) == nil {
+		t.Fatalf("tile %v should differ before being rebuilt", tc)
+	}
+
+	// the rebuilt navmesh is the same as the full build
+	if n, ok := tileMesh.RebuildTiles(changed); !ok || n != 1 {
+		t.Fatalf("got %d rebuilt tiles (ok=%v), want 1", n, ok)
+	}
+	for y := int32(0); y < th; y++ {
+		for x := int32(0); x < tw; x++ {
+			if err := compareTiles(tileMesh.NavMesh(), full, x, y); err != nil {
+				t.Fatalf("rebuilt navmesh differs from the full build: %v", err)
+			}
+		}
+	}
+}
+
+// compareTiles checks that the tiles at (x, y) of the navmeshes m1 and m2 have
+// the same content and are connected to the same polygons.
+//
+// Tiles, and so polygon references, may be stored in different slots of the
+// navmeshes, and have different salts, so the links are compared by the
+// location of the polygon they lead to.
+func compareTiles(m1, m2 *detour.NavMesh, x, y int32) error {
+	t1, t2 := m1.TileAt(x, y, 0), m2.TileAt(x, y, 0)
+	if t1 == nil || t2 == nil {
+		if t1 != t2 {
+			return fmt.Errorf("tile (%d, %d): only present in one navmesh", x, y)
+		}
+		return nil
+	}
+
+	if len(t1.Polys) != len(t2.Polys) {
+		return fmt.Errorf("tile (%d, %d): got %d polys, want %d", x, y, len(t1.Polys), len(t2.Polys))
+	}
+	for i := range t1.Polys {
+		p1, p2 := t1.Polys[i], t2.Polys[i]
+		p1.FirstLink, p2.FirstLink = 0, 0
+		if p1 != p2 {
+			return fmt.Errorf("tile (%d, %d): poly %d differs, got %+v, want %+v", x, y, i, p1, p2)
+		}
+		l1, l2 := polyLinks(m1, t1, i), polyLinks(m2, t2, i)
+		if !reflect.DeepEqual(l1, l2) {
+			return fmt.Errorf("tile (%d, %d): poly %d links differ, got %v, want %v", x, y, i, l1, l2)
+		}
+	}
+	if !reflect.DeepEqual(t1.Verts, t2.Verts) ||
+		!reflect.DeepEqual(t1.DetailMeshes, t2.DetailMeshes) ||
+		!reflect.DeepEqual(t1.DetailVerts, t2.DetailVerts) ||
+		!reflect.DeepEqual(t1.DetailTris, t2.DetailTris) ||
+		!reflect.DeepEqual(t1.BvTree, t2.BvTree) {
+		return fmt.Errorf("tile (%d, %d): tile data differs", x, y)
+	}
+	return nil
+}
+
+// polyLink is a link of a polygon, identified by the location of the polygon
+// it leads to rather than by its reference.
+type polyLink struct {
+	x, y       int32
+	poly       uint32
+	edge, side uint8
+	bmin, bmax uint8
+}
+
+// polyLinks returns the sorted links of the i-th polygon of tile.
+func polyLinks(m *detour.NavMesh, tile *detour.MeshTile, i int) []polyLink {
+	var links []polyLink
+	for li := tile.Polys[i].FirstLink; li != 0xffffffff; li = tile.Links[li].Next {
+		l := &tile.Links[li]
+		var (
+			salt, it, ip uint32
+			nei          *detour.MeshTile
+			poly         *detour.Poly
+		)
+		m.DecodePolyID(l.Ref, &salt, &it, &ip)
+		m.TileAndPolyByRefUnsafe(l.Ref, &nei, &poly)
+		links = append(links, polyLink{nei.Header.X, nei.Header.Y, ip, l.Edge, l.Side, l.BMin, l.BMax})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i], links[j]
+		if a.edge != b.edge {
+			return a.edge < b.edge
+		}
+		if a.x != b.x {
+			return a.x < b.x
+		}
+		if a.y != b.y {
+			return a.y < b.y
+		}
+		return a.poly < b.poly
+	})
+	return links
+}